@@ -0,0 +1,201 @@
+//Package aead encrypts message bodies held in memory segments with AES-256-GCM or ChaCha20-Poly1305.
+//
+//A sealed body is laid out as follows, integers are little endian:
+//
+//	size      field
+//	4         key id
+//	16        random salt
+//	          chunks of ChunkSize bytes of plaintext, each followed by its 16 bytes tag
+//
+//The last chunk is always shorter than ChunkSize, it's empty if the plaintext is a multiple of ChunkSize,
+//so a body truncated at a chunk boundary is detected. Chunks are sealed and opened one at a time,
+//a large body is never flattened.
+//
+//Nonces are managed by the package: every body is sealed with its own key, derived with HKDF-SHA256
+//from the secret and the random salt, and the nonce of a chunk is its index plus a last chunk marker.
+//The frame header is authenticated along with the body, see frame.Frame.AssociatedData.
+package aead
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+)
+
+const (
+	ChunkSize = 16 * 1024
+	SaltSize  = 16
+	//Overhead is the size of a tag.
+	Overhead = 16
+	//size of the key id and the salt in front of the chunks.
+	prefixSize = 4 + SaltSize
+	nonceSize  = 12
+)
+
+var (
+	ErrUnsupportedAlgorithm = fmt.Errorf("aead: unsupported algorithm")
+	ErrInvalidKeySize       = fmt.Errorf("aead: invalid key size.")
+	ErrUnknownKey           = fmt.Errorf("aead: unknown key")
+	ErrDuplicateKey         = fmt.Errorf("aead: duplicate key")
+	ErrNoPrimaryKey         = fmt.Errorf("aead: no primary key.")
+	ErrPrimaryKey           = fmt.Errorf("aead: cannot remove the primary key.")
+	ErrTruncated            = fmt.Errorf("aead: sealed body is truncated.")
+	ErrAuthentication       = fmt.Errorf("aead: message authentication failed.")
+	ErrNotEncrypted         = fmt.Errorf("aead: frame is not encrypted.")
+)
+
+//Sealer seals and opens bodies with the keys of a keyring, it's safe for concurrent use.
+type Sealer struct {
+	keyring Keyring
+	//scratch buffers of a sealed chunk.
+	buffers sync.Pool
+}
+
+func NewSealer(kr Keyring) *Sealer {
+	s := &Sealer{keyring: kr}
+	s.buffers.New = func() interface{} {
+		buf := make([]byte, ChunkSize+Overhead)
+		return &buf
+	}
+	return s
+}
+
+func chunkNonce(nonce []byte, index uint64, last bool) {
+	binary.BigEndian.PutUint64(nonce, index)
+	nonce[8], nonce[9], nonce[10], nonce[11] = 0, 0, 0, 0
+	if last {
+		nonce[11] = 1
+	}
+}
+
+//Seal reads the plaintext from src and writes the sealed body into dst with the primary key.
+func (s *Sealer) Seal(dst memory.MemorySegmentProxyer, src io.Reader, associatedData []byte) error {
+	k, err := s.keyring.Primary()
+	if err != nil {
+		return err
+	}
+	var prefix [prefixSize]byte
+	binary.LittleEndian.PutUint32(prefix[:4], k.ID)
+	if _, err := io.ReadFull(rand.Reader, prefix[4:]); err != nil {
+		return err
+	}
+	c, err := k.messageAEAD(prefix[4:])
+	if err != nil {
+		return err
+	}
+	if err := dst.WriteMemory(prefix[:]); err != nil {
+		return err
+	}
+
+	bufp := s.buffers.Get().(*[]byte)
+	defer s.buffers.Put(bufp)
+	buf := *bufp
+	var nonce [nonceSize]byte
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(src, buf[:ChunkSize])
+		last := n < ChunkSize
+		if last && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		chunkNonce(nonce[:], index, last)
+		if err := dst.WriteMemory(c.Seal(buf[:0], nonce[:], buf[:n], associatedData)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+//Open reads a sealed body from src, verifies it and writes the plaintext into dst.
+//Chunks are verified one at a time, so on error dst may hold the plaintext of the chunks verified so far.
+func (s *Sealer) Open(dst memory.MemorySegmentProxyer, src io.Reader, associatedData []byte) error {
+	var prefix [prefixSize]byte
+	if _, err := io.ReadFull(src, prefix[:]); err != nil {
+		return ErrTruncated
+	}
+	k, err := s.keyring.Key(binary.LittleEndian.Uint32(prefix[:4]))
+	if err != nil {
+		return err
+	}
+	c, err := k.messageAEAD(prefix[4:])
+	if err != nil {
+		return err
+	}
+
+	bufp := s.buffers.Get().(*[]byte)
+	defer s.buffers.Put(bufp)
+	buf := *bufp
+	var nonce [nonceSize]byte
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(src, buf)
+		if err == io.EOF {
+			//the last chunk holds at least its tag.
+			return ErrTruncated
+		}
+		last := n < len(buf)
+		if last && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		chunkNonce(nonce[:], index, last)
+		plaintext, err := c.Open(buf[:0], nonce[:], buf[:n], associatedData)
+		if err != nil {
+			return ErrAuthentication
+		}
+		if err := dst.WriteMemory(plaintext); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+//SealProxy seals src into a new proxy drawn from mp, src is left untouched.
+func (s *Sealer) SealProxy(mp *memory.MemoryProvider, src memory.MemorySegmentProxyer, associatedData []byte) (memory.MemorySegmentProxyer, error) {
+	dst := mp.NewSegmentProxy()
+	if err := s.Seal(dst, src.NewReader(), associatedData); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
+
+//OpenProxy opens src into a new proxy drawn from mp, nothing is returned unless the whole body was verified.
+func (s *Sealer) OpenProxy(mp *memory.MemoryProvider, src memory.MemorySegmentProxyer, associatedData []byte) (memory.MemorySegmentProxyer, error) {
+	dst := mp.NewSegmentProxy()
+	if err := s.Open(dst, src.NewReader(), associatedData); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
+
+//WriteFrame writes a frame whose body is body sealed with the primary key, FLAG_ENCRYPTED is added to flags
+//and the header is authenticated along with the body.
+func (s *Sealer) WriteFrame(fw *frame.FrameWriter, messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer) error {
+	return fw.WriteFrame(messageType, correlationID, flags|frame.FLAG_ENCRYPTED, func(w memory.MemorySegmentProxyer) error {
+		return s.Seal(w, body.NewReader(), fw.Frame().AssociatedData())
+	})
+}
+
+//OpenFrame replaces the sealed body of f by its plaintext and clears FLAG_ENCRYPTED from its flags,
+//the sealed body goes back to the pool. A compressed body MUST be opened before being decompressed.
+func (s *Sealer) OpenFrame(mp *memory.MemoryProvider, f *frame.Frame) error {
+	if f.Flags&frame.FLAG_ENCRYPTED == 0 {
+		return ErrNotEncrypted
+	}
+	body, err := s.OpenProxy(mp, f.Body, f.AssociatedData())
+	if err != nil {
+		return err
+	}
+	f.Body.Close()
+	f.Body = body
+	f.Flags &^= frame.FLAG_ENCRYPTED
+	return nil
+}
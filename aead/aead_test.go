@@ -0,0 +1,169 @@
+package aead
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gomsg/compress"
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type AEADSuite struct{}
+
+var _ = Suite(&AEADSuite{})
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(4*1024*1024, 4096)
+	return mp
+}
+
+func newKeyring(c *C, algorithm Algorithm) *MemoryKeyring {
+	kr := NewMemoryKeyring()
+	_, err := kr.Rotate(algorithm)
+	c.Assert(err, IsNil)
+	return kr
+}
+
+func newBody(c *C, mp *memory.MemoryProvider, size int) (memory.MemorySegmentProxyer, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(data), IsNil)
+	return msp, data
+}
+
+func readAll(c *C, msp memory.MemorySegmentProxyer) []byte {
+	data, err := ioutil.ReadAll(msp.NewReader())
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (s *AEADSuite) Test_RoundTrip(c *C) {
+	mp := newProvider()
+	ad := []byte("header")
+	for _, algorithm := range []Algorithm{AES_256_GCM, CHACHA20_POLY1305} {
+		sealer := NewSealer(newKeyring(c, algorithm))
+		for _, size := range []int{0, 100, ChunkSize, 3*ChunkSize + 5} {
+			body, data := newBody(c, mp, size)
+			sealed, err := sealer.SealProxy(mp, body, ad)
+			c.Assert(err, IsNil)
+			chunks := size/ChunkSize + 1
+			c.Assert(sealed.GetLength(), Equals, prefixSize+size+chunks*Overhead)
+
+			opened, err := sealer.OpenProxy(mp, sealed, ad)
+			c.Assert(err, IsNil)
+			c.Assert(bytes.Equal(readAll(c, opened), data), Equals, true, Commentf("%s %d", algorithm, size))
+
+			_, err = sealer.OpenProxy(mp, sealed, []byte("other"))
+			c.Assert(err, Equals, ErrAuthentication)
+			body.Close()
+			sealed.Close()
+			opened.Close()
+		}
+	}
+}
+
+func (s *AEADSuite) Test_Tampering(c *C) {
+	mp := newProvider()
+	sealer := NewSealer(newKeyring(c, AES_256_GCM))
+	body, _ := newBody(c, mp, 2*ChunkSize+10)
+	sealed, err := sealer.SealProxy(mp, body, nil)
+	c.Assert(err, IsNil)
+	data := readAll(c, sealed)
+
+	open := func(data []byte) error {
+		return sealer.Open(mp.NewSegmentProxy(), bytes.NewReader(data), nil)
+	}
+	c.Assert(open(data), IsNil)
+	flipped := append([]byte{}, data...)
+	flipped[prefixSize+ChunkSize+10] ^= 1
+	c.Assert(open(flipped), Equals, ErrAuthentication)
+	//truncated bodies and reordered chunks.
+	chunk := ChunkSize + Overhead
+	c.Assert(open(data[:prefixSize+2*chunk]), Equals, ErrTruncated)
+	c.Assert(open(data[:prefixSize+chunk]), Equals, ErrTruncated)
+	c.Assert(open(data[:prefixSize+chunk+100]), Equals, ErrAuthentication)
+	swapped := append(append(append([]byte{}, data[:prefixSize]...), data[prefixSize+chunk:prefixSize+2*chunk]...), data[prefixSize:prefixSize+chunk]...)
+	swapped = append(swapped, data[prefixSize+2*chunk:]...)
+	c.Assert(open(swapped), Equals, ErrAuthentication)
+	c.Assert(open(data[:10]), Equals, ErrTruncated)
+
+	//every body has its own salt.
+	sealed2, err := sealer.SealProxy(mp, body, nil)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(readAll(c, sealed2)[4:prefixSize], data[4:prefixSize]), Equals, false)
+}
+
+func (s *AEADSuite) Test_KeyRotation(c *C) {
+	mp := newProvider()
+	kr := newKeyring(c, AES_256_GCM)
+	sealer := NewSealer(kr)
+	body, data := newBody(c, mp, 1000)
+	old, err := sealer.SealProxy(mp, body, nil)
+	c.Assert(err, IsNil)
+
+	k, err := kr.Rotate(CHACHA20_POLY1305)
+	c.Assert(err, IsNil)
+	c.Assert(k.ID, Equals, uint32(2))
+	sealed, err := sealer.SealProxy(mp, body, nil)
+	c.Assert(err, IsNil)
+	c.Assert(readAll(c, sealed)[0], Equals, byte(2))
+
+	for _, msp := range []memory.MemorySegmentProxyer{old, sealed} {
+		opened, err := sealer.OpenProxy(mp, msp, nil)
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(readAll(c, opened), data), Equals, true)
+	}
+
+	c.Assert(kr.RemoveKey(2), Equals, ErrPrimaryKey)
+	c.Assert(kr.RemoveKey(1), IsNil)
+	_, err = sealer.OpenProxy(mp, old, nil)
+	c.Assert(errors.Is(err, ErrUnknownKey), Equals, true)
+
+	c.Assert(errors.Is(kr.AddKey(k), ErrDuplicateKey), Equals, true)
+	_, err = NewKey(3, AES_256_GCM, []byte("short"))
+	c.Assert(err, Equals, ErrInvalidKeySize)
+	_, err = NewKey(3, Algorithm(9), make([]byte, KeySize))
+	c.Assert(errors.Is(err, ErrUnsupportedAlgorithm), Equals, true)
+	_, err = NewSealer(NewMemoryKeyring()).SealProxy(mp, body, nil)
+	c.Assert(err, Equals, ErrNoPrimaryKey)
+}
+
+func (s *AEADSuite) Test_Frame(c *C) {
+	mp := newProvider()
+	sealer := NewSealer(newKeyring(c, CHACHA20_POLY1305))
+	body, data := newBody(c, mp, 3*ChunkSize)
+	compressed, err := compress.CompressProxy(mp, compress.NewSnappy(), body)
+	c.Assert(err, IsNil)
+
+	msp := mp.NewSegmentProxy()
+	fw := frame.NewFrameWriter(msp)
+	c.Assert(sealer.WriteFrame(fw, 5, 6, frame.FLAG_COMPRESSION_SNAPPY, compressed), IsNil)
+	wire := msp.GetBuffer()
+
+	f, err := frame.NewFrameReader(bytes.NewReader(wire), mp).ReadFrame()
+	c.Assert(err, IsNil)
+	c.Assert(f.Flags&frame.FLAG_ENCRYPTED, Equals, frame.FLAG_ENCRYPTED)
+	c.Assert(sealer.OpenFrame(mp, f), IsNil)
+	c.Assert(sealer.OpenFrame(mp, f), Equals, ErrNotEncrypted)
+	c.Assert(compress.DecompressFrame(mp, f, 0), IsNil)
+	c.Assert(bytes.Equal(readAll(c, f.Body), data), Equals, true)
+	f.Close()
+
+	//the checksum only covers the body, the message type is authenticated by the AEAD.
+	wire[8] ^= 1
+	f, err = frame.NewFrameReader(bytes.NewReader(wire), mp).ReadFrame()
+	c.Assert(err, IsNil)
+	c.Assert(sealer.OpenFrame(mp, f), Equals, ErrAuthentication)
+	f.Close()
+}
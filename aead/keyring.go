@@ -0,0 +1,174 @@
+package aead
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+type Algorithm uint8
+
+const (
+	AES_256_GCM Algorithm = iota + 1
+	CHACHA20_POLY1305
+)
+
+//KeySize is the size of the secret of every algorithm.
+const KeySize = 32
+
+func (a Algorithm) String() string {
+	switch a {
+	case AES_256_GCM:
+		return "AES-256-GCM"
+	case CHACHA20_POLY1305:
+		return "ChaCha20-Poly1305"
+	}
+	return fmt.Sprintf("Algorithm(%d)", uint8(a))
+}
+
+//Key is a secret identified by its key ID, which is written in front of every body sealed with it.
+type Key struct {
+	ID        uint32
+	Algorithm Algorithm
+	secret    []byte
+}
+
+func NewKey(id uint32, algorithm Algorithm, secret []byte) (*Key, error) {
+	if algorithm != AES_256_GCM && algorithm != CHACHA20_POLY1305 {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+	if len(secret) != KeySize {
+		return nil, ErrInvalidKeySize
+	}
+	return &Key{ID: id, Algorithm: algorithm, secret: append([]byte{}, secret...)}, nil
+}
+
+//GenerateKey returns a key with a random secret.
+func GenerateKey(id uint32, algorithm Algorithm) (*Key, error) {
+	secret := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	return NewKey(id, algorithm, secret)
+}
+
+//messageAEAD derives the key of a single message from the secret and the salt of the message,
+//so the nonces only need to be unique within a message.
+func (k *Key) messageAEAD(salt []byte) (cipher.AEAD, error) {
+	subkey := make([]byte, KeySize)
+	kdf := hkdf.New(sha256.New, k.secret, salt, []byte("gomsg aead "+k.Algorithm.String()))
+	if _, err := io.ReadFull(kdf, subkey); err != nil {
+		return nil, err
+	}
+	if k.Algorithm == CHACHA20_POLY1305 {
+		return chacha20poly1305.New(subkey)
+	}
+	block, err := aes.NewCipher(subkey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//Keyring provides the keys for sealing and opening bodies.
+type Keyring interface {
+	//Primary returns the key new bodies are sealed with.
+	Primary() (*Key, error)
+	//Key returns the key of a key ID read from a sealed body.
+	Key(id uint32) (*Key, error)
+}
+
+//MemoryKeyring is a Keyring holding its keys in memory.
+//
+//Keys are rotated by adding a new key and making it the primary one, bodies sealed with the previous keys
+//can still be opened until those keys are removed.
+type MemoryKeyring struct {
+	keys    map[uint32]*Key
+	primary *Key
+	sync.RWMutex
+}
+
+func NewMemoryKeyring() *MemoryKeyring {
+	return &MemoryKeyring{keys: make(map[uint32]*Key)}
+}
+
+//AddKey adds a key, it becomes the primary key if there isn't any yet.
+func (kr *MemoryKeyring) AddKey(k *Key) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if _, ok := kr.keys[k.ID]; ok {
+		return fmt.Errorf("%w %d", ErrDuplicateKey, k.ID)
+	}
+	kr.keys[k.ID] = k
+	if kr.primary == nil {
+		kr.primary = k
+	}
+	return nil
+}
+
+func (kr *MemoryKeyring) SetPrimary(id uint32) error {
+	kr.Lock()
+	defer kr.Unlock()
+	k, ok := kr.keys[id]
+	if !ok {
+		return fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	kr.primary = k
+	return nil
+}
+
+//RemoveKey forgets a retired key, the primary key cannot be removed.
+func (kr *MemoryKeyring) RemoveKey(id uint32) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if kr.primary != nil && kr.primary.ID == id {
+		return ErrPrimaryKey
+	}
+	delete(kr.keys, id)
+	return nil
+}
+
+//Rotate generates a key with the next key ID and makes it the primary key.
+func (kr *MemoryKeyring) Rotate(algorithm Algorithm) (*Key, error) {
+	kr.Lock()
+	defer kr.Unlock()
+	id := uint32(1)
+	for kid := range kr.keys {
+		if kid >= id {
+			id = kid + 1
+		}
+	}
+	k, err := GenerateKey(id, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	kr.keys[id] = k
+	kr.primary = k
+	return k, nil
+}
+
+func (kr *MemoryKeyring) Primary() (*Key, error) {
+	kr.RLock()
+	defer kr.RUnlock()
+	if kr.primary == nil {
+		return nil, ErrNoPrimaryKey
+	}
+	return kr.primary, nil
+}
+
+func (kr *MemoryKeyring) Key(id uint32) (*Key, error) {
+	kr.RLock()
+	defer kr.RUnlock()
+	k, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	return k, nil
+}
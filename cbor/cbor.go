@@ -0,0 +1,127 @@
+//Package cbor implements the Concise Binary Object Representation (RFC 8949)
+//on top of the pooled memory segments of the memory package.
+package cbor
+
+import (
+	"fmt"
+	"math"
+)
+
+//major types, stored in the high-order 3 bits of the initial byte.
+const (
+	majorTypeUnsignedInt byte = iota << 5
+	majorTypeNegativeInt
+	majorTypeByteString
+	majorTypeTextString
+	majorTypeArray
+	majorTypeMap
+	majorTypeTag
+	majorTypeSimple
+)
+
+const (
+	additionalInfoMask byte = 0x1f
+
+	additionalInfoUint8      byte = 24
+	additionalInfoUint16     byte = 25
+	additionalInfoUint32     byte = 26
+	additionalInfoUint64     byte = 27
+	additionalInfoIndefinite byte = 31
+)
+
+const (
+	simpleFalse     byte = 20
+	simpleTrue      byte = 21
+	simpleNull      byte = 22
+	simpleUndefined byte = 23
+	simpleFloat16   byte = 25
+	simpleFloat32   byte = 26
+	simpleFloat64   byte = 27
+
+	breakCode = majorTypeSimple | additionalInfoIndefinite
+)
+
+const (
+	//TagPositiveBignum is the tag number of unsigned bignums.
+	TagPositiveBignum uint64 = 2
+	//TagNegativeBignum is the tag number of negative bignums.
+	TagNegativeBignum uint64 = 3
+)
+
+var (
+	ErrIndefiniteInCanonicalMode = fmt.Errorf("cbor: indefinite-length items are not allowed in canonical mode.")
+	ErrUnexpectedBreak           = fmt.Errorf("cbor: unexpected break code.")
+	ErrInvalidSimpleValue        = fmt.Errorf("cbor: simple values 24..31 cannot be encoded in two bytes.")
+	ErrMaxNestedLevelsExceeded   = fmt.Errorf("cbor: exceeded max nested levels.")
+	ErrMaxLengthExceeded         = fmt.Errorf("cbor: item length exceeded max length.")
+)
+
+//Tag represents a tagged data item (major type 6) which has no native Go representation.
+type Tag struct {
+	Number  uint64
+	Content interface{}
+}
+
+//SimpleValue represents a simple value (major type 7) other than false, true, null and undefined.
+type SimpleValue byte
+
+//Undefined represents the CBOR undefined simple value.
+type Undefined struct{}
+
+//float32ToFloat16 returns the IEEE 754 half-precision bits of f,
+//the second returned value is false when f cannot be represented exactly.
+func float32ToFloat16(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int((bits >> 23) & 0xff)
+	mant := bits & 0x7fffff
+	switch {
+	case exp == 0xff:
+		if mant == 0 {
+			return sign | 0x7c00, true
+		}
+		//only the quiet NaN without payload survives the conversion.
+		if mant == 0x400000 {
+			return sign | 0x7e00, true
+		}
+		return 0, false
+	case exp == 0:
+		//float32 subnormals are far below the half-precision range.
+		return sign, mant == 0
+	}
+	e := exp - 127
+	if e >= -14 && e <= 15 {
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(e+15)<<10 | uint16(mant>>13), true
+	}
+	if e >= -24 && e < -14 {
+		//subnormal half-precision, value = m * 2^-24.
+		full := mant | 0x800000
+		shift := uint(-e - 1)
+		if full&(1<<shift-1) != 0 {
+			return 0, false
+		}
+		return sign | uint16(full>>shift), true
+	}
+	return 0, false
+}
+
+//float16ToFloat32 widens IEEE 754 half-precision bits.
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
@@ -0,0 +1,299 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"io"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type CBOR struct{}
+
+var _ = Suite(&CBOR{})
+
+func bigInt(s string) *big.Int {
+	v, _ := new(big.Int).SetString(s, 10)
+	return v
+}
+
+//appendixA holds the examples of encoded CBOR data items from RFC 8949 Appendix A.
+//
+//	*  canonical - re-encoding the decoded value with a canonical encoder gives back the same bytes.
+//	*  preferred - re-encoding the decoded value with a default encoder gives back the same bytes.
+//	*  otherwise the vector uses indefinite-length items and only the decoded value is checked.
+var appendixA = []struct {
+	hex       string
+	value     interface{}
+	canonical bool
+	preferred bool
+}{
+	{"00", uint64(0), true, true},
+	{"01", uint64(1), true, true},
+	{"0a", uint64(10), true, true},
+	{"17", uint64(23), true, true},
+	{"1818", uint64(24), true, true},
+	{"1819", uint64(25), true, true},
+	{"1864", uint64(100), true, true},
+	{"1903e8", uint64(1000), true, true},
+	{"1a000f4240", uint64(1000000), true, true},
+	{"1b000000e8d4a51000", uint64(1000000000000), true, true},
+	{"1bffffffffffffffff", uint64(18446744073709551615), true, true},
+	{"c249010000000000000000", bigInt("18446744073709551616"), true, true},
+	{"3bffffffffffffffff", bigInt("-18446744073709551616"), true, true},
+	{"c349010000000000000000", bigInt("-18446744073709551617"), true, true},
+	{"20", int64(-1), true, true},
+	{"29", int64(-10), true, true},
+	{"3863", int64(-100), true, true},
+	{"3903e7", int64(-1000), true, true},
+	{"f90000", float32(0.0), true, false},
+	{"f98000", float32(math.Copysign(0, -1)), true, false},
+	{"f93c00", float32(1.0), true, false},
+	{"fb3ff199999999999a", 1.1, true, true},
+	{"f93e00", float32(1.5), true, false},
+	{"f97bff", float32(65504.0), true, false},
+	{"fa47c35000", float32(100000.0), true, true},
+	{"fa7f7fffff", float32(3.4028234663852886e+38), true, true},
+	{"fb7e37e43c8800759c", 1.0e+300, true, true},
+	{"f90001", float32(5.960464477539063e-8), true, false},
+	{"f90400", float32(0.00006103515625), true, false},
+	{"f9c400", float32(-4.0), true, false},
+	{"fbc010666666666666", -4.1, true, true},
+	{"f97c00", float32(math.Inf(1)), true, false},
+	{"f97e00", float32(math.NaN()), true, false},
+	{"f9fc00", float32(math.Inf(-1)), true, false},
+	{"fa7f800000", float32(math.Inf(1)), false, true},
+	{"fa7fc00000", float32(math.NaN()), false, true},
+	{"faff800000", float32(math.Inf(-1)), false, true},
+	{"fb7ff0000000000000", math.Inf(1), false, true},
+	{"fb7ff8000000000000", math.NaN(), false, true},
+	{"fbfff0000000000000", math.Inf(-1), false, true},
+	{"f4", false, true, true},
+	{"f5", true, true, true},
+	{"f6", nil, true, true},
+	{"f7", Undefined{}, true, true},
+	{"f0", SimpleValue(16), true, true},
+	{"f8ff", SimpleValue(255), true, true},
+	{"c074323031332d30332d32315432303a30343a30305a", Tag{0, "2013-03-21T20:04:00Z"}, true, true},
+	{"c11a514b67b0", Tag{1, uint64(1363896240)}, true, true},
+	{"c1fb41d452d9ec200000", Tag{1, 1363896240.5}, true, true},
+	{"d74401020304", Tag{23, []byte{1, 2, 3, 4}}, true, true},
+	{"d818456449455446", Tag{24, []byte("dIETF")}, true, true},
+	{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", Tag{32, "http://www.example.com"}, true, true},
+	{"40", []byte{}, true, true},
+	{"4401020304", []byte{1, 2, 3, 4}, true, true},
+	{"60", "", true, true},
+	{"6161", "a", true, true},
+	{"6449455446", "IETF", true, true},
+	{"62225c", "\"\\", true, true},
+	{"62c3bc", "ü", true, true},
+	{"63e6b0b4", "水", true, true},
+	{"64f0908591", "\U00010151", true, true},
+	{"80", []interface{}{}, true, true},
+	{"83010203", []interface{}{uint64(1), uint64(2), uint64(3)}, true, true},
+	{"8301820203820405", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}, true, true},
+	{"98190102030405060708090a0b0c0d0e0f101112131415161718181819", oneToTwentyFive(), true, true},
+	{"a0", map[interface{}]interface{}{}, true, true},
+	{"a201020304", map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}, true, false},
+	{"a26161016162820203", map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}, true, false},
+	{"826161a161626163", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}, true, true},
+	{"a56161614161626142616361436164614461656145", map[interface{}]interface{}{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}, true, false},
+	{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}, false, false},
+	{"7f657374726561646d696e67ff", "streaming", false, false},
+	{"9fff", []interface{}{}, false, false},
+	{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}, false, false},
+	{"9f01820203820405ff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}, false, false},
+	{"83018202039f0405ff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}, false, false},
+	{"83019f0203ff820405", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}, false, false},
+	{"9f0102030405060708090a0b0c0d0e0f101112131415161718181819ff", oneToTwentyFive(), false, false},
+	{"bf61610161629f0203ffff", map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}, false, false},
+	{"826161bf61626163ff", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}, false, false},
+	{"bf6346756ef563416d7421ff", map[interface{}]interface{}{"Fun": true, "Amt": int64(-2)}, false, false},
+}
+
+func oneToTwentyFive() []interface{} {
+	items := []interface{}{}
+	for i := uint64(1); i <= 25; i++ {
+		items = append(items, i)
+	}
+	return items
+}
+
+//newProvider uses tiny segments on purpose, so that most of the data items cross segment boundaries.
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(4096, 8)
+	return mp
+}
+
+func decodeHex(c *C, mp *memory.MemoryProvider, s string) (interface{}, error) {
+	data, err := hex.DecodeString(s)
+	c.Assert(err, IsNil)
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(msp.WriteMemory(data), IsNil)
+	return NewDecoder(msp.NewReader()).Decode()
+}
+
+func encodeHex(c *C, mp *memory.MemoryProvider, canonical bool, v interface{}) string {
+	msp := mp.NewSegmentProxy()
+	e := NewEncoder(msp)
+	if canonical {
+		e = NewCanonicalEncoder(msp)
+	}
+	c.Assert(e.Encode(v), IsNil)
+	return hex.EncodeToString(msp.GetBuffer())
+}
+
+func isNaN(v interface{}) bool {
+	switch f := v.(type) {
+	case float32:
+		return math.IsNaN(float64(f))
+	case float64:
+		return math.IsNaN(f)
+	}
+	return false
+}
+
+func (m *CBOR) Test_AppendixA_Decode(c *C) {
+	mp := newProvider()
+	for _, vector := range appendixA {
+		v, err := decodeHex(c, mp, vector.hex)
+		c.Assert(err, IsNil, Commentf(vector.hex))
+		if isNaN(vector.value) {
+			c.Assert(isNaN(v), Equals, true, Commentf(vector.hex))
+			continue
+		}
+		if f, ok := vector.value.(float32); ok && f == 0 {
+			c.Assert(math.Signbit(float64(v.(float32))), Equals, math.Signbit(float64(f)), Commentf(vector.hex))
+		}
+		c.Assert(v, DeepEquals, vector.value, Commentf(vector.hex))
+	}
+}
+
+func (m *CBOR) Test_AppendixA_Encode(c *C) {
+	mp := newProvider()
+	for _, vector := range appendixA {
+		v, err := decodeHex(c, mp, vector.hex)
+		c.Assert(err, IsNil, Commentf(vector.hex))
+		if vector.canonical {
+			c.Assert(encodeHex(c, mp, true, v), Equals, vector.hex)
+		}
+		if vector.preferred {
+			c.Assert(encodeHex(c, mp, false, v), Equals, vector.hex)
+		}
+	}
+}
+
+func (m *CBOR) Test_Encode_IndefiniteLength(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	e := NewEncoder(msp)
+	//[_ 1, [2, 3], [_ 4, 5]]
+	c.Assert(e.BeginIndefiniteArray(), IsNil)
+	c.Assert(e.EncodeUint(1), IsNil)
+	c.Assert(e.Encode([]int{2, 3}), IsNil)
+	c.Assert(e.BeginIndefiniteArray(), IsNil)
+	c.Assert(e.EncodeUint(4), IsNil)
+	c.Assert(e.EncodeUint(5), IsNil)
+	c.Assert(e.EncodeBreak(), IsNil)
+	c.Assert(e.EncodeBreak(), IsNil)
+	//(_ "strea", "ming")
+	c.Assert(e.BeginIndefiniteString(), IsNil)
+	c.Assert(e.EncodeString("strea"), IsNil)
+	c.Assert(e.EncodeString("ming"), IsNil)
+	c.Assert(e.EncodeBreak(), IsNil)
+	//{_ "Fun": true, "Amt": -2}
+	c.Assert(e.BeginIndefiniteMap(), IsNil)
+	c.Assert(e.EncodeString("Fun"), IsNil)
+	c.Assert(e.EncodeBool(true), IsNil)
+	c.Assert(e.EncodeString("Amt"), IsNil)
+	c.Assert(e.EncodeInt(-2), IsNil)
+	c.Assert(e.EncodeBreak(), IsNil)
+	c.Assert(hex.EncodeToString(msp.GetBuffer()), Equals, "9f018202039f0405ffff"+"7f657374726561646d696e67ff"+"bf6346756ef563416d7421ff")
+
+	msp = mp.NewSegmentProxy()
+	defer msp.Close()
+	e = NewCanonicalEncoder(msp)
+	c.Assert(e.BeginIndefiniteArray(), Equals, ErrIndefiniteInCanonicalMode)
+	c.Assert(e.EncodeBreak(), Equals, ErrIndefiniteInCanonicalMode)
+}
+
+func (m *CBOR) Test_Canonical_MapKeyOrder(c *C) {
+	mp := newProvider()
+	v := map[interface{}]interface{}{"aa": 1, "b": 2, 10: 3, -1: 4, 100: 5, false: 6}
+	//keys are sorted by their encoded bytes: 0a, 1864, 20, 6162, 626161, f4.
+	c.Assert(encodeHex(c, mp, true, v), Equals, "a6"+"0a03"+"186405"+"2004"+"616202"+"62616101"+"f406")
+}
+
+func (m *CBOR) Test_Decode_Sequence(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	e := NewEncoder(msp)
+	c.Assert(e.Encode("first"), IsNil)
+	c.Assert(e.Encode([]interface{}{uint64(2)}), IsNil)
+	d := NewDecoder(msp.NewReader())
+	v, err := d.Decode()
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "first")
+	v, err = d.Decode()
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, []interface{}{uint64(2)})
+	_, err = d.Decode()
+	c.Assert(err, NotNil)
+}
+
+func (m *CBOR) Test_Decode_Malformed(c *C) {
+	mp := newProvider()
+	for _, s := range []string{
+		"18",         //missing argument
+		"62c3",       //truncated text string
+		"ff",         //break outside of an indefinite-length item
+		"1c",         //reserved additional information
+		"5f01ff",     //invalid chunk of an indefinite-length byte string
+		"f818",       //simple value in two bytes below 32
+		"a201020102", //duplicate map key
+		"62c328",     //invalid UTF-8
+	} {
+		_, err := decodeHex(c, mp, s)
+		c.Assert(err, NotNil, Commentf(s))
+	}
+
+	d, err := hex.DecodeString("818181818180")
+	c.Assert(err, IsNil)
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(msp.WriteMemory(d), IsNil)
+	decoder := NewDecoder(msp.NewReader())
+	decoder.MaxNestedLevels = 3
+	_, err = decoder.Decode()
+	c.Assert(err, Equals, ErrMaxNestedLevelsExceeded)
+
+	//lengths claimed up to MaxLength by a few bytes fail once the data runs out, without allocating them ahead.
+	for _, s := range []string{"5a00ffffff00", "7a00ffffff61", "9a00ffffff01", "ba00ffffff0102"} {
+		_, err := decodeHex(c, mp, s)
+		c.Assert(err, Equals, io.ErrUnexpectedEOF, Commentf(s))
+	}
+}
+
+func (m *CBOR) Test_Decode_LongString(c *C) {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(64*1024, 256)
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(NewEncoder(msp).Encode(data), IsNil)
+	v, err := NewDecoder(msp.NewReader()).Decode()
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, data)
+}
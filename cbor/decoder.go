@@ -0,0 +1,358 @@
+package cbor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"unicode/utf8"
+)
+
+var (
+	//max depth of nested arrays, maps and tags by default.
+	defMaxNestedLevels = 32
+	//max length of a single string, array or map by default.
+	defMaxLength uint64 = 1024 * 1024 * 16
+	//max number of bytes or items allocated ahead of the data, the length in the header is untrusted.
+	maxPrealloc uint64 = 1024
+)
+
+//Reader is the source a decoder consumes, memory.MemorySegmentReader satisfies it.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+//Decoder reads CBOR data items from a segmented buffer.
+//
+//Decoded items are mapped onto Go values as follows:
+//unsigned integers to uint64, negative integers to int64 (or *big.Int if they don't fit),
+//byte and text strings to []byte and string (indefinite-length chunks are concatenated),
+//arrays to []interface{}, maps to map[interface{}]interface{}, bignums to *big.Int,
+//other tags to Tag, half and single precision floats to float32, double precision floats to float64,
+//null to nil, and undefined or unassigned simple values to Undefined and SimpleValue.
+type Decoder struct {
+	r               Reader
+	MaxNestedLevels int
+	MaxLength       uint64
+	buf             [8]byte
+}
+
+func NewDecoder(r Reader) *Decoder {
+	return &Decoder{r: r, MaxNestedLevels: defMaxNestedLevels, MaxLength: defMaxLength}
+}
+
+//Decode reads the next data item, it returns io.EOF when there isn't any item left.
+func (d *Decoder) Decode() (interface{}, error) {
+	ib, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeItem(ib, 0)
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+//readArgument reads the argument of a head whose initial byte is ib.
+func (d *Decoder) readArgument(ib byte) (uint64, error) {
+	ai := ib & additionalInfoMask
+	size := 0
+	switch {
+	case ai < additionalInfoUint8:
+		return uint64(ai), nil
+	case ai == additionalInfoUint8:
+		size = 1
+	case ai == additionalInfoUint16:
+		size = 2
+	case ai == additionalInfoUint32:
+		size = 4
+	case ai == additionalInfoUint64:
+		size = 8
+	default:
+		return 0, fmt.Errorf("cbor: malformed additional information %d.", ai)
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:size]); err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(d.buf[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(d.buf[:2])), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(d.buf[:4])), nil
+	}
+	return binary.BigEndian.Uint64(d.buf[:8]), nil
+}
+
+func (d *Decoder) readLength(ib byte) (uint64, error) {
+	n, err := d.readArgument(ib)
+	if err != nil {
+		return 0, err
+	}
+	if n > d.MaxLength {
+		return 0, ErrMaxLengthExceeded
+	}
+	return n, nil
+}
+
+func (d *Decoder) decodeItem(ib byte, level int) (interface{}, error) {
+	if level > d.MaxNestedLevels {
+		return nil, ErrMaxNestedLevelsExceeded
+	}
+	major := ib &^ additionalInfoMask
+	indefinite := ib&additionalInfoMask == additionalInfoIndefinite
+	switch major {
+	case majorTypeUnsignedInt:
+		return d.readArgument(ib)
+	case majorTypeNegativeInt:
+		n, err := d.readArgument(ib)
+		if err != nil {
+			return nil, err
+		}
+		if n <= math.MaxInt64 {
+			return -1 - int64(n), nil
+		}
+		v := new(big.Int).SetUint64(n)
+		return v.Neg(v).Sub(v, big.NewInt(1)), nil
+	case majorTypeByteString, majorTypeTextString:
+		var data []byte
+		var err error
+		if indefinite {
+			data, err = d.readChunks(major)
+		} else {
+			data, err = d.readString(ib)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if major == majorTypeByteString {
+			return data, nil
+		}
+		if !utf8.Valid(data) {
+			return nil, fmt.Errorf("cbor: invalid UTF-8 text string.")
+		}
+		return string(data), nil
+	case majorTypeArray:
+		return d.decodeArray(ib, indefinite, level)
+	case majorTypeMap:
+		return d.decodeMap(ib, indefinite, level)
+	case majorTypeTag:
+		return d.decodeTag(ib, level)
+	}
+	return d.decodeSimple(ib)
+}
+
+func (d *Decoder) readString(ib byte) ([]byte, error) {
+	n, err := d.readLength(ib)
+	if err != nil {
+		return nil, err
+	}
+	//the length claimed is untrusted, the string grows only as its bytes come in.
+	data := make([]byte, 0, prealloc(n))
+	for uint64(len(data)) < n {
+		if len(data) == cap(data) {
+			data = append(data, 0)[:len(data)]
+		}
+		end := cap(data)
+		if uint64(end) > n {
+			end = int(n)
+		}
+		read, err := io.ReadFull(d.r, data[len(data):end])
+		data = data[:len(data)+read]
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+//prealloc returns the room allocated ahead for a string, an array or a map of n bytes or items.
+func prealloc(n uint64) int {
+	if n > maxPrealloc {
+		return int(maxPrealloc)
+	}
+	return int(n)
+}
+
+//readChunks concatenates the definite-length chunks of an indefinite-length string.
+func (d *Decoder) readChunks(major byte) ([]byte, error) {
+	data := []byte{}
+	for {
+		ib, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if ib == breakCode {
+			return data, nil
+		}
+		if ib&^additionalInfoMask != major || ib&additionalInfoMask == additionalInfoIndefinite {
+			return nil, fmt.Errorf("cbor: invalid chunk in indefinite-length string.")
+		}
+		chunk, err := d.readString(ib)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(data)+len(chunk)) > d.MaxLength {
+			return nil, ErrMaxLengthExceeded
+		}
+		data = append(data, chunk...)
+	}
+}
+
+//next reads the next nested data item, breaks are reported by ErrUnexpectedBreak.
+func (d *Decoder) next(level int) (interface{}, error) {
+	ib, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if ib == breakCode {
+		return nil, ErrUnexpectedBreak
+	}
+	return d.decodeItem(ib, level)
+}
+
+func (d *Decoder) decodeArray(ib byte, indefinite bool, level int) (interface{}, error) {
+	if indefinite {
+		items := []interface{}{}
+		for {
+			item, err := d.next(level + 1)
+			if err == ErrUnexpectedBreak {
+				return items, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if uint64(len(items)) >= d.MaxLength {
+				return nil, ErrMaxLengthExceeded
+			}
+			items = append(items, item)
+		}
+	}
+	n, err := d.readLength(ib)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, 0, prealloc(n))
+	for i := uint64(0); i < n; i++ {
+		item, err := d.next(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *Decoder) decodeMap(ib byte, indefinite bool, level int) (interface{}, error) {
+	n := uint64(0)
+	if !indefinite {
+		var err error
+		if n, err = d.readLength(ib); err != nil {
+			return nil, err
+		}
+	}
+	m := make(map[interface{}]interface{}, prealloc(n))
+	for i := uint64(0); indefinite || i < n; i++ {
+		key, err := d.next(level + 1)
+		if err == ErrUnexpectedBreak && indefinite {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.next(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case []byte, []interface{}, map[interface{}]interface{}, *big.Int, Tag:
+			return nil, fmt.Errorf("cbor: unsupported map key type %T.", key)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("cbor: duplicate map key %v.", key)
+		}
+		if uint64(len(m)) >= d.MaxLength {
+			return nil, ErrMaxLengthExceeded
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func (d *Decoder) decodeTag(ib byte, level int) (interface{}, error) {
+	number, err := d.readArgument(ib)
+	if err != nil {
+		return nil, err
+	}
+	content, err := d.next(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	if number == TagPositiveBignum || number == TagNegativeBignum {
+		data, ok := content.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("cbor: bignum content MUST be a byte string.")
+		}
+		v := new(big.Int).SetBytes(data)
+		if number == TagNegativeBignum {
+			v.Neg(v).Sub(v, big.NewInt(1))
+		}
+		return v, nil
+	}
+	return Tag{Number: number, Content: content}, nil
+}
+
+func (d *Decoder) decodeSimple(ib byte) (interface{}, error) {
+	ai := ib & additionalInfoMask
+	switch ai {
+	case simpleFalse:
+		return false, nil
+	case simpleTrue:
+		return true, nil
+	case simpleNull:
+		return nil, nil
+	case simpleUndefined:
+		return Undefined{}, nil
+	case additionalInfoUint8:
+		v, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if v < 32 {
+			return nil, ErrInvalidSimpleValue
+		}
+		return SimpleValue(v), nil
+	case simpleFloat16, simpleFloat32, simpleFloat64:
+		bits, err := d.readArgument(ib)
+		if err != nil {
+			return nil, err
+		}
+		switch ai {
+		case simpleFloat16:
+			return float16ToFloat32(uint16(bits)), nil
+		case simpleFloat32:
+			return math.Float32frombits(uint32(bits)), nil
+		}
+		return math.Float64frombits(bits), nil
+	case additionalInfoIndefinite:
+		return nil, ErrUnexpectedBreak
+	}
+	if ai < 20 {
+		return SimpleValue(ai), nil
+	}
+	return nil, fmt.Errorf("cbor: malformed simple value %d.", ai)
+}
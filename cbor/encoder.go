@@ -0,0 +1,360 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"github.com/gomsg/memory"
+)
+
+//writer is the subset of memory.MemorySegmentProxyer the encoder relies on.
+type writer interface {
+	WriteMemory(data []byte) error
+}
+
+//bufferWriter collects encoded data items in memory, it's used for sorting map keys in canonical mode.
+type bufferWriter struct {
+	bytes.Buffer
+}
+
+func (bw *bufferWriter) WriteMemory(data []byte) error {
+	_, err := bw.Write(data)
+	return err
+}
+
+//Encoder writes CBOR data items into a memory segment proxy.
+//
+//In canonical mode the encoder follows the core deterministic encoding requirements (RFC 8949 section 4.2.1):
+//integers, lengths and floats take the shortest form, map keys are sorted bytewise by their encoded form
+//and indefinite-length items are refused.
+type Encoder struct {
+	w         writer
+	canonical bool
+	head      [9]byte
+}
+
+//NewEncoder returns an encoder which writes preferred (but not deterministic) serializations into w.
+func NewEncoder(w memory.MemorySegmentProxyer) *Encoder {
+	return &Encoder{w: w}
+}
+
+//NewCanonicalEncoder returns an encoder which writes deterministic serializations into w.
+func NewCanonicalEncoder(w memory.MemorySegmentProxyer) *Encoder {
+	return &Encoder{w: w, canonical: true}
+}
+
+func (e *Encoder) writeHead(major byte, n uint64) error {
+	switch {
+	case n < uint64(additionalInfoUint8):
+		e.head[0] = major | byte(n)
+		return e.w.WriteMemory(e.head[:1])
+	case n <= math.MaxUint8:
+		e.head[0] = major | additionalInfoUint8
+		e.head[1] = byte(n)
+		return e.w.WriteMemory(e.head[:2])
+	case n <= math.MaxUint16:
+		e.head[0] = major | additionalInfoUint16
+		binary.BigEndian.PutUint16(e.head[1:], uint16(n))
+		return e.w.WriteMemory(e.head[:3])
+	case n <= math.MaxUint32:
+		e.head[0] = major | additionalInfoUint32
+		binary.BigEndian.PutUint32(e.head[1:], uint32(n))
+		return e.w.WriteMemory(e.head[:5])
+	default:
+		e.head[0] = major | additionalInfoUint64
+		binary.BigEndian.PutUint64(e.head[1:], n)
+		return e.w.WriteMemory(e.head[:9])
+	}
+}
+
+func (e *Encoder) writeInitialByte(b byte) error {
+	e.head[0] = b
+	return e.w.WriteMemory(e.head[:1])
+}
+
+func (e *Encoder) EncodeUint(v uint64) error {
+	return e.writeHead(majorTypeUnsignedInt, v)
+}
+
+func (e *Encoder) EncodeInt(v int64) error {
+	if v >= 0 {
+		return e.writeHead(majorTypeUnsignedInt, uint64(v))
+	}
+	return e.writeHead(majorTypeNegativeInt, uint64(-(v + 1)))
+}
+
+//EncodeBigInt writes v as an integer when it fits into 64 bits, otherwise as a bignum (tag 2 or 3).
+func (e *Encoder) EncodeBigInt(v *big.Int) error {
+	if v.Sign() >= 0 {
+		if v.IsUint64() {
+			return e.EncodeUint(v.Uint64())
+		}
+		if err := e.EncodeTag(TagPositiveBignum); err != nil {
+			return err
+		}
+		return e.EncodeBytes(v.Bytes())
+	}
+	//a negative integer n is encoded as -1 - n.
+	n := new(big.Int).Neg(v)
+	n.Sub(n, big.NewInt(1))
+	if n.IsUint64() {
+		return e.writeHead(majorTypeNegativeInt, n.Uint64())
+	}
+	if err := e.EncodeTag(TagNegativeBignum); err != nil {
+		return err
+	}
+	return e.EncodeBytes(n.Bytes())
+}
+
+func (e *Encoder) EncodeBytes(b []byte) error {
+	if err := e.writeHead(majorTypeByteString, uint64(len(b))); err != nil {
+		return err
+	}
+	return e.w.WriteMemory(b)
+}
+
+func (e *Encoder) EncodeString(s string) error {
+	if err := e.writeHead(majorTypeTextString, uint64(len(s))); err != nil {
+		return err
+	}
+	return e.w.WriteMemory([]byte(s))
+}
+
+//EncodeArrayHeader writes the head of an array, the n elements MUST be encoded right after it.
+func (e *Encoder) EncodeArrayHeader(n int) error {
+	return e.writeHead(majorTypeArray, uint64(n))
+}
+
+//EncodeMapHeader writes the head of a map, the n key/value pairs MUST be encoded right after it.
+func (e *Encoder) EncodeMapHeader(n int) error {
+	return e.writeHead(majorTypeMap, uint64(n))
+}
+
+//EncodeTag writes a tag number, the tagged data item MUST be encoded right after it.
+func (e *Encoder) EncodeTag(number uint64) error {
+	return e.writeHead(majorTypeTag, number)
+}
+
+func (e *Encoder) EncodeBool(b bool) error {
+	if b {
+		return e.writeInitialByte(majorTypeSimple | simpleTrue)
+	}
+	return e.writeInitialByte(majorTypeSimple | simpleFalse)
+}
+
+func (e *Encoder) EncodeNull() error {
+	return e.writeInitialByte(majorTypeSimple | simpleNull)
+}
+
+func (e *Encoder) EncodeUndefined() error {
+	return e.writeInitialByte(majorTypeSimple | simpleUndefined)
+}
+
+func (e *Encoder) EncodeSimple(v SimpleValue) error {
+	if v >= 24 && v < 32 {
+		return ErrInvalidSimpleValue
+	}
+	return e.writeHead(majorTypeSimple, uint64(v))
+}
+
+//EncodeFloat32 writes f in single precision, or in the shortest exact form in canonical mode.
+func (e *Encoder) EncodeFloat32(f float32) error {
+	if e.canonical {
+		return e.encodeShortestFloat(float64(f))
+	}
+	e.head[0] = majorTypeSimple | simpleFloat32
+	binary.BigEndian.PutUint32(e.head[1:], math.Float32bits(f))
+	return e.w.WriteMemory(e.head[:5])
+}
+
+//EncodeFloat64 writes f in double precision, or in the shortest exact form in canonical mode.
+func (e *Encoder) EncodeFloat64(f float64) error {
+	if e.canonical {
+		return e.encodeShortestFloat(f)
+	}
+	e.head[0] = majorTypeSimple | simpleFloat64
+	binary.BigEndian.PutUint64(e.head[1:], math.Float64bits(f))
+	return e.w.WriteMemory(e.head[:9])
+}
+
+func (e *Encoder) encodeShortestFloat(f float64) error {
+	if math.IsNaN(f) {
+		e.head[0] = majorTypeSimple | simpleFloat16
+		binary.BigEndian.PutUint16(e.head[1:], 0x7e00)
+		return e.w.WriteMemory(e.head[:3])
+	}
+	f32 := float32(f)
+	if float64(f32) != f {
+		e.head[0] = majorTypeSimple | simpleFloat64
+		binary.BigEndian.PutUint64(e.head[1:], math.Float64bits(f))
+		return e.w.WriteMemory(e.head[:9])
+	}
+	if h, ok := float32ToFloat16(f32); ok {
+		e.head[0] = majorTypeSimple | simpleFloat16
+		binary.BigEndian.PutUint16(e.head[1:], h)
+		return e.w.WriteMemory(e.head[:3])
+	}
+	e.head[0] = majorTypeSimple | simpleFloat32
+	binary.BigEndian.PutUint32(e.head[1:], math.Float32bits(f32))
+	return e.w.WriteMemory(e.head[:5])
+}
+
+func (e *Encoder) beginIndefinite(major byte) error {
+	if e.canonical {
+		return ErrIndefiniteInCanonicalMode
+	}
+	return e.writeInitialByte(major | additionalInfoIndefinite)
+}
+
+//BeginIndefiniteArray starts an indefinite-length array which is terminated by EncodeBreak.
+func (e *Encoder) BeginIndefiniteArray() error {
+	return e.beginIndefinite(majorTypeArray)
+}
+
+//BeginIndefiniteMap starts an indefinite-length map which is terminated by EncodeBreak.
+func (e *Encoder) BeginIndefiniteMap() error {
+	return e.beginIndefinite(majorTypeMap)
+}
+
+//BeginIndefiniteBytes starts an indefinite-length byte string,
+//its chunks are written by EncodeBytes and terminated by EncodeBreak.
+func (e *Encoder) BeginIndefiniteBytes() error {
+	return e.beginIndefinite(majorTypeByteString)
+}
+
+//BeginIndefiniteString starts an indefinite-length text string,
+//its chunks are written by EncodeString and terminated by EncodeBreak.
+func (e *Encoder) BeginIndefiniteString() error {
+	return e.beginIndefinite(majorTypeTextString)
+}
+
+//EncodeBreak terminates the innermost indefinite-length item.
+func (e *Encoder) EncodeBreak() error {
+	if e.canonical {
+		return ErrIndefiniteInCanonicalMode
+	}
+	return e.writeInitialByte(breakCode)
+}
+
+//Encode writes a generic Go value.
+//Besides the types of this package, it supports nil, booleans, integers, floats, strings, []byte,
+//*big.Int, slices, arrays and maps of supported types.
+func (e *Encoder) Encode(v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		return e.EncodeNull()
+	case bool:
+		return e.EncodeBool(value)
+	case int:
+		return e.EncodeInt(int64(value))
+	case int8:
+		return e.EncodeInt(int64(value))
+	case int16:
+		return e.EncodeInt(int64(value))
+	case int32:
+		return e.EncodeInt(int64(value))
+	case int64:
+		return e.EncodeInt(value)
+	case uint:
+		return e.EncodeUint(uint64(value))
+	case uint8:
+		return e.EncodeUint(uint64(value))
+	case uint16:
+		return e.EncodeUint(uint64(value))
+	case uint32:
+		return e.EncodeUint(uint64(value))
+	case uint64:
+		return e.EncodeUint(value)
+	case float32:
+		return e.EncodeFloat32(value)
+	case float64:
+		return e.EncodeFloat64(value)
+	case string:
+		return e.EncodeString(value)
+	case []byte:
+		return e.EncodeBytes(value)
+	case *big.Int:
+		return e.EncodeBigInt(value)
+	case Tag:
+		if err := e.EncodeTag(value.Number); err != nil {
+			return err
+		}
+		return e.Encode(value.Content)
+	case SimpleValue:
+		return e.EncodeSimple(value)
+	case Undefined:
+		return e.EncodeUndefined()
+	case []interface{}:
+		if err := e.EncodeArrayHeader(len(value)); err != nil {
+			return err
+		}
+		for _, item := range value {
+			if err := e.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if err := e.EncodeArrayHeader(rv.Len()); err != nil {
+			return err
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.Encode(rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		return e.encodeMap(rv)
+	}
+	return fmt.Errorf("cbor: unsupported type %T.", v)
+}
+
+func (e *Encoder) encodeMap(rv reflect.Value) error {
+	if err := e.EncodeMapHeader(rv.Len()); err != nil {
+		return err
+	}
+	keys := rv.MapKeys()
+	if !e.canonical {
+		for _, key := range keys {
+			if err := e.Encode(key.Interface()); err != nil {
+				return err
+			}
+			if err := e.Encode(rv.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	//canonical mode sorts the pairs by the bytewise lexicographic order of the encoded keys.
+	type pair struct {
+		key   []byte
+		value reflect.Value
+	}
+	pairs := make([]pair, 0, len(keys))
+	for _, key := range keys {
+		bw := &bufferWriter{}
+		if err := (&Encoder{w: bw, canonical: true}).Encode(key.Interface()); err != nil {
+			return err
+		}
+		pairs = append(pairs, pair{key: bw.Bytes(), value: rv.MapIndex(key)})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+	for _, p := range pairs {
+		if err := e.w.WriteMemory(p.key); err != nil {
+			return err
+		}
+		if err := e.Encode(p.value.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+//Package compress compresses message bodies held in memory segments.
+//
+//A Compressor streams data from a reader into a writer, the helpers of this package read a filled
+//memory segment proxy and write the result into a new proxy drawn from the same MemoryProvider,
+//so neither side of a large body is ever flattened by the caller. LZ4 is the exception, its blocks are handled whole
+//in memory, see LZ4.
+//
+//Every Compressor is identified by a frame.FLAG_COMPRESSION_* codec, which is recorded in the flags
+//of the frame carrying the compressed body.
+package compress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+)
+
+var (
+	ErrUnknownCodec = fmt.Errorf("compress: unknown compression codec.")
+	ErrCorrupt      = fmt.Errorf("compress: corrupt input.")
+	ErrTooLarge     = fmt.Errorf("compress: decompressed size exceeded max size.")
+)
+
+//Compressor is a compression codec.
+type Compressor interface {
+	//Codec returns the frame.FLAG_COMPRESSION_* value recorded in the frame flags.
+	Codec() uint16
+	Compress(dst io.Writer, src io.Reader) error
+	Decompress(dst io.Writer, src io.Reader) error
+}
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[uint16]Compressor{
+		frame.FLAG_COMPRESSION_SNAPPY: NewSnappy(),
+		frame.FLAG_COMPRESSION_LZ4:    NewLZ4(),
+		frame.FLAG_COMPRESSION_ZSTD:   NewZstd(DefaultZstdLevel),
+	}
+)
+
+//Register replaces the compressor of its codec, e.g. with a zstd compressor of another level.
+func Register(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[c.Codec()] = c
+}
+
+//Lookup returns the compressor of the codec recorded in flags, nil if there isn't any.
+func Lookup(flags uint16) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return compressors[flags&frame.FLAG_COMPRESSION_MASK]
+}
+
+//proxyWriter adapts a memory segment proxy to io.Writer, optionally failing past max bytes.
+type proxyWriter struct {
+	msp memory.MemorySegmentProxyer
+	n   uint
+	max uint
+}
+
+//sizeLimiter is implemented by the writers failing past a number of bytes, so that a codec decompressing
+//whole blocks can refuse one too large before allocating it.
+type sizeLimiter interface {
+	//remaining returns the number of bytes which can still be written, false if there is no limit.
+	remaining() (uint, bool)
+}
+
+func (pw *proxyWriter) remaining() (uint, bool) {
+	return pw.max - pw.n, pw.max > 0
+}
+
+func (pw *proxyWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if pw.max > 0 && pw.n+uint(len(p)) > pw.max {
+		return 0, ErrTooLarge
+	}
+	if err := pw.msp.WriteMemory(p); err != nil {
+		return 0, err
+	}
+	pw.n += uint(len(p))
+	return len(p), nil
+}
+
+//CompressProxy compresses src into a new proxy drawn from mp, src is left untouched.
+func CompressProxy(mp *memory.MemoryProvider, c Compressor, src memory.MemorySegmentProxyer) (memory.MemorySegmentProxyer, error) {
+	dst := mp.NewSegmentProxy()
+	if err := c.Compress(&proxyWriter{msp: dst}, src.NewReader()); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
+
+//DecompressProxy decompresses src into a new proxy drawn from mp, failing with ErrTooLarge past maxSize bytes.
+//A maxSize of 0 means no limit.
+func DecompressProxy(mp *memory.MemoryProvider, c Compressor, src memory.MemorySegmentProxyer, maxSize uint) (memory.MemorySegmentProxyer, error) {
+	dst := mp.NewSegmentProxy()
+	if err := c.Decompress(&proxyWriter{msp: dst, max: maxSize}, src.NewReader()); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
+
+//WriteFrame writes a frame whose body is body compressed by c, the codec is added to flags.
+func WriteFrame(fw *frame.FrameWriter, c Compressor, messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer) error {
+	flags = flags&^frame.FLAG_COMPRESSION_MASK | c.Codec()
+	return fw.WriteFrame(messageType, correlationID, flags, func(w memory.MemorySegmentProxyer) error {
+		return c.Compress(&proxyWriter{msp: w}, body.NewReader())
+	})
+}
+
+//DecompressFrame replaces the compressed body of f by its decompressed form and clears the codec from its flags,
+//the compressed body goes back to the pool. It does nothing if the body isn't compressed.
+func DecompressFrame(mp *memory.MemoryProvider, f *frame.Frame, maxSize uint) error {
+	codec := f.Compression()
+	if codec == 0 {
+		return nil
+	}
+	c := Lookup(codec)
+	if c == nil {
+		return ErrUnknownCodec
+	}
+	body, err := DecompressProxy(mp, c, f.Body, maxSize)
+	if err != nil {
+		return err
+	}
+	f.Body.Close()
+	f.Body = body
+	f.Flags &^= frame.FLAG_COMPRESSION_MASK
+	return nil
+}
@@ -0,0 +1,186 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type CompressSuite struct{}
+
+var _ = Suite(&CompressSuite{})
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(1024*1024, 256)
+	return mp
+}
+
+// payload returns JSON-ish data spanning many segments.
+func payload() []byte {
+	var sb strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&sb, `{"id":%d,"name":"user-%d","tags":["a","b"],"active":%t},`, i, i%7, i%3 == 0)
+	}
+	return []byte(sb.String())
+}
+
+func readAll(c *C, msp memory.MemorySegmentProxyer) []byte {
+	data, err := ioutil.ReadAll(msp.NewReader())
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (s *CompressSuite) Test_RoundTrip(c *C) {
+	mp := newProvider()
+	data := payload()
+	for _, comp := range []Compressor{NewSnappy(), NewLZ4(), NewZstd(DefaultZstdLevel)} {
+		for _, input := range [][]byte{data, []byte("tiny"), {}} {
+			src := mp.NewSegmentProxy()
+			c.Assert(src.WriteMemory(input), IsNil)
+			compressed, err := CompressProxy(mp, comp, src)
+			c.Assert(err, IsNil)
+			if len(input) == len(data) {
+				c.Assert(compressed.GetLength() < len(data)/3, Equals, true, Commentf("codec %d", comp.Codec()))
+			}
+			decompressed, err := DecompressProxy(mp, comp, compressed, 0)
+			c.Assert(err, IsNil)
+			c.Assert(bytes.Equal(readAll(c, decompressed), input), Equals, true, Commentf("codec %d", comp.Codec()))
+
+			_, err = DecompressProxy(mp, comp, compressed, 2)
+			if len(input) > 2 {
+				c.Assert(err, Equals, ErrTooLarge)
+			}
+			src.Close()
+			compressed.Close()
+			decompressed.Close()
+		}
+	}
+}
+
+// lz4Golden are blocks written by the reference LZ4 library (LZ4_compress_default of liblz4 1.9.4), without the size.
+var lz4Golden = []struct {
+	input []byte
+	block string
+}{
+	{[]byte{}, "00"},
+	{[]byte("gomsg"), "50676f6d7367"},
+	//a long match.
+	{[]byte(strings.Repeat("gomsg frames carry segments. ", 20)),
+		"ff0e676f6d7367206672616d6573206361727279207365676d656e74732e201d00ffff11506e74732e20"},
+	//long runs of literals.
+	{lz4Literals(),
+		"ffec0008121e2c3c4e627890aac6e4092b4f759dc7f3265688bcf22f69a5e3286aaef4418bd72a7acc257bd3328eec51" +
+			"b31c82ea59c538a81f930e86058104840b8f1aa231bd50e07710a643dd7e21c16811b76413bf7227d9924d0ac485480d" +
+			"cf986330facb9e734a23f9d6b596795e452e1906f0e1d4c9c0b9b4b1b0b1b4b9c0c9d4e1f006192e455e7996b5d6f923" +
+			"4a739ecbfa306398cf0d4885c40a4d92d92772bf1364b71168c1217edd43a61077e050bd31a21a8f0b84048105860e93" +
+			"1fa838c559ea821cb351ec8e32d37b25cc7a2ad78b41f4ae6a28e3a5692ff2bc885626f3c79d754f2b09e4c6aa907862" +
+			"4e3c2c1e120800f5f1efeff1f5fb00ff46500dcf986330"},
+}
+
+// lz4Literals returns 600 bytes with few matches.
+func lz4Literals() []byte {
+	data := make([]byte, 600)
+	for i := range data {
+		data[i] = byte((i*i + 7*i) % 251)
+	}
+	return data
+}
+
+func (s *CompressSuite) Test_LZ4_Block(c *C) {
+	//"a" then a match of 18 bytes at offset 1 overlapping itself, then the 5 last literals.
+	block := []byte{24, 0, 0, 0, 0x1e, 'a', 1, 0, 0x50, 'a', 'a', 'a', 'a', 'a'}
+	var out bytes.Buffer
+	c.Assert(NewLZ4().Decompress(&out, bytes.NewReader(block)), IsNil)
+	c.Assert(out.String(), Equals, strings.Repeat("a", 24))
+
+	out.Reset()
+	c.Assert(NewLZ4().Compress(&out, strings.NewReader(strings.Repeat("a", 300))), IsNil)
+	c.Assert(out.Len() < 20, Equals, true)
+
+	for _, corrupt := range [][]byte{
+		{24, 0, 0, 0, 0x1e, 'a', 2, 0, 0x50, 'a', 'a', 'a', 'a', 'a'}, //offset past the output
+		{24, 0, 0, 0, 0x1e, 'a', 1, 0},                                //truncated
+		{25, 0, 0, 0, 0x1e, 'a', 1, 0, 0x50, 'a', 'a', 'a', 'a', 'a'}, //wrong size
+		{0xff, 0xff, 0xff, 0x7f, 0x10, 'a'},                           //size beyond the max ratio
+	} {
+		c.Assert(NewLZ4().Decompress(&out, bytes.NewReader(corrupt)), Equals, ErrCorrupt)
+	}
+
+	//the blocks are the ones of the reference implementation, in both directions.
+	for _, golden := range lz4Golden {
+		block, err := hex.DecodeString(golden.block)
+		c.Assert(err, IsNil)
+		size := []byte{0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(size, uint32(len(golden.input)))
+		out.Reset()
+		c.Assert(NewLZ4().Decompress(&out, bytes.NewReader(append(size, block...))), IsNil)
+		c.Assert(out.Bytes(), DeepEquals, golden.input)
+		out.Reset()
+		c.Assert(NewLZ4().Compress(&out, bytes.NewReader(golden.input)), IsNil)
+		c.Assert(hex.EncodeToString(out.Bytes()[4:]), Equals, golden.block)
+	}
+
+	//the size claimed by the header is checked against the max size before the block is allocated.
+	mp := newProvider()
+	src := mp.NewSegmentProxy()
+	c.Assert(src.WriteMemory([]byte{0x00, 0x00, 0x10, 0x00, 0xf0, 0xff, 0xff, 0xff}), IsNil)
+	c.Assert(src.WriteMemory(make([]byte, 4096)), IsNil)
+	_, err := DecompressProxy(mp, NewLZ4(), src, 1024)
+	c.Assert(err, Equals, ErrTooLarge)
+	src.Close()
+}
+
+func (s *CompressSuite) Test_Frame(c *C) {
+	mp := newProvider()
+	data := payload()
+	body := mp.NewSegmentProxy()
+	c.Assert(body.WriteMemory(data), IsNil)
+
+	msp := mp.NewSegmentProxy()
+	fw := frame.NewFrameWriter(msp)
+	for _, comp := range []Compressor{NewSnappy(), NewLZ4(), NewZstd(DefaultZstdLevel)} {
+		c.Assert(WriteFrame(fw, comp, 1, uint64(comp.Codec()), frame.FLAG_CHECKSUM_XXHASH64, body), IsNil)
+	}
+	wire := msp.GetBuffer()
+	c.Assert(len(wire) < len(data), Equals, true)
+
+	fr := frame.NewFrameReader(bytes.NewReader(wire), mp)
+	for _, codec := range []uint16{frame.FLAG_COMPRESSION_SNAPPY, frame.FLAG_COMPRESSION_LZ4, frame.FLAG_COMPRESSION_ZSTD} {
+		f, err := fr.ReadFrame()
+		c.Assert(err, IsNil)
+		c.Assert(f.Compression(), Equals, codec)
+		c.Assert(f.Flags&frame.FLAG_CHECKSUM_XXHASH64, Equals, frame.FLAG_CHECKSUM_XXHASH64)
+		c.Assert(DecompressFrame(mp, f, 1024*1024), IsNil)
+		c.Assert(f.Compression(), Equals, uint16(0))
+		c.Assert(bytes.Equal(readAll(c, f.Body), data), Equals, true)
+		f.Close()
+	}
+}
+
+func (s *CompressSuite) Test_Register(c *C) {
+	c.Assert(Lookup(frame.FLAG_COMPRESSION_LZ4|frame.FLAG_CHECKSUM_CRC32C), FitsTypeOf, &LZ4{})
+	c.Assert(Lookup(0), IsNil)
+
+	zstd := NewZstd(19)
+	Register(zstd)
+	defer Register(NewZstd(DefaultZstdLevel))
+	c.Assert(Lookup(frame.FLAG_COMPRESSION_ZSTD), Equals, zstd)
+
+	compressorsLock.Lock()
+	delete(compressors, frame.FLAG_COMPRESSION_ZSTD)
+	compressorsLock.Unlock()
+	f := &frame.Frame{Flags: frame.FLAG_COMPRESSION_ZSTD}
+	c.Assert(DecompressFrame(newProvider(), f, 0), Equals, ErrUnknownCodec)
+}
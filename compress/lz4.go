@@ -0,0 +1,243 @@
+package compress
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/gomsg/frame"
+)
+
+const (
+	lz4MinMatch = 4
+	//the last match must start at least 12 bytes before the end of the block.
+	lz4MFLimit = 12
+	//the last 5 bytes of the block are always literals.
+	lz4LastLiterals = 5
+	lz4MaxOffset    = 65535
+	lz4HashLog      = 14
+	//a single byte never expands to more than 255 bytes, which bounds the size claimed by a corrupt header.
+	lz4MaxRatio = 255
+)
+
+//LZ4 uses the LZ4 block format, the block is preceded by its decompressed size as a 4 bytes little endian integer.
+//Past the size, the block is the one of the reference library (LZ4_compress_default, LZ4_decompress_safe).
+//
+//Unlike snappy and zstd, a block is compressed and decompressed as a whole in memory, so LZ4 flattens both sides
+//of the body. The buffers are bounded: a block holds at most 4GiB, and the decompressed size claimed by its header
+//is checked against the max size of DecompressProxy before anything is allocated.
+type LZ4 struct{}
+
+func NewLZ4() *LZ4 {
+	return &LZ4{}
+}
+
+func (l *LZ4) Codec() uint16 {
+	return frame.FLAG_COMPRESSION_LZ4
+}
+
+//lz4CompressBound returns the max length of the block of n bytes, header included.
+func lz4CompressBound(n uint64) uint64 {
+	return 4 + n + n/255 + 16
+}
+
+func (l *LZ4) Compress(dst io.Writer, src io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(src, math.MaxUint32+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > math.MaxUint32 {
+		return ErrTooLarge
+	}
+	block := make([]byte, 4, lz4CompressBound(uint64(len(data))))
+	binary.LittleEndian.PutUint32(block, uint32(len(data)))
+	block = lz4CompressBlock(block, data)
+	_, err = dst.Write(block)
+	return err
+}
+
+func (l *LZ4) Decompress(dst io.Writer, src io.Reader) error {
+	maxSize := uint64(math.MaxUint32)
+	if limited, ok := dst.(sizeLimiter); ok {
+		if remaining, ok := limited.remaining(); ok && uint64(remaining) < maxSize {
+			maxSize = uint64(remaining)
+		}
+	}
+	//a block of at most maxSize bytes can't be longer than its bound, the rest is read only to tell it apart.
+	block, err := io.ReadAll(io.LimitReader(src, int64(lz4CompressBound(maxSize))+1))
+	if err != nil {
+		return err
+	}
+	if len(block) < 4 {
+		return ErrCorrupt
+	}
+	size := binary.LittleEndian.Uint32(block)
+	if uint64(size) > maxSize {
+		return ErrTooLarge
+	}
+	block = block[4:]
+	if uint64(size) > uint64(len(block))*lz4MaxRatio || uint64(len(block)) > lz4CompressBound(uint64(size)) {
+		return ErrCorrupt
+	}
+	data := make([]byte, size)
+	if err := lz4DecompressBlock(data, block); err != nil {
+		return err
+	}
+	_, err = dst.Write(data)
+	return err
+}
+
+func lz4Hash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - lz4HashLog)
+}
+
+//lz4CompressBlock appends the compressed src to dst, matches are found greedily through a hash table of 4 bytes sequences.
+func lz4CompressBlock(dst, src []byte) []byte {
+	anchor := 0
+	if len(src) > lz4MFLimit {
+		//positions are stored plus one, so 0 means an empty slot.
+		var table [1 << lz4HashLog]int32
+		limit := len(src) - lz4MFLimit
+		for i := 0; i < limit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := lz4Hash(seq)
+			ref := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				i++
+				continue
+			}
+			matchLen := lz4MinMatch
+			for i+matchLen < len(src)-lz4LastLiterals && src[ref+matchLen] == src[i+matchLen] {
+				matchLen++
+			}
+			for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+				i--
+				ref--
+				matchLen++
+			}
+			dst = lz4AppendSequence(dst, src[anchor:i], i-ref, matchLen)
+			i += matchLen
+			anchor = i
+		}
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+//lz4AppendSequence appends literals followed by a match, a match length of 0 marks the last sequence of the block.
+func lz4AppendSequence(dst, literals []byte, offset, matchLen int) []byte {
+	var token byte
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	if matchLen > 0 {
+		if matchLen-lz4MinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(matchLen - lz4MinMatch)
+		}
+	}
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if matchLen == 0 {
+		return dst
+	}
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if matchLen-lz4MinMatch >= 15 {
+		dst = lz4AppendLength(dst, matchLen-lz4MinMatch-15)
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+//lz4DecompressBlock decompresses src into dst, which MUST be exactly as long as the decompressed data.
+func lz4DecompressBlock(dst, src []byte) error {
+	si, di := 0, 0
+	for {
+		if si >= len(src) {
+			return ErrCorrupt
+		}
+		token := src[si]
+		si++
+		literalLen := int(token >> 4)
+		if literalLen == 15 {
+			n, err := lz4ReadLength(src, &si)
+			if err != nil {
+				return err
+			}
+			literalLen += n
+		}
+		if literalLen > len(src)-si || literalLen > len(dst)-di {
+			return ErrCorrupt
+		}
+		copy(dst[di:], src[si:si+literalLen])
+		si += literalLen
+		di += literalLen
+		if si == len(src) {
+			break
+		}
+
+		if len(src)-si < 2 {
+			return ErrCorrupt
+		}
+		offset := int(src[si]) | int(src[si+1])<<8
+		si += 2
+		if offset == 0 || offset > di {
+			return ErrCorrupt
+		}
+		matchLen := int(token & 15)
+		if matchLen == 15 {
+			n, err := lz4ReadLength(src, &si)
+			if err != nil {
+				return err
+			}
+			matchLen += n
+		}
+		matchLen += lz4MinMatch
+		if matchLen > len(dst)-di {
+			return ErrCorrupt
+		}
+		if offset >= matchLen {
+			copy(dst[di:di+matchLen], dst[di-offset:])
+		} else {
+			//the match overlaps the bytes it produces, e.g. a run of one byte.
+			for k := 0; k < matchLen; k++ {
+				dst[di+k] = dst[di-offset+k]
+			}
+		}
+		di += matchLen
+	}
+	if di != len(dst) {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func lz4ReadLength(src []byte, si *int) (int, error) {
+	n := 0
+	for {
+		if *si >= len(src) {
+			return 0, ErrCorrupt
+		}
+		b := src[*si]
+		*si++
+		n += int(b)
+		if n > len(src)*lz4MaxRatio {
+			return 0, ErrCorrupt
+		}
+		if b != 255 {
+			return n, nil
+		}
+	}
+}
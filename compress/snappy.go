@@ -0,0 +1,36 @@
+package compress
+
+import (
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/gomsg/frame"
+)
+
+//Snappy uses the snappy framing format, which streams the data in checksummed chunks of up to 64KB.
+type Snappy struct{}
+
+func NewSnappy() *Snappy {
+	return &Snappy{}
+}
+
+func (s *Snappy) Codec() uint16 {
+	return frame.FLAG_COMPRESSION_SNAPPY
+}
+
+func (s *Snappy) Compress(dst io.Writer, src io.Reader) error {
+	w := snappy.NewBufferedWriter(dst)
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *Snappy) Decompress(dst io.Writer, src io.Reader) error {
+	_, err := io.Copy(dst, snappy.NewReader(src))
+	if err == snappy.ErrCorrupt {
+		return ErrCorrupt
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package compress
+
+import (
+	"io"
+	"sync"
+
+	"github.com/gomsg/frame"
+	"github.com/klauspost/compress/zstd"
+)
+
+const DefaultZstdLevel = 3
+
+//Zstd keeps pools of single threaded encoders and decoders, since creating them is expensive.
+type Zstd struct {
+	level    zstd.EncoderLevel
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+//NewZstd takes a zstd compression level, it's mapped to the closest level supported by the encoder.
+func NewZstd(level int) *Zstd {
+	return &Zstd{level: zstd.EncoderLevelFromZstd(level)}
+}
+
+func (z *Zstd) Codec() uint16 {
+	return frame.FLAG_COMPRESSION_ZSTD
+}
+
+func (z *Zstd) Compress(dst io.Writer, src io.Reader) error {
+	e, _ := z.encoders.Get().(*zstd.Encoder)
+	if e == nil {
+		var err error
+		e, err = zstd.NewWriter(dst, zstd.WithEncoderLevel(z.level), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+	} else {
+		e.Reset(dst)
+	}
+	_, err := e.ReadFrom(src)
+	if cerr := e.Close(); err == nil {
+		err = cerr
+	}
+	//a closed encoder can be reset, drop the reference to dst before pooling it.
+	e.Reset(nil)
+	z.encoders.Put(e)
+	return err
+}
+
+func (z *Zstd) Decompress(dst io.Writer, src io.Reader) error {
+	d, _ := z.decoders.Get().(*zstd.Decoder)
+	if d == nil {
+		var err error
+		d, err = zstd.NewReader(src, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+	} else if err := d.Reset(src); err != nil {
+		return err
+	}
+	_, err := d.WriteTo(dst)
+	d.Reset(nil)
+	z.decoders.Put(d)
+	return err
+}
@@ -0,0 +1,63 @@
+package frame
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	ErrChecksumMismatch = fmt.Errorf("frame: checksum mismatch.")
+)
+
+//ChecksumMismatchError is returned by FrameReader when the body of a frame doesn't match its checksum,
+//errors.Is(err, ErrChecksumMismatch) reports true for it.
+type ChecksumMismatchError struct {
+	MessageType   uint32
+	CorrelationID uint64
+	Expected      uint64
+	Actual        uint64
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("frame: checksum mismatch. (Message Type: %d, Correlation ID: %d, Expected: %#x, Actual: %#x)",
+		e.MessageType, e.CorrelationID, e.Expected, e.Actual)
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+//defaultChecksum selects CRC32C unless the flags already select a checksum.
+func defaultChecksum(flags uint16) uint16 {
+	if flags&checksumFlags == 0 {
+		flags |= FLAG_CHECKSUM_CRC32C
+	}
+	return flags
+}
+
+//newHash returns the hash selected by the checksum flags, or nil if the frame carries no checksum.
+func newHash(flags uint16) hash.Hash {
+	switch {
+	case flags&FLAG_CHECKSUM_XXHASH64 != 0:
+		return xxhash.New()
+	case flags&FLAG_CHECKSUM_CRC32C != 0:
+		return crc32.New(castagnoliTable)
+	}
+	return nil
+}
+
+//sum returns the checksum stored into the header, a 32 bits checksum takes the low half of the field.
+func sum(h hash.Hash) uint64 {
+	switch v := h.(type) {
+	case hash.Hash64:
+		return v.Sum64()
+	case hash.Hash32:
+		return uint64(v.Sum32())
+	}
+	return 0
+}
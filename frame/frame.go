@@ -0,0 +1,229 @@
+//Package frame defines the wire format of gomsg messages.
+//
+//Every frame starts with a fixed size header, all integers are little endian:
+//
+//	offset  size  field
+//	0       4     magic number ("GMSG")
+//	4       1     version
+//	5       1     reserved, always 0
+//	6       2     flags
+//	8       4     message type
+//	12      4     body length
+//	16      8     correlation id
+//	24      8     checksum of the body
+//	32            body, or the trace context if FLAG_TRACE is set
+//
+//The lowest 2 bits of the flags select the checksum, the next 2 bits the compression codec of the body,
+//the next bit marks an encrypted body, the next one a frame sent along with files and the next one a frame
+//carrying a trace context. The checksum covers the body as it is on the wire, i.e. after compression and encryption.
+//
+//The trace context extends the header, it's the binary form of a W3C traceparent so that spans link across hops:
+//
+//	offset  size  field
+//	32      16    trace id
+//	48      8     parent span id
+//	56      1     trace flags
+//	57            body
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/gomsg/memory"
+)
+
+const (
+	//MagicNumber is "GMSG" in little endian.
+	MagicNumber uint32 = 0x47534d47
+	Version     uint8  = 1
+	HeaderSize         = 32
+	//TraceContextSize is the size of the trace context following the header of a frame with FLAG_TRACE.
+	TraceContextSize = 25
+	//MaxHeaderSize is the size of a header along with its trace context.
+	MaxHeaderSize = HeaderSize + TraceContextSize
+)
+
+//offsets of the header fields.
+const (
+	magicOffset         = 0
+	versionOffset       = 4
+	flagsOffset         = 6
+	messageTypeOffset   = 8
+	bodyLengthOffset    = 12
+	correlationIDOffset = 16
+	checksumOffset      = 24
+	traceIDOffset       = 32
+	spanIDOffset        = 48
+	traceFlagsOffset    = 56
+)
+
+//Frame flags.
+const (
+	//FLAG_CHECKSUM_CRC32C marks a frame whose checksum field holds the CRC32C (Castagnoli) of the body.
+	FLAG_CHECKSUM_CRC32C uint16 = 1 << iota
+	//FLAG_CHECKSUM_XXHASH64 marks a frame whose checksum field holds the xxHash64 of the body.
+	FLAG_CHECKSUM_XXHASH64
+
+	checksumFlags = FLAG_CHECKSUM_CRC32C | FLAG_CHECKSUM_XXHASH64
+)
+
+//Compression codecs of the body, the codec is a 2 bits value rather than a single flag.
+const (
+	FLAG_COMPRESSION_SNAPPY uint16 = (iota + 1) << 2
+	FLAG_COMPRESSION_LZ4
+	FLAG_COMPRESSION_ZSTD
+
+	FLAG_COMPRESSION_MASK uint16 = 3 << 2
+)
+
+//FLAG_ENCRYPTED marks a frame whose body is sealed with an AEAD.
+const FLAG_ENCRYPTED uint16 = 1 << 4
+
+//FLAG_FILES marks a frame sent along with file descriptors over a Unix domain socket.
+const FLAG_FILES uint16 = 1 << 5
+
+//FLAG_TRACE marks a frame whose header is followed by a trace context, see TraceContext.
+const FLAG_TRACE uint16 = 1 << 6
+
+var (
+	//max body size of a frame being read by default.
+	defMaxBodySize uint32 = 1024 * 1024 * 16
+)
+
+var (
+	ErrInvalidMagic       = fmt.Errorf("frame: invalid magic number.")
+	ErrUnsupportedVersion = fmt.Errorf("frame: unsupported version.")
+	ErrFrameTooLarge      = fmt.Errorf("frame: body size exceeded max body size.")
+	ErrFrameNotBegun      = fmt.Errorf("frame: End called without Begin.")
+)
+
+//TraceContext identifies the span a frame was sent from, as a W3C traceparent does.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   uint8
+}
+
+//IsValid reports whether both the trace id and the span id are set.
+func (tc *TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+//Frame is a parsed frame header along with its body, the body lives in pooled memory segments.
+type Frame struct {
+	Version       uint8
+	Flags         uint16
+	MessageType   uint32
+	BodyLength    uint32
+	CorrelationID uint64
+	Checksum      uint64
+	//Trace is the trace context of the frame, only sent if FLAG_TRACE is set.
+	Trace TraceContext
+	Body  memory.MemorySegmentProxyer
+	//Files received along with the frame, see FLAG_FILES. Take them by setting Files to nil before Close.
+	Files []*os.File
+}
+
+//Close gives the memory segments of the body back to the pool and closes the files.
+func (f *Frame) Close() {
+	if f.Body != nil {
+		f.Body.Close()
+		f.Body = nil
+	}
+	for _, file := range f.Files {
+		file.Close()
+	}
+	f.Files = nil
+}
+
+//HeaderLength returns the size of the header on the wire, including the trace context if FLAG_TRACE is set.
+func (f *Frame) HeaderLength() int {
+	if f.Flags&FLAG_TRACE != 0 {
+		return MaxHeaderSize
+	}
+	return HeaderSize
+}
+
+//Compression returns the FLAG_COMPRESSION_* codec of the body, 0 if the body isn't compressed.
+func (f *Frame) Compression() uint16 {
+	return f.Flags & FLAG_COMPRESSION_MASK
+}
+
+//EncodeHeader fills the version, the body length and the checksum from the body, then encodes the header
+//into header which MUST be HeaderLength long. It's used for sending a body as it is, without copying it
+//into a FrameWriter. The checksum is CRC32C unless FLAG_CHECKSUM_XXHASH64 is set.
+func (f *Frame) EncodeHeader(header []byte) error {
+	f.Version = Version
+	f.Flags = defaultChecksum(f.Flags)
+	h := newHash(f.Flags)
+	length := 0
+	if f.Body != nil {
+		for _, buf := range f.Body.Buffers() {
+			h.Write(buf)
+			length += len(buf)
+		}
+	}
+	if uint64(length) > math.MaxUint32 {
+		return ErrFrameTooLarge
+	}
+	f.BodyLength = uint32(length)
+	f.Checksum = sum(h)
+	f.encodeHeader(header)
+	return nil
+}
+
+//AssociatedData returns the encoded header with the body length and the checksum zeroed,
+//since both depend on the sealed body. It's authenticated along with an encrypted body.
+//FLAG_TRACE and the trace context are left out, a transport fills them in once the body was sealed.
+func (f *Frame) AssociatedData() []byte {
+	header := *f
+	header.BodyLength = 0
+	header.Checksum = 0
+	header.Flags &^= FLAG_TRACE
+	header.Trace = TraceContext{}
+	data := make([]byte, HeaderSize)
+	header.encodeHeader(data)
+	return data
+}
+
+func (f *Frame) encodeHeader(header []byte) {
+	binary.LittleEndian.PutUint32(header[magicOffset:], MagicNumber)
+	header[versionOffset] = f.Version
+	header[versionOffset+1] = 0
+	binary.LittleEndian.PutUint16(header[flagsOffset:], f.Flags)
+	binary.LittleEndian.PutUint32(header[messageTypeOffset:], f.MessageType)
+	binary.LittleEndian.PutUint32(header[bodyLengthOffset:], f.BodyLength)
+	binary.LittleEndian.PutUint64(header[correlationIDOffset:], f.CorrelationID)
+	binary.LittleEndian.PutUint64(header[checksumOffset:], f.Checksum)
+	if f.Flags&FLAG_TRACE != 0 {
+		copy(header[traceIDOffset:], f.Trace.TraceID[:])
+		copy(header[spanIDOffset:], f.Trace.SpanID[:])
+		header[traceFlagsOffset] = f.Trace.Flags
+	}
+}
+
+func (f *Frame) decodeHeader(header []byte) error {
+	if binary.LittleEndian.Uint32(header[magicOffset:]) != MagicNumber {
+		return ErrInvalidMagic
+	}
+	f.Version = header[versionOffset]
+	if f.Version != Version {
+		return ErrUnsupportedVersion
+	}
+	f.Flags = binary.LittleEndian.Uint16(header[flagsOffset:])
+	f.MessageType = binary.LittleEndian.Uint32(header[messageTypeOffset:])
+	f.BodyLength = binary.LittleEndian.Uint32(header[bodyLengthOffset:])
+	f.CorrelationID = binary.LittleEndian.Uint64(header[correlationIDOffset:])
+	f.Checksum = binary.LittleEndian.Uint64(header[checksumOffset:])
+	return nil
+}
+
+//decodeTrace decodes the trace context following the header, header MUST be MaxHeaderSize long.
+func (f *Frame) decodeTrace(header []byte) {
+	copy(f.Trace.TraceID[:], header[traceIDOffset:])
+	copy(f.Trace.SpanID[:], header[spanIDOffset:])
+	f.Trace.Flags = header[traceFlagsOffset]
+}
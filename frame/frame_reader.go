@@ -0,0 +1,113 @@
+package frame
+
+import (
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"github.com/gomsg/memory"
+)
+
+//FrameReader parses frames incrementally from a byte stream, bodies are read straight into pooled memory segments
+//and verified against the checksum of their header while being read.
+//
+//If the underlying reader fails in the middle of a frame (e.g. a read deadline expired),
+//the bytes read so far are kept and the next ReadFrame call carries on with the same frame.
+type FrameReader struct {
+	r           io.Reader
+	mp          *memory.MemoryProvider
+	MaxBodySize uint32
+	header      [MaxHeaderSize]byte
+	headerRead  int
+	frame       *Frame
+	hash        hash.Hash
+	bodyLeft    uint
+}
+
+func NewFrameReader(r io.Reader, mp *memory.MemoryProvider) *FrameReader {
+	return &FrameReader{r: r, mp: mp, MaxBodySize: defMaxBodySize}
+}
+
+//ReadFrame returns the next frame, the caller owns it and MUST close it.
+//It returns io.EOF if the stream ends right between two frames.
+func (fr *FrameReader) ReadFrame() (*Frame, error) {
+	for {
+		want := fr.headerLength()
+		if fr.headerRead == want {
+			break
+		}
+		n, err := fr.r.Read(fr.header[fr.headerRead:want])
+		fr.headerRead += n
+		if err == io.EOF {
+			if fr.headerRead == 0 {
+				return nil, io.EOF
+			}
+			if fr.headerRead < fr.headerLength() {
+				return nil, io.ErrUnexpectedEOF
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if fr.frame == nil {
+		f := &Frame{}
+		if err := f.decodeHeader(fr.header[:]); err != nil {
+			fr.reset()
+			return nil, err
+		}
+		if f.Flags&FLAG_TRACE != 0 {
+			f.decodeTrace(fr.header[:])
+		}
+		if f.BodyLength > fr.MaxBodySize {
+			fr.reset()
+			return nil, ErrFrameTooLarge
+		}
+		f.Body = fr.mp.NewSegmentProxy()
+		fr.hash = newHash(f.Flags)
+		f.Body.SetHash(fr.hash)
+		fr.frame = f
+		fr.bodyLeft = uint(f.BodyLength)
+	}
+	for fr.bodyLeft > 0 {
+		n, err := fr.frame.Body.FillFrom(fr.r, fr.bodyLeft)
+		fr.bodyLeft -= n
+		if err == io.ErrUnexpectedEOF {
+			fr.reset().Close()
+			return nil, err
+		}
+		if err != nil {
+			//keep the partial frame for the next call.
+			return nil, err
+		}
+	}
+	f := fr.reset()
+	f.Body.SetHash(nil)
+	if fr.hash != nil {
+		if actual := sum(fr.hash); actual != f.Checksum {
+			f.Close()
+			return nil, &ChecksumMismatchError{
+				MessageType:   f.MessageType,
+				CorrelationID: f.CorrelationID,
+				Expected:      f.Checksum,
+				Actual:        actual}
+		}
+	}
+	return f, nil
+}
+
+//headerLength returns how many bytes of header to read, the trace context is read once the flags are.
+func (fr *FrameReader) headerLength() int {
+	if fr.headerRead >= HeaderSize && binary.LittleEndian.Uint16(fr.header[flagsOffset:])&FLAG_TRACE != 0 {
+		return MaxHeaderSize
+	}
+	return HeaderSize
+}
+
+//reset prepares the reader for the next frame and returns the current one, which may be nil.
+func (fr *FrameReader) reset() *Frame {
+	f := fr.frame
+	fr.frame = nil
+	fr.headerRead = 0
+	fr.bodyLeft = 0
+	return f
+}
@@ -0,0 +1,246 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type FrameSuite struct{}
+
+var _ = Suite(&FrameSuite{})
+
+var errTimeout = errors.New("i/o timeout")
+
+//choppyReader returns at most 3 bytes per call and fails every other call, like a socket with a short deadline.
+type choppyReader struct {
+	r     io.Reader
+	calls int
+}
+
+func (cr *choppyReader) Read(p []byte) (int, error) {
+	cr.calls++
+	if cr.calls%2 == 0 {
+		return 0, errTimeout
+	}
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return cr.r.Read(p)
+}
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(64*1024, 16)
+	return mp
+}
+
+func writeFrames(c *C, mp *memory.MemoryProvider) []byte {
+	msp := mp.NewSegmentProxy()
+	fw := NewFrameWriter(msp)
+	//a 3 bytes prefix moves the first header off the segment boundary.
+	c.Assert(msp.WriteMemory([]byte("pre")), IsNil)
+	c.Assert(fw.WriteFrame(7, 100, 0, func(body memory.MemorySegmentProxyer) error {
+		return body.WriteMemory([]byte("hello, segmented world"))
+	}), IsNil)
+	c.Assert(fw.Begin(8, 101, 0), IsNil)
+	c.Assert(fw.End(), IsNil)
+	return msp.GetBuffer()[3:]
+}
+
+func (s *FrameSuite) Test_WriteFrame_Header(c *C) {
+	mp := newProvider()
+	data := writeFrames(c, mp)
+	body := []byte("hello, segmented world")
+	c.Assert(len(data), Equals, 2*HeaderSize+len(body))
+	c.Assert(string(data[:4]), Equals, "GMSG")
+	c.Assert(data[versionOffset], Equals, Version)
+	c.Assert(binary.LittleEndian.Uint16(data[flagsOffset:]), Equals, FLAG_CHECKSUM_CRC32C)
+	c.Assert(binary.LittleEndian.Uint32(data[messageTypeOffset:]), Equals, uint32(7))
+	c.Assert(binary.LittleEndian.Uint32(data[bodyLengthOffset:]), Equals, uint32(len(body)))
+	c.Assert(binary.LittleEndian.Uint64(data[correlationIDOffset:]), Equals, uint64(100))
+	c.Assert(binary.LittleEndian.Uint64(data[checksumOffset:]), Equals, uint64(crc32.Checksum(body, castagnoliTable)))
+	c.Assert(data[HeaderSize:HeaderSize+len(body)], DeepEquals, body)
+
+	empty := data[HeaderSize+len(body):]
+	c.Assert(binary.LittleEndian.Uint32(empty[bodyLengthOffset:]), Equals, uint32(0))
+	c.Assert(binary.LittleEndian.Uint64(empty[correlationIDOffset:]), Equals, uint64(101))
+}
+
+func (s *FrameSuite) Test_ReadFrame_Incremental(c *C) {
+	mp := newProvider()
+	data := writeFrames(c, mp)
+	fr := NewFrameReader(&choppyReader{r: bytes.NewReader(data)}, mp)
+	frames := []*Frame{}
+	for {
+		f, err := fr.ReadFrame()
+		if err == errTimeout {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		frames = append(frames, f)
+	}
+	c.Assert(len(frames), Equals, 2)
+	c.Assert(frames[0].MessageType, Equals, uint32(7))
+	c.Assert(frames[0].CorrelationID, Equals, uint64(100))
+	body, err := ioutil.ReadAll(frames[0].Body.NewReader())
+	c.Assert(err, IsNil)
+	c.Assert(string(body), Equals, "hello, segmented world")
+	c.Assert(frames[0].Body.GetSegmentCount(), Equals, 2)
+	c.Assert(frames[1].MessageType, Equals, uint32(8))
+	c.Assert(frames[1].Body.GetLength(), Equals, 0)
+	for _, f := range frames {
+		f.Close()
+	}
+}
+
+func (s *FrameSuite) Test_ReadFrame_Limits(c *C) {
+	mp := newProvider()
+	data := writeFrames(c, mp)
+
+	fr := NewFrameReader(bytes.NewReader(data), mp)
+	fr.MaxBodySize = 8
+	_, err := fr.ReadFrame()
+	c.Assert(err, Equals, ErrFrameTooLarge)
+
+	_, err = NewFrameReader(bytes.NewReader(data[:HeaderSize+5]), mp).ReadFrame()
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+	_, err = NewFrameReader(bytes.NewReader(data[:10]), mp).ReadFrame()
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+
+	bad := append([]byte{}, data...)
+	bad[0] = 'X'
+	_, err = NewFrameReader(bytes.NewReader(bad), mp).ReadFrame()
+	c.Assert(err, Equals, ErrInvalidMagic)
+	bad = append([]byte{}, data...)
+	bad[versionOffset] = 9
+	_, err = NewFrameReader(bytes.NewReader(bad), mp).ReadFrame()
+	c.Assert(err, Equals, ErrUnsupportedVersion)
+
+	c.Assert(NewFrameWriter(mp.NewSegmentProxy()).End(), Equals, ErrFrameNotBegun)
+}
+
+func (s *FrameSuite) Test_Checksum_XXHash64(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	fw := NewFrameWriter(msp)
+	body := bytes.Repeat([]byte("xxhash "), 10)
+	c.Assert(fw.WriteFrame(1, 2, FLAG_CHECKSUM_XXHASH64, func(w memory.MemorySegmentProxyer) error {
+		return w.WriteMemory(body)
+	}), IsNil)
+	data := msp.GetBuffer()
+	c.Assert(binary.LittleEndian.Uint16(data[flagsOffset:]), Equals, FLAG_CHECKSUM_XXHASH64)
+	c.Assert(binary.LittleEndian.Uint64(data[checksumOffset:]), Equals, xxhash.Sum64(body))
+
+	f, err := NewFrameReader(bytes.NewReader(data), mp).ReadFrame()
+	c.Assert(err, IsNil)
+	c.Assert(f.Checksum, Equals, xxhash.Sum64(body))
+	f.Close()
+}
+
+func (s *FrameSuite) Test_Checksum_Mismatch(c *C) {
+	mp := newProvider()
+	for _, flags := range []uint16{0, FLAG_CHECKSUM_XXHASH64} {
+		msp := mp.NewSegmentProxy()
+		c.Assert(NewFrameWriter(msp).WriteFrame(3, 4, flags, func(w memory.MemorySegmentProxyer) error {
+			return w.WriteMemory([]byte("payload"))
+		}), IsNil)
+		data := msp.GetBuffer()
+		data[HeaderSize+1] ^= 0xff
+		f, err := NewFrameReader(bytes.NewReader(data), mp).ReadFrame()
+		c.Assert(f, IsNil)
+		c.Assert(errors.Is(err, ErrChecksumMismatch), Equals, true)
+		mismatch, ok := err.(*ChecksumMismatchError)
+		c.Assert(ok, Equals, true)
+		c.Assert(mismatch.CorrelationID, Equals, uint64(4))
+		c.Assert(mismatch.Actual, Not(Equals), mismatch.Expected)
+	}
+}
+
+func (s *FrameSuite) Test_EncodeHeader(c *C) {
+	mp := newProvider()
+	body := mp.NewSegmentProxy()
+	c.Assert(body.WriteMemory([]byte("hello, segmented world")), IsNil)
+	f := &Frame{MessageType: 7, CorrelationID: 100, Body: body}
+	header := make([]byte, HeaderSize)
+	c.Assert(f.EncodeHeader(header), IsNil)
+	c.Assert(f.Flags, Equals, FLAG_CHECKSUM_CRC32C)
+
+	//the same header as written by a FrameWriter.
+	data := writeFrames(c, mp)
+	c.Assert(header, DeepEquals, data[:HeaderSize])
+	f.Close()
+}
+
+func (s *FrameSuite) Test_TraceContext(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	fw := NewFrameWriter(msp)
+	trace := TraceContext{Flags: 1}
+	for i := range trace.TraceID {
+		trace.TraceID[i] = byte(i + 1)
+	}
+	copy(trace.SpanID[:], "spanid!!")
+	c.Assert(fw.Begin(7, 100, FLAG_TRACE), IsNil)
+	fw.Frame().Trace = trace
+	c.Assert(msp.WriteMemory([]byte("traced")), IsNil)
+	c.Assert(fw.End(), IsNil)
+	c.Assert(fw.WriteFrame(8, 101, 0, func(body memory.MemorySegmentProxyer) error {
+		return body.WriteMemory([]byte("untraced"))
+	}), IsNil)
+
+	data := msp.GetBuffer()
+	c.Assert(len(data), Equals, MaxHeaderSize+len("traced")+HeaderSize+len("untraced"))
+	c.Assert(binary.LittleEndian.Uint32(data[bodyLengthOffset:]), Equals, uint32(len("traced")))
+	c.Assert(data[spanIDOffset:traceFlagsOffset], DeepEquals, []byte("spanid!!"))
+
+	fr := NewFrameReader(&choppyReader{r: bytes.NewReader(data)}, mp)
+	frames := []*Frame{}
+	for len(frames) < 2 {
+		f, err := fr.ReadFrame()
+		if err == errTimeout {
+			continue
+		}
+		c.Assert(err, IsNil)
+		frames = append(frames, f)
+	}
+	c.Assert(frames[0].Flags&FLAG_TRACE, Equals, FLAG_TRACE)
+	c.Assert(frames[0].Trace, Equals, trace)
+	c.Assert(frames[0].Trace.IsValid(), Equals, true)
+
+	//EncodeHeader writes the same header as a FrameWriter.
+	header := make([]byte, frames[0].HeaderLength())
+	c.Assert(frames[0].EncodeHeader(header), IsNil)
+	c.Assert(header, DeepEquals, data[:MaxHeaderSize])
+	//the trace context isn't authenticated, a frame sealed before being traced opens alike.
+	untraced := *frames[0]
+	untraced.Flags &^= FLAG_TRACE
+	untraced.Trace = TraceContext{}
+	c.Assert(frames[0].AssociatedData(), DeepEquals, untraced.AssociatedData())
+	c.Assert(frames[0].AssociatedData(), HasLen, HeaderSize)
+
+	c.Assert(string(frames[0].Body.GetBuffer()), Equals, "traced")
+	c.Assert(frames[1].Trace.IsValid(), Equals, false)
+	c.Assert(string(frames[1].Body.GetBuffer()), Equals, "untraced")
+
+	_, err := NewFrameReader(bytes.NewReader(data[:HeaderSize+5]), mp).ReadFrame()
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+	for _, f := range frames {
+		f.Close()
+	}
+	msp.Close()
+}
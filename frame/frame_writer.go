@@ -0,0 +1,91 @@
+package frame
+
+import (
+	"hash"
+	"math"
+
+	"github.com/gomsg/memory"
+)
+
+//FrameWriter writes frames into a memory segment proxy, several frames could be written one after another.
+//
+//Begin reserves the header with Skip and saves its position, the body is then written straight into the proxy
+//which computes a running checksum of it, End back-fills the header at the saved position.
+//A frame begun with FLAG_TRACE reserves room for the trace context too, set by Frame().Trace before End.
+type FrameWriter struct {
+	msp       memory.MemorySegmentProxyer
+	frame     Frame
+	hash      hash.Hash
+	headerPos *memory.MemoryPosition
+	bodyStart int
+	header    [MaxHeaderSize]byte
+}
+
+func NewFrameWriter(msp memory.MemorySegmentProxyer) *FrameWriter {
+	return &FrameWriter{msp: msp}
+}
+
+//Body returns the proxy the body of the current frame is written into.
+func (fw *FrameWriter) Body() memory.MemorySegmentProxyer {
+	return fw.msp
+}
+
+//Frame returns the header of the current frame, it's only valid between Begin and End.
+func (fw *FrameWriter) Frame() *Frame {
+	return &fw.frame
+}
+
+//Begin starts a frame by reserving its header.
+//The checksum is CRC32C unless FLAG_CHECKSUM_XXHASH64 is specified.
+func (fw *FrameWriter) Begin(messageType uint32, correlationID uint64, flags uint16) error {
+	//the position may point at the end of a full segment, WriteMemoryAt moves on to the next one.
+	pos := fw.msp.GetPosition()
+	bodyStart := fw.msp.GetLength()
+	frame := Frame{
+		Version:       Version,
+		Flags:         defaultChecksum(flags),
+		MessageType:   messageType,
+		CorrelationID: correlationID}
+	headerLength := frame.HeaderLength()
+	if err := fw.msp.Skip(uint(headerLength)); err != nil {
+		return err
+	}
+	fw.headerPos = pos
+	fw.bodyStart = bodyStart + headerLength
+	fw.frame = frame
+	fw.hash = newHash(frame.Flags)
+	fw.msp.SetHash(fw.hash)
+	return nil
+}
+
+//End completes the current frame by back-filling its header.
+func (fw *FrameWriter) End() error {
+	if fw.headerPos == nil {
+		return ErrFrameNotBegun
+	}
+	fw.msp.SetHash(nil)
+	length := fw.msp.GetLength() - fw.bodyStart
+	if uint64(length) > math.MaxUint32 {
+		fw.headerPos = nil
+		return ErrFrameTooLarge
+	}
+	fw.frame.BodyLength = uint32(length)
+	fw.frame.Checksum = sum(fw.hash)
+	fw.frame.encodeHeader(fw.header[:])
+	err := fw.msp.WriteMemoryAt(fw.headerPos, fw.header[:fw.frame.HeaderLength()])
+	fw.headerPos = nil
+	return err
+}
+
+//WriteFrame writes a whole frame, the body is written by writeBody.
+func (fw *FrameWriter) WriteFrame(messageType uint32, correlationID uint64, flags uint16, writeBody func(body memory.MemorySegmentProxyer) error) error {
+	if err := fw.Begin(messageType, correlationID, flags); err != nil {
+		return err
+	}
+	if err := writeBody(fw.msp); err != nil {
+		fw.msp.SetHash(nil)
+		fw.headerPos = nil
+		return err
+	}
+	return fw.End()
+}
@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidFraction = fmt.Errorf("memory: fraction of the memory limit must be within (0, 1].")
+
+//where the cgroup of the process and its limits are read from, replaced by tests.
+var (
+	procSelfCgroup = "/proc/self/cgroup"
+	cgroupRoot     = "/sys/fs/cgroup"
+)
+
+//cgroup v1 reports no limit as a huge number rounded to pages.
+const cgroupV1Unlimited = 1 << 62
+
+//memoryLimit returns the lowest memory limit of the process, from its cgroup (v2 or v1) and from
+//the Go runtime (GOMEMLIMIT or debug.SetMemoryLimit), and where it comes from. It returns 0 if there's none.
+func memoryLimit() (uint64, string) {
+	limit, source := cgroupMemoryLimit()
+	if goLimit := debug.SetMemoryLimit(-1); goLimit > 0 && goLimit < math.MaxInt64 && (limit == 0 || uint64(goLimit) < limit) {
+		limit, source = uint64(goLimit), "Go runtime"
+	}
+	return limit, source
+}
+
+//cgroupMemoryLimit returns the lowest memory limit of the cgroup of the process and of its ancestors.
+func cgroupMemoryLimit() (uint64, string) {
+	f, err := os.Open(procSelfCgroup)
+	if err != nil {
+		return 0, ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		//hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			if limit := lowestLimit(cgroupRoot, fields[2], "memory.max"); limit > 0 {
+				return limit, "cgroup v2"
+			}
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == "memory" {
+				if limit := lowestLimit(path.Join(cgroupRoot, "memory"), fields[2], "memory.limit_in_bytes"); limit > 0 {
+					return limit, "cgroup v1"
+				}
+			}
+		}
+	}
+	return 0, ""
+}
+
+//lowestLimit returns the lowest limit read from file in the cgroup directory and its ancestors, under root.
+//A container usually sees its own cgroup at the root, so missing directories are skipped.
+func lowestLimit(root, cgroup, file string) uint64 {
+	lowest := uint64(0)
+	for dir := path.Clean("/" + cgroup); ; dir = path.Dir(dir) {
+		data, err := os.ReadFile(path.Join(root, dir, file))
+		if err == nil {
+			value := strings.TrimSpace(string(data))
+			if limit, err := strconv.ParseUint(value, 10, 64); err == nil && limit < cgroupV1Unlimited && (lowest == 0 || limit < lowest) {
+				lowest = limit
+			}
+		}
+		if dir == "/" {
+			return lowest
+		}
+	}
+}
+
+//autoPoolSize returns fraction of the memory limit of the process, rounded down to segments,
+//or the default pool size if there's no limit.
+func autoPoolSize(fraction float64, segmentSize uint, logger Logger) (uint, error) {
+	if fraction <= 0 || fraction > 1 {
+		return 0, ErrInvalidFraction
+	}
+	limit, source := memoryLimit()
+	if limit == 0 {
+		size := roundToSegments(defMemPoolSize, segmentSize)
+		logger.Info("No memory limit found, sizing Memory Pool to the default", "size", size)
+		return size, nil
+	}
+	size := roundToSegments(uint(float64(limit)*fraction), segmentSize)
+	logger.Info("Sizing Memory Pool from the memory limit", "fraction", fraction, "source", source, "limit", limit, "size", size)
+	return size, nil
+}
+
+//roundToSegments rounds size down to a multiple of segmentSize, keeping at least one segment.
+func roundToSegments(size, segmentSize uint) uint {
+	size = size / segmentSize * segmentSize
+	if size == 0 {
+		size = segmentSize
+	}
+	return size
+}
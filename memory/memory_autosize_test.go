@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+
+	. "gopkg.in/check.v1"
+)
+
+//fakeCgroup points the cgroup lookups at fake files under a temporary directory, files maps their paths
+//relative to the cgroup root to their content. It returns a function restoring the real ones.
+func fakeCgroup(c *C, procSelf string, files map[string]string) func() {
+	dir := c.MkDir()
+	procSelfCgroup, cgroupRoot = path.Join(dir, "cgroup"), path.Join(dir, "sys")
+	c.Assert(os.WriteFile(procSelfCgroup, []byte(procSelf), 0644), IsNil)
+	for name, content := range files {
+		file := path.Join(cgroupRoot, name)
+		c.Assert(os.MkdirAll(path.Dir(file), 0755), IsNil)
+		c.Assert(os.WriteFile(file, []byte(content), 0644), IsNil)
+	}
+	return func() {
+		procSelfCgroup, cgroupRoot = "/proc/self/cgroup", "/sys/fs/cgroup"
+	}
+}
+
+//noGoLimit removes the memory limit of the Go runtime, returning a function restoring it.
+func noGoLimit() func() {
+	previous := debug.SetMemoryLimit(math.MaxInt64)
+	return func() { debug.SetMemoryLimit(previous) }
+}
+
+func (m *MemoryPool) TestMemoryLimit_CgroupV2(c *C) {
+	defer noGoLimit()()
+	defer fakeCgroup(c, "0::/kubepods/pod1/ctr\n", map[string]string{
+		"memory.max":                    "max\n",
+		"kubepods/pod1/memory.max":      "1048576000\n",
+		"kubepods/pod1/ctr/memory.max":  "max\n",
+		"kubepods/pod1/ctr/memory.high": "1000\n",
+	})()
+	limit, source := memoryLimit()
+	c.Assert(limit, Equals, uint64(1048576000))
+	c.Assert(source, Equals, "cgroup v2")
+	size, err := autoPoolSize(0.5, 256, defaultLogger)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, uint(524288000))
+}
+
+func (m *MemoryPool) TestMemoryLimit_CgroupV2_Namespaced(c *C) {
+	defer noGoLimit()()
+	//inside a container the cgroup shows up at the root of the mount.
+	defer fakeCgroup(c, "0::/kubepods/pod1/ctr\n", map[string]string{
+		"memory.max": "268435456\n",
+	})()
+	limit, source := memoryLimit()
+	c.Assert(limit, Equals, uint64(268435456))
+	c.Assert(source, Equals, "cgroup v2")
+}
+
+func (m *MemoryPool) TestMemoryLimit_CgroupV1(c *C) {
+	defer noGoLimit()()
+	defer fakeCgroup(c, "12:cpu,cpuacct:/docker/abc\n4:memory:/docker/abc\n0::/\n", map[string]string{
+		"memory/memory.limit_in_bytes":            "9223372036854771712\n",
+		"memory/docker/abc/memory.limit_in_bytes": "536870912\n",
+	})()
+	limit, source := memoryLimit()
+	c.Assert(limit, Equals, uint64(536870912))
+	c.Assert(source, Equals, "cgroup v1")
+}
+
+func (m *MemoryPool) TestMemoryLimit_GoRuntime(c *C) {
+	defer noGoLimit()()
+	defer fakeCgroup(c, "0::/\n", map[string]string{"memory.max": "1048576000\n"})()
+	debug.SetMemoryLimit(100 * 1024 * 1024)
+	limit, source := memoryLimit()
+	c.Assert(limit, Equals, uint64(100*1024*1024))
+	c.Assert(source, Equals, "Go runtime")
+}
+
+func (m *MemoryPool) TestMemoryLimit_None(c *C) {
+	defer noGoLimit()()
+	defer fakeCgroup(c, "0::/\n", map[string]string{"memory.max": "max\n"})()
+	limit, _ := memoryLimit()
+	c.Assert(limit, Equals, uint64(0))
+	size, err := autoPoolSize(0.5, 256, defaultLogger)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, defMemPoolSize)
+	//the default is rounded down to segments as well.
+	size, err = autoPoolSize(0.5, 3000, defaultLogger)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, defMemPoolSize/3000*3000)
+	mp, err := NewMemoryProvider(WithAutoSize(0.5), WithSegmentSize(3000))
+	c.Assert(err, IsNil)
+	c.Assert(mp.Close(), IsNil)
+}
+
+func (m *MemoryPool) TestNewMemoryProvider_AutoSize(c *C) {
+	defer noGoLimit()()
+	defer fakeCgroup(c, "0::/\n", map[string]string{"memory.max": "1000000\n"})()
+	mp, err := NewMemoryProvider(WithAutoSize(0.25), WithPoolSize(1024), WithSegmentSize(1000))
+	c.Assert(err, IsNil)
+	c.Assert(cap(mp.memPool), Equals, 250000)
+	c.Assert(mp.Close(), IsNil)
+
+	if runtime.GOOS == "linux" {
+		mp, err = NewMemoryProvider(WithAutoSize(0.25), WithSegmentSize(1000), WithMapping(0))
+		c.Assert(err, IsNil)
+		c.Assert(*mp.unusedSegmentCount, Equals, int32(250))
+		c.Assert(mp.Close(), IsNil)
+	}
+
+	_, err = NewMemoryProvider(WithAutoSize(1.5))
+	c.Assert(err, Equals, ErrInvalidFraction)
+	_, err = NewMemoryProvider(WithAutoSize(-1))
+	c.Assert(err, Equals, ErrInvalidFraction)
+}
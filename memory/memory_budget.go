@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrInvalidBudget  = fmt.Errorf("memory: invalid budget, its minimum can't exceed its maximum nor the pool.")
+	ErrBudgetExists   = fmt.Errorf("memory: budget already exists.")
+	ErrBudgetExceeded = fmt.Errorf("memory: budget exceeded.")
+	ErrBudgetClosed   = fmt.Errorf("memory: budget closed.")
+)
+
+//Budget is a share of the pool of a provider, e.g. for a tenant: its proxies can always borrow up to
+//its minimum number of segments and never more than its maximum.
+//The minimum is reserved, other borrowers can't take it, the rest of the pool is shared on a first come,
+//first served basis. Its fields are guarded by the lock of the provider.
+type Budget struct {
+	mp       *MemoryProvider
+	name     string
+	min      int
+	max      int
+	used     int
+	rejected uint64
+	closed   bool
+}
+
+//BudgetStats reports the usage of a budget, in segments.
+type BudgetStats struct {
+	Name string
+	Min  int
+	Max  int
+	Used int
+	//Rejected counts the segments the budget failed to borrow.
+	Rejected uint64
+}
+
+//NewBudget carves a named budget of min to max segments out of the pool.
+//The minimums of every budget can't exceed the number of segments of the pool. A minimum is only guaranteed
+//once enough segments are given back, if they were borrowed before the budget was created.
+func (mp *MemoryProvider) NewBudget(name string, min, max int) (*Budget, error) {
+	mp.Lock()
+	defer mp.Unlock()
+	if mp.closed {
+		return nil, ErrProviderClosed
+	}
+	if min < 0 || max <= 0 || min > max {
+		return nil, ErrInvalidBudget
+	}
+	if _, ok := mp.budgets[name]; ok {
+		return nil, ErrBudgetExists
+	}
+	minimums := min
+	for _, b := range mp.budgets {
+		minimums += b.min
+	}
+	if minimums > len(mp.segments) {
+		return nil, ErrInvalidBudget
+	}
+	if mp.budgets == nil {
+		mp.budgets = make(map[string]*Budget)
+	}
+	b := &Budget{mp: mp, name: name, min: min, max: max}
+	mp.budgets[name] = b
+	return b, nil
+}
+
+//Budget returns the budget of the given name, nil if there isn't any.
+func (mp *MemoryProvider) Budget(name string) *Budget {
+	mp.RLock()
+	defer mp.RUnlock()
+	return mp.budgets[name]
+}
+
+//Budgets reports the usage of every budget, sorted by name.
+func (mp *MemoryProvider) Budgets() []BudgetStats {
+	mp.RLock()
+	defer mp.RUnlock()
+	stats := make([]BudgetStats, 0, len(mp.budgets))
+	for _, b := range mp.budgets {
+		stats = append(stats, b.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+//reservedSegments returns how many available segments are kept for the minimums of the budgets,
+//the caller holds the lock.
+func (mp *MemoryProvider) reservedSegments() int {
+	reserved := 0
+	for _, b := range mp.budgets {
+		if b.used < b.min {
+			reserved += b.min - b.used
+		}
+	}
+	return reserved
+}
+
+//NewSegmentProxy returns a proxy whose segments are charged to the budget.
+func (b *Budget) NewSegmentProxy() MemorySegmentProxyer {
+	return &MemorySegmentProxy{mp: b.mp, usedSegments: []*memorySegment{}, budget: b}
+}
+
+//NewSegmentProxyWithCapacity returns a proxy charged to the budget with room for writing n bytes,
+//see MemorySegmentProxy.Reserve.
+func (b *Budget) NewSegmentProxyWithCapacity(n uint) (MemorySegmentProxyer, error) {
+	msp := b.NewSegmentProxy()
+	if err := msp.Reserve(n); err != nil {
+		return nil, err
+	}
+	return msp, nil
+}
+
+//Name returns the name of the budget, "" for nil.
+func (b *Budget) Name() string {
+	if b == nil {
+		return ""
+	}
+	return b.name
+}
+
+//Stats reports the usage of the budget.
+func (b *Budget) Stats() BudgetStats {
+	b.mp.RLock()
+	defer b.mp.RUnlock()
+	return b.stats()
+}
+
+func (b *Budget) stats() BudgetStats {
+	return BudgetStats{Name: b.name, Min: b.min, Max: b.max, Used: b.used, Rejected: b.rejected}
+}
+
+//Close removes the budget from its provider, releasing its minimum. Its proxies can't borrow anymore,
+//the segments they hold are given back as usual.
+func (b *Budget) Close() {
+	b.mp.Lock()
+	defer b.mp.Unlock()
+	if !b.closed {
+		b.closed = true
+		delete(b.mp.budgets, b.name)
+	}
+}
+
+//admit checks that the budget can borrow n more segments, the caller holds the lock.
+func (b *Budget) admit(n int) error {
+	if b.closed {
+		return ErrBudgetClosed
+	}
+	if b.used+n > b.max {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+//reservedLeft returns how many segments the budget can still borrow within its minimum,
+//from the segments reserved for it.
+func (b *Budget) reservedLeft() int {
+	if b == nil || b.closed || b.used >= b.min {
+		return 0
+	}
+	return b.min - b.used
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (m *MemoryPool) TestBudget_MinimumAndMaximum(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(10*64), WithSegmentSize(64))
+	c.Assert(err, IsNil)
+	quiet, err := mp.NewBudget("quiet", 3, 5)
+	c.Assert(err, IsNil)
+	noisy, err := mp.NewBudget("noisy", 2, 6)
+	c.Assert(err, IsNil)
+
+	//the noisy tenant bursts up to its maximum.
+	burst := noisy.NewSegmentProxy()
+	c.Assert(burst.WriteMemory(make([]byte, 6*64)), IsNil)
+	c.Assert(burst.WriteByte(0), Equals, ErrBudgetExceeded)
+	//unbudgeted borrowers take what's left but the minimum of the quiet tenant.
+	ms, err := mp.GetOneAvailable()
+	c.Assert(err, IsNil)
+	c.Assert(mp.NewSegmentProxy().WriteByte(0), NotNil)
+	//which is still there.
+	msp := quiet.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 3*64)), IsNil)
+	c.Assert(msp.WriteByte(0), NotNil)
+
+	c.Assert(mp.Budgets(), DeepEquals, []BudgetStats{
+		{Name: "noisy", Min: 2, Max: 6, Used: 6, Rejected: 1},
+		{Name: "quiet", Min: 3, Max: 5, Used: 3, Rejected: 1},
+	})
+	burst.Close()
+	c.Assert(noisy.Stats().Used, Equals, 0)
+	c.Assert(mp.Giveback(ms), IsNil)
+	c.Assert(msp.WriteMemory(make([]byte, 2*64)), IsNil)
+	c.Assert(msp.WriteByte(0), Equals, ErrBudgetExceeded)
+	c.Assert(quiet.Stats(), DeepEquals, BudgetStats{Name: "quiet", Min: 3, Max: 5, Used: 5, Rejected: 2})
+	msp.Close()
+	c.Assert(mp.Outstanding(), Equals, 0)
+	c.Assert(mp.Close(), IsNil)
+}
+
+func (m *MemoryPool) TestBudget_Lifecycle(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(4*64), WithSegmentSize(64))
+	c.Assert(err, IsNil)
+	_, err = mp.NewBudget("a", 3, 2)
+	c.Assert(err, Equals, ErrInvalidBudget)
+	_, err = mp.NewBudget("a", 0, 0)
+	c.Assert(err, Equals, ErrInvalidBudget)
+	a, err := mp.NewBudget("a", 3, 4)
+	c.Assert(err, IsNil)
+	_, err = mp.NewBudget("a", 1, 1)
+	c.Assert(err, Equals, ErrBudgetExists)
+	_, err = mp.NewBudget("b", 2, 2)
+	c.Assert(err, Equals, ErrInvalidBudget)
+	c.Assert(mp.Budget("a"), Equals, a)
+	c.Assert(a.Name(), Equals, "a")
+
+	msp := a.NewSegmentProxy()
+	c.Assert(msp.WriteByte(0), IsNil)
+	//closing releases the minimum.
+	a.Close()
+	c.Assert(mp.Budget("a"), IsNil)
+	c.Assert(msp.WriteMemory(make([]byte, 64)), Equals, ErrBudgetClosed)
+	other := mp.NewSegmentProxy()
+	c.Assert(other.WriteMemory(make([]byte, 3*64)), IsNil)
+	other.Close()
+	msp.Close()
+	c.Assert(a.Stats().Used, Equals, 0)
+	c.Assert(mp.Close(), IsNil)
+	_, err = mp.NewBudget("c", 1, 1)
+	c.Assert(err, Equals, ErrProviderClosed)
+}
+
+func (m *MemoryPool) TestBudget_Reserve(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(10*64), WithSegmentSize(64))
+	c.Assert(err, IsNil)
+	a, err := mp.NewBudget("a", 2, 4)
+	c.Assert(err, IsNil)
+	_, err = mp.NewBudget("b", 4, 4)
+	c.Assert(err, IsNil)
+	//a's minimum is always there, the rest of the pool is shared.
+	_, err = a.NewSegmentProxyWithCapacity(5 * 64)
+	c.Assert(err, Equals, ErrBudgetExceeded)
+	msp, err := a.NewSegmentProxyWithCapacity(4 * 64)
+	c.Assert(err, IsNil)
+	c.Assert(a.Stats(), DeepEquals, BudgetStats{Name: "a", Min: 2, Max: 4, Used: 4, Rejected: 5})
+	msp.Close()
+	hog, err := mp.NewSegmentProxyWithCapacity(4 * 64)
+	c.Assert(err, IsNil)
+	_, err = a.NewSegmentProxyWithCapacity(3 * 64)
+	c.Assert(err, Equals, ErrNoMoreSegments)
+	msp, err = a.NewSegmentProxyWithCapacity(2 * 64)
+	c.Assert(err, IsNil)
+	c.Assert(a.Stats().Used, Equals, 2)
+	msp.Close()
+	hog.Close()
+	c.Assert(mp.Close(), IsNil)
+}
@@ -0,0 +1,6 @@
+//go:build !memguard
+
+package memory
+
+//guardPages makes Initialize lay the pool out with guard pages, see MMAP_GUARD_PAGES.
+const guardPages = false
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+//Logger receives the structured records of a provider: a message along with alternating keys and values.
+//*slog.Logger implements it, NewLogrusLogger adapts logrus and the zaplogger package adapts zap.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+//defaultLogger logs through the standard logger of logrus, unless a provider is given its own.
+var defaultLogger Logger = NewLogrusLogger(logrus.StandardLogger())
+
+//SetLogger sets the logger of the provider, which SHOULD be set before initializing it.
+//Passing nil restores the default one, logging through logrus.
+func (mp *MemoryProvider) SetLogger(l Logger) {
+	mp.Lock()
+	defer mp.Unlock()
+	mp.logger = l
+}
+
+//log returns the logger of the provider, the caller doesn't need to hold the lock as long as the logger
+//is set before the provider is used.
+func (mp *MemoryProvider) log() Logger {
+	if mp.logger == nil {
+		return defaultLogger
+	}
+	return mp.logger
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+//NewLogrusLogger adapts a logrus logger or entry, the keys and values become its fields.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return &logrusLogger{l: l}
+}
+
+func (l *logrusLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Debug(msg)
+}
+
+func (l *logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Info(msg)
+}
+
+func (l *logrusLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Warn(msg)
+}
+
+func (l *logrusLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.with(keysAndValues).Error(msg)
+}
+
+func (l *logrusLogger) with(keysAndValues []interface{}) logrus.FieldLogger {
+	if len(keysAndValues) == 0 {
+		return l.l
+	}
+	fields := make(logrus.Fields, len(keysAndValues)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 == len(keysAndValues) {
+			//a key without its value, as slog does.
+			fields["!BADKEY"] = keysAndValues[i]
+			break
+		}
+		fields[key] = keysAndValues[i+1]
+	}
+	return l.l.WithFields(fields)
+}
@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+)
+
+type record struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+//recorder keeps the records logged.
+type recorder struct {
+	records []record
+	lock    sync.Mutex
+}
+
+func (r *recorder) log(level, msg string, keysAndValues []interface{}) {
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records = append(r.records, record{level: level, msg: msg, fields: fields})
+}
+
+func (r *recorder) Debug(msg string, keysAndValues ...interface{}) {
+	r.log("debug", msg, keysAndValues)
+}
+func (r *recorder) Info(msg string, keysAndValues ...interface{}) { r.log("info", msg, keysAndValues) }
+func (r *recorder) Warn(msg string, keysAndValues ...interface{}) { r.log("warn", msg, keysAndValues) }
+func (r *recorder) Error(msg string, keysAndValues ...interface{}) {
+	r.log("error", msg, keysAndValues)
+}
+
+//take returns the records logged since the last call.
+func (r *recorder) take() []record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	records := r.records
+	r.records = nil
+	return records
+}
+
+func (m *MemoryPool) TestLogger_Events(c *C) {
+	r := &recorder{}
+	mp, err := NewMemoryProvider(WithPoolSize(4*64), WithSegmentSize(64), WithLogger(r))
+	c.Assert(err, IsNil)
+	records := r.take()
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].level, Equals, "info")
+	//or the guarded pool with the memguard tag.
+	c.Assert(records[0].msg, Matches, "Initializing Memory .*Pool.*")
+	c.Assert(records[0].fields["size"], Equals, uint(256))
+
+	//exhaustion is logged once until a borrow succeeds again.
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 3*64)), IsNil)
+	c.Assert(msp.WriteMemory(make([]byte, 2*64)), NotNil)
+	c.Assert(msp.WriteMemory(make([]byte, 2*64)), NotNil)
+	c.Assert(r.take(), DeepEquals, []record{{level: "warn", msg: "Memory Pool exhausted",
+		fields: map[string]interface{}{"requested": 2, "free": 1, "reserved": 0, "budget": ""}}})
+	c.Assert(msp.WriteByte(0), IsNil)
+	c.Assert(msp.WriteMemory(make([]byte, 64)), NotNil)
+	c.Assert(r.take(), HasLen, 1)
+
+	ms := msp.(*MemorySegmentProxy).usedSegments[0]
+	msp.Close()
+	c.Assert(mp.Giveback(ms), NotNil)
+	records = r.take()
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].level, Equals, "error")
+	c.Assert(records[0].msg, Equals, "Memory segment given back more than once")
+
+	//segments leaked when the pool is closed.
+	msp = mp.NewSegmentProxy()
+	c.Assert(msp.WriteByte(0), IsNil)
+	c.Assert(mp.Close(), Equals, ErrPoolInUse)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	c.Assert(mp.Drain(ctx), NotNil)
+	c.Assert(r.take(), DeepEquals, []record{
+		{level: "warn", msg: "Closing Memory Pool with segments still in use", fields: map[string]interface{}{"outstanding": 1}},
+		{level: "warn", msg: "Memory Pool not drained, segments still in use",
+			fields: map[string]interface{}{"outstanding": 1, "error": context.DeadlineExceeded}},
+	})
+	msp.Close()
+}
+
+func (m *MemoryPool) TestLogger_Pressure(c *C) {
+	r := &recorder{}
+	mp, err := NewMemoryProvider(WithPoolSize(4*64), WithSegmentSize(64), WithLogger(r))
+	c.Assert(err, IsNil)
+	c.Assert(mp.SetWatermarks(Watermarks{Low: 0.5, High: 0.7}), IsNil)
+	r.take()
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 3*64)), IsNil)
+	msp.Close()
+	c.Assert(r.take(), DeepEquals, []record{
+		{level: "warn", msg: "Memory Pool under pressure", fields: map[string]interface{}{"free": 0.25, "low": 0.5}},
+		{level: "info", msg: "Memory Pool recovered from pressure", fields: map[string]interface{}{"free": 1.0, "high": 0.7}},
+	})
+}
+
+func (m *MemoryPool) TestLogger_Slog(c *C) {
+	buf := &bytes.Buffer{}
+	mp := &MemoryProvider{}
+	mp.SetLogger(slog.New(slog.NewJSONHandler(buf, nil)))
+	mp.Initialize(128, 64)
+	var entry map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &entry), IsNil)
+	c.Assert(entry["level"], Equals, "INFO")
+	c.Assert(entry["msg"], Matches, "Initializing Memory .*Pool.*")
+	c.Assert(entry["size"], Equals, 128.0)
+	c.Assert(entry["segmentSize"], Equals, 64.0)
+}
+
+func (m *MemoryPool) TestLogger_Logrus(c *C) {
+	buf := &bytes.Buffer{}
+	l := logrus.New()
+	l.SetOutput(buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	mp := &MemoryProvider{}
+	mp.SetLogger(NewLogrusLogger(l.WithField("component", "memory")))
+	mp.Initialize(128, 64)
+	var entry map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &entry), IsNil)
+	c.Assert(entry["level"], Equals, "info")
+	c.Assert(entry["msg"], Matches, "Initializing Memory .*Pool.*")
+	c.Assert(entry["size"], Equals, 128.0)
+	c.Assert(entry["component"], Equals, "memory")
+
+	buf.Reset()
+	NewLogrusLogger(l).Warn("odd", "key")
+	c.Assert(json.Unmarshal(buf.Bytes(), &entry), IsNil)
+	c.Assert(entry["!BADKEY"], Equals, "key")
+}
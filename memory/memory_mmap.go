@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"fmt"
+	"os"
+)
+
+var (
+	ErrMmapUnsupported = fmt.Errorf("memory: memory mapped pools are not supported on this platform.")
+)
+
+//flags of InitializeMapped.
+const (
+	//MMAP_HUGEPAGES advises the kernel to back the pool with transparent huge pages (madvise(MADV_HUGEPAGE)).
+	MMAP_HUGEPAGES = 1 << iota
+	//MMAP_HUGETLB maps the pool from the reserved huge pages (MAP_HUGETLB), the size of the pool is rounded up
+	//to a multiple of 2Mi. Mapping fails if there aren't enough huge pages reserved, see /proc/sys/vm/nr_hugepages.
+	MMAP_HUGETLB
+	//MMAP_GUARD_PAGES maps every segment at the end of its own pages, followed by an inaccessible guard page,
+	//so a write overrunning a segment faults right away instead of corrupting the next one. It's meant for
+	//debugging: it takes at least a page and a guard page per segment, every guard page is a mapping of its own
+	//limited by /proc/sys/vm/max_map_count, and the huge pages flags are ignored.
+	//Building with the memguard tag makes Initialize use it, falling back to the heap if mapping fails.
+	MMAP_GUARD_PAGES
+)
+
+const hugePageSize uint = 2 * 1024 * 1024
+
+//InitializeMapped initializes the pool like Initialize but in anonymous memory mapped outside of the Go heap,
+//which the garbage collector neither scans nor accounts for. The pool MUST be released by Close.
+func (mp *MemoryProvider) InitializeMapped(memPoolSize, memSegmentSize uint, flags int) error {
+	if mp.initialized {
+		return ErrAlreadyInitialized
+	}
+	if memPoolSize == 0 {
+		memPoolSize = defMemPoolSize
+	}
+	if memSegmentSize == 0 {
+		memSegmentSize = defmemSegmentSize
+	}
+	if flags&MMAP_GUARD_PAGES != 0 {
+		return mp.initializeGuarded(memPoolSize, memSegmentSize)
+	}
+	if flags&MMAP_HUGETLB != 0 {
+		memPoolSize = (memPoolSize + hugePageSize - 1) / hugePageSize * hugePageSize
+	}
+	mp.log().Info("Initializing Memory Mapped Pool", "size", memPoolSize, "segmentSize", memSegmentSize, "flags", flags)
+	pool, err := mmapPool(memPoolSize, flags)
+	if err != nil {
+		return err
+	}
+	mp.memPool = pool[:0]
+	mp.unmap = func() error { return munmapPool(pool) }
+	mp.initSegments(memSegmentSize, int(memPoolSize/memSegmentSize), mp.sliceSegment, true)
+	return nil
+}
+
+//initializeGuarded lays the pool out as a leading guard page then, for every segment,
+//the pages holding it, right-aligned, and a guard page: the guard pages start every span bytes.
+func (mp *MemoryProvider) initializeGuarded(memPoolSize, memSegmentSize uint) error {
+	page := uint(os.Getpagesize())
+	span := (memSegmentSize+page-1)/page*page + page
+	count := memPoolSize / memSegmentSize
+	size := page + count*span
+	mp.log().Info("Initializing Memory Mapped Pool with guard pages", "size", memPoolSize, "segmentSize", memSegmentSize, "mapped", size)
+	pool, err := mmapPool(size, 0)
+	if err != nil {
+		return err
+	}
+	for offset := uint(0); offset < size; offset += span {
+		if err := protectGuard(pool[offset : offset+page]); err != nil {
+			munmapPool(pool)
+			return err
+		}
+	}
+	//the segments aren't contiguous, the capacity of memPool only tells the size of the pool.
+	mp.memPool = pool[: 0 : count*memSegmentSize]
+	mp.unmap = func() error { return munmapPool(pool) }
+	mp.initSegments(memSegmentSize, int(count), func(index int) []byte {
+		end := uint(index+1) * span
+		//the capacity ends at the guard page too, appending beyond it reallocates.
+		return pool[end-memSegmentSize : end : end]
+	}, true)
+	return nil
+}
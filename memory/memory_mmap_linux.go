@@ -0,0 +1,30 @@
+package memory
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func mmapPool(size uint, flags int) ([]byte, error) {
+	mmapFlags := unix.MAP_PRIVATE | unix.MAP_ANONYMOUS
+	if flags&MMAP_HUGETLB != 0 {
+		mmapFlags |= unix.MAP_HUGETLB
+	}
+	pool, err := unix.Mmap(-1, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, mmapFlags)
+	if err != nil {
+		return nil, err
+	}
+	if flags&MMAP_HUGEPAGES != 0 {
+		//only a hint, kernels without transparent huge pages keep on using regular pages.
+		unix.Madvise(pool, unix.MADV_HUGEPAGE)
+	}
+	return pool, nil
+}
+
+func munmapPool(pool []byte) error {
+	return unix.Munmap(pool)
+}
+
+//protectGuard makes a guard page inaccessible.
+func protectGuard(page []byte) error {
+	return unix.Mprotect(page, unix.PROT_NONE)
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+
+	. "gopkg.in/check.v1"
+)
+
+func (m *MemoryPool) TestInitializeMapped(c *C) {
+	mp := &MemoryProvider{}
+	c.Assert(mp.InitializeMapped(1024, 128, MMAP_HUGEPAGES), IsNil)
+	c.Assert(cap(mp.memPool), Equals, 1024)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(1024/128))
+
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("mapped")), IsNil)
+	c.Assert(string(msp.Buffers()[0]), Equals, "mapped")
+	c.Assert(mp.Close(), Equals, ErrPoolInUse)
+	msp.Close()
+	c.Assert(mp.Close(), IsNil)
+	c.Assert(mp.memPool, IsNil)
+	_, err := mp.GetOneAvailable()
+	c.Assert(err, NotNil)
+	c.Assert(mp.Close(), IsNil)
+}
+
+func (m *MemoryPool) TestInitializeMapped_HugeTLB(c *C) {
+	mp := &MemoryProvider{}
+	if err := mp.InitializeMapped(1024, 128, MMAP_HUGETLB); err != nil {
+		c.Skip("no huge pages reserved: " + err.Error())
+	}
+	defer mp.Close()
+	c.Assert(cap(mp.memPool), Equals, int(hugePageSize))
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(hugePageSize/128))
+}
+
+func (m *MemoryPool) TestInitializeMapped_GuardPages(c *C) {
+	mp := &MemoryProvider{}
+	c.Assert(mp.InitializeMapped(1000, 100, MMAP_GUARD_PAGES), IsNil)
+	defer mp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(10))
+
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(msp.WriteMemory(make([]byte, 250)), IsNil)
+	buffers := msp.Buffers()
+	c.Assert(buffers, HasLen, 3)
+	for _, buf := range buffers[:2] {
+		c.Assert(cap(buf), Equals, 100)
+	}
+	//writing right past a segment faults on its guard page.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	overrun := func(address unsafe.Pointer) (fault interface{}) {
+		defer func() { fault = recover() }()
+		*(*byte)(address) = 0xff
+		return nil
+	}
+	for _, buf := range buffers[:2] {
+		address := unsafe.Add(unsafe.Pointer(&buf[0]), len(buf))
+		fault, ok := overrun(address).(interface{ Addr() uintptr })
+		c.Assert(ok, Equals, true)
+		c.Assert(fault.Addr(), Equals, uintptr(address))
+	}
+}
+
+const benchmarkPoolSize uint = 512 * 1024 * 1024
+
+//benchmarkGC allocates garbage next to a pool, reporting the GC cycles and pauses it takes
+//and the resident memory of the process.
+func benchmarkGC(b *testing.B, mp *MemoryProvider) {
+	defer mp.Close()
+	//touch the whole pool, as a busy process would.
+	msp := mp.NewSegmentProxy()
+	chunk := make([]byte, 1024*1024)
+	for msp.WriteMemory(chunk) == nil {
+	}
+	msp.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	var garbage [][]byte
+	for i := 0; i < b.N; i++ {
+		garbage = append(garbage, make([]byte, 4096))
+		if len(garbage) == 1024 {
+			garbage = nil
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.NumGC-before.NumGC), "gcs")
+	b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "gc-pause-ns/op")
+	b.ReportMetric(float64(after.HeapAlloc)/(1024*1024), "heap-MiB")
+	b.ReportMetric(float64(rss())/(1024*1024), "rss-MiB")
+}
+
+//rss returns the resident memory of the process.
+func rss() uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, _ := strconv.ParseUint(fields[1], 10, 64)
+	return pages * uint64(os.Getpagesize())
+}
+
+func BenchmarkGC_HeapPool(b *testing.B) {
+	mp := &MemoryProvider{}
+	mp.Initialize(benchmarkPoolSize, 4096)
+	benchmarkGC(b, mp)
+}
+
+func BenchmarkGC_MappedPool(b *testing.B) {
+	mp := &MemoryProvider{}
+	if err := mp.InitializeMapped(benchmarkPoolSize, 4096, 0); err != nil {
+		b.Fatal(err)
+	}
+	benchmarkGC(b, mp)
+}
+
+func BenchmarkGC_MappedPool_HugePages(b *testing.B) {
+	mp := &MemoryProvider{}
+	if err := mp.InitializeMapped(benchmarkPoolSize, 4096, MMAP_HUGEPAGES); err != nil {
+		b.Fatal(err)
+	}
+	benchmarkGC(b, mp)
+}
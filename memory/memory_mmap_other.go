@@ -0,0 +1,16 @@
+//go:build !linux
+
+package memory
+
+//mmapPool is only supported on Linux.
+func mmapPool(size uint, flags int) ([]byte, error) {
+	return nil, ErrMmapUnsupported
+}
+
+func munmapPool(pool []byte) error {
+	return ErrMmapUnsupported
+}
+
+func protectGuard(page []byte) error {
+	return ErrMmapUnsupported
+}
@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrInvalidPoolSize    = fmt.Errorf("memory: pool size must be a positive multiple of the segment size.")
+	ErrInvalidSegmentSize = fmt.Errorf("memory: segment size must be positive.")
+)
+
+type providerOptions struct {
+	poolSize    uint
+	segmentSize uint
+	mapped      bool
+	mmapFlags   int
+	//fraction of the memory limit taken by the pool, 0 unless sized automatically.
+	autoSize float64
+	logger   Logger
+	//telemetry is set by WithTelemetry, along with its providers.
+	telemetry      bool
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+//Option configures a provider returned by NewMemoryProvider.
+type Option func(o *providerOptions)
+
+//WithPoolSize sets the size of the pool, 100Mi by default.
+func WithPoolSize(size uint) Option {
+	return func(o *providerOptions) { o.poolSize = size }
+}
+
+//WithSegmentSize sets the size of the segments, 256 bytes by default.
+func WithSegmentSize(size uint) Option {
+	return func(o *providerOptions) { o.segmentSize = size }
+}
+
+//WithMapping maps the pool off-heap, see InitializeMapped and the MMAP_* flags.
+func WithMapping(flags int) Option {
+	return func(o *providerOptions) {
+		o.mapped = true
+		o.mmapFlags = flags
+	}
+}
+
+//WithAutoSize sizes the pool to a fraction of the memory limit of the process, e.g. 0.25: the lowest of
+//the limits of its cgroup (v2 or v1) and of the Go runtime (GOMEMLIMIT), rounded down to segments.
+//The default pool size is used if there's no limit. It overrides WithPoolSize.
+func WithAutoSize(fraction float64) Option {
+	return func(o *providerOptions) { o.autoSize = fraction }
+}
+
+//WithLogger sets the logger of the provider, see MemoryProvider.SetLogger.
+func WithLogger(l Logger) Option {
+	return func(o *providerOptions) { o.logger = l }
+}
+
+//WithTelemetry instruments the provider with OpenTelemetry, see MemoryProvider.SetTelemetry.
+func WithTelemetry(tp trace.TracerProvider, mtp metric.MeterProvider) Option {
+	return func(o *providerOptions) {
+		o.telemetry = true
+		o.tracerProvider = tp
+		o.meterProvider = mtp
+	}
+}
+
+//NewMemoryProvider returns an initialized provider, the pool MUST be a multiple of the segment size.
+//The provider SHOULD be released by Close or Drain.
+func NewMemoryProvider(opts ...Option) (*MemoryProvider, error) {
+	o := providerOptions{poolSize: defMemPoolSize, segmentSize: defmemSegmentSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.segmentSize == 0 {
+		return nil, ErrInvalidSegmentSize
+	}
+	mp := &MemoryProvider{logger: o.logger}
+	if o.telemetry {
+		if err := mp.SetTelemetry(o.tracerProvider, o.meterProvider); err != nil {
+			return nil, err
+		}
+	}
+	if o.autoSize != 0 {
+		size, err := autoPoolSize(o.autoSize, o.segmentSize, mp.log())
+		if err != nil {
+			return nil, err
+		}
+		o.poolSize = size
+	}
+	if o.poolSize == 0 || o.poolSize%o.segmentSize != 0 {
+		return nil, ErrInvalidPoolSize
+	}
+	if o.mapped {
+		if err := mp.InitializeMapped(o.poolSize, o.segmentSize, o.mmapFlags); err != nil {
+			return nil, err
+		}
+		return mp, nil
+	}
+	mp.Initialize(o.poolSize, o.segmentSize)
+	return mp, nil
+}
@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (m *MemoryPool) TestNewMemoryProvider(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(1024), WithSegmentSize(128))
+	c.Assert(err, IsNil)
+	c.Assert(cap(mp.memPool), Equals, 1024)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(8))
+	c.Assert(mp.Close(), IsNil)
+
+	mp, err = NewMemoryProvider()
+	c.Assert(err, IsNil)
+	c.Assert(cap(mp.memPool), Equals, int(defMemPoolSize))
+	c.Assert(mp.memSegmentSize, Equals, defmemSegmentSize)
+	c.Assert(mp.Close(), IsNil)
+
+	_, err = NewMemoryProvider(WithPoolSize(1000), WithSegmentSize(128))
+	c.Assert(err, Equals, ErrInvalidPoolSize)
+	_, err = NewMemoryProvider(WithPoolSize(0))
+	c.Assert(err, Equals, ErrInvalidPoolSize)
+	_, err = NewMemoryProvider(WithPoolSize(64), WithSegmentSize(128))
+	c.Assert(err, Equals, ErrInvalidPoolSize)
+	_, err = NewMemoryProvider(WithSegmentSize(0))
+	c.Assert(err, Equals, ErrInvalidSegmentSize)
+}
+
+func (m *MemoryPool) TestInitializeTwice(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(1024, 128)
+	mp.Initialize(2048, 256)
+	c.Assert(cap(mp.memPool), Equals, 1024)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(8))
+	c.Assert(mp.InitializeMapped(1024, 128, 0), Equals, ErrAlreadyInitialized)
+}
+
+func (m *MemoryPool) TestClose_RefusesBorrows(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(1024), WithSegmentSize(128))
+	c.Assert(err, IsNil)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 200)), IsNil)
+	c.Assert(mp.Outstanding(), Equals, 2)
+
+	c.Assert(mp.Close(), Equals, ErrPoolInUse)
+	c.Assert(mp.NewSegmentProxy().WriteMemory([]byte{1}), Equals, ErrProviderClosed)
+	c.Assert(msp.WriteMemory(make([]byte, 100)), Equals, ErrProviderClosed)
+	c.Assert(mp.memPool, NotNil)
+
+	msp.Close()
+	c.Assert(mp.Outstanding(), Equals, 0)
+	c.Assert(mp.Close(), IsNil)
+	c.Assert(mp.memPool, IsNil)
+}
+
+func (m *MemoryPool) TestDrain(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(1024), WithSegmentSize(128))
+	c.Assert(err, IsNil)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 200)), IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(mp.Drain(ctx), Equals, context.DeadlineExceeded)
+	c.Assert(mp.Outstanding(), Equals, 2)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		msp.Close()
+	}()
+	c.Assert(mp.Drain(context.Background()), IsNil)
+	c.Assert(mp.Outstanding(), Equals, 0)
+	c.Assert(mp.memPool, IsNil)
+	c.Assert(mp.Drain(context.Background()), IsNil)
+}
+
+func (m *MemoryPool) TestDrain_Attached(c *C) {
+	owner, err := NewMemoryProvider(WithPoolSize(1024), WithSegmentSize(128))
+	c.Assert(err, IsNil)
+	defer owner.Close()
+	msp := owner.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("attached")), IsNil)
+	descs, err := owner.Detach(msp)
+	c.Assert(err, IsNil)
+
+	mp := &MemoryProvider{}
+	mp.InitializeFrom(owner.memPool[:1024], 128, false)
+	attached, err := mp.Attach(descs, func(index uint32) { owner.GivebackIndex(index) })
+	c.Assert(err, IsNil)
+	c.Assert(mp.Outstanding(), Equals, 1)
+	c.Assert(mp.Close(), Equals, ErrPoolInUse)
+	drained := make(chan error)
+	go func() { drained <- mp.Drain(context.Background()) }()
+	_, err = mp.Attach(descs, nil)
+	c.Assert(err, Equals, ErrProviderClosed)
+	attached.Close()
+	c.Assert(<-drained, IsNil)
+	c.Assert(owner.Outstanding(), Equals, 0)
+}
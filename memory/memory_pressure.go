@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"fmt"
+)
+
+var ErrInvalidWatermarks = fmt.Errorf("memory: watermarks must be fractions of the pool, the low one below the high one.")
+
+//Watermarks of the free segments of a pool, as fractions of its segments, e.g. {Low: 0.1, High: 0.3}.
+//The pool comes under pressure once less than Low of its segments are free, and recovers once more than High
+//are free again: the gap between them keeps the pressure from flapping.
+type Watermarks struct {
+	Low  float64
+	High float64
+}
+
+//recovered is the channel returned by Recovered while the pool isn't under pressure.
+var recovered = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+//SetWatermarks sets the watermarks of the pool, which isn't under pressure until they're set.
+func (mp *MemoryProvider) SetWatermarks(w Watermarks) error {
+	if w.Low < 0 || w.High > 1 || w.Low >= w.High {
+		return ErrInvalidWatermarks
+	}
+	changed := false
+	defer func() {
+		if changed {
+			mp.notifyPressure()
+		}
+	}()
+	mp.Lock()
+	defer mp.Unlock()
+	mp.watermarks = &w
+	changed = mp.updatePressure()
+	return nil
+}
+
+//OnPressure registers a callback, called with true when the pool comes under pressure and with false when it
+//recovers. Callbacks are called one at a time, outside of the lock of the provider, so they may borrow or give
+//segments back, e.g. by dropping low priority messages.
+func (mp *MemoryProvider) OnPressure(callback func(pressure bool)) {
+	mp.Lock()
+	defer mp.Unlock()
+	mp.pressureCallbacks = append(mp.pressureCallbacks, callback)
+}
+
+//UnderPressure tells whether the pool is under pressure, e.g. for refusing new requests.
+func (mp *MemoryProvider) UnderPressure() bool {
+	mp.RLock()
+	defer mp.RUnlock()
+	return mp.pressure
+}
+
+//Recovered returns a channel closed once the pool isn't under pressure, e.g. for pausing reads from a socket.
+//The channel is already closed if the pool isn't under pressure.
+func (mp *MemoryProvider) Recovered() <-chan struct{} {
+	mp.RLock()
+	defer mp.RUnlock()
+	if !mp.pressure {
+		return recovered
+	}
+	return mp.recovered
+}
+
+//updatePressure moves the pool in or out of pressure from its free segments, the caller holds the lock.
+//It returns whether the pressure changed, the callbacks are called by notifyPressure once the lock is released.
+func (mp *MemoryProvider) updatePressure() bool {
+	if mp.watermarks == nil || len(mp.segments) == 0 {
+		return false
+	}
+	free := mp.freeLocked()
+	if !mp.pressure && free < mp.watermarks.Low {
+		mp.pressure = true
+		mp.recovered = make(chan struct{})
+		return true
+	}
+	if mp.pressure && free > mp.watermarks.High {
+		mp.pressure = false
+		close(mp.recovered)
+		return true
+	}
+	return false
+}
+
+//freeLocked returns the fraction of the segments of the pool which are free, the caller holds the lock.
+func (mp *MemoryProvider) freeLocked() float64 {
+	if len(mp.segments) == 0 {
+		return 0
+	}
+	return float64(*mp.unusedSegmentCount) / float64(len(mp.segments))
+}
+
+//notifyPressure calls the callbacks with the pressure of the pool unless they were already told about it.
+//A caller finding the callbacks being called leaves it to the one calling them, which checks the pressure
+//again afterwards.
+func (mp *MemoryProvider) notifyPressure() {
+	for {
+		if !mp.notifyLock.TryLock() {
+			return
+		}
+		mp.Lock()
+		pressure := mp.pressure
+		changed := pressure != mp.notified
+		mp.notified = pressure
+		callbacks := mp.pressureCallbacks
+		var watermarks Watermarks
+		if mp.watermarks != nil {
+			watermarks = *mp.watermarks
+		}
+		free := mp.freeLocked()
+		mp.Unlock()
+		if changed {
+			if pressure {
+				mp.log().Warn("Memory Pool under pressure", "free", free, "low", watermarks.Low)
+			} else {
+				mp.log().Info("Memory Pool recovered from pressure", "free", free, "high", watermarks.High)
+			}
+			for _, callback := range callbacks {
+				callback(pressure)
+			}
+		}
+		mp.notifyLock.Unlock()
+		mp.RLock()
+		again := mp.pressure != mp.notified
+		mp.RUnlock()
+		if !again {
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (m *MemoryPool) TestWatermarks(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(10*64), WithSegmentSize(64))
+	c.Assert(err, IsNil)
+	c.Assert(mp.SetWatermarks(Watermarks{Low: 0.3, High: 0.2}), Equals, ErrInvalidWatermarks)
+	c.Assert(mp.SetWatermarks(Watermarks{Low: -0.1, High: 0.2}), Equals, ErrInvalidWatermarks)
+	c.Assert(mp.SetWatermarks(Watermarks{Low: 0.2, High: 0.5}), IsNil)
+	var events []bool
+	mp.OnPressure(func(pressure bool) { events = append(events, pressure) })
+
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 8*64)), IsNil)
+	c.Assert(mp.UnderPressure(), Equals, false)
+	c.Assert(msp.WriteByte(0), IsNil)
+	c.Assert(mp.UnderPressure(), Equals, true)
+	c.Assert(events, DeepEquals, []bool{true})
+	recovered := mp.Recovered()
+	select {
+	case <-recovered:
+		c.Fatal("recovered under pressure")
+	default:
+	}
+
+	//between the watermarks the pressure holds.
+	ms := msp.(*MemorySegmentProxy).usedSegments
+	for _, s := range ms[:4] {
+		c.Assert(mp.Giveback(s), IsNil)
+	}
+	msp.(*MemorySegmentProxy).usedSegments = ms[4:]
+	c.Assert(mp.UnderPressure(), Equals, true)
+	c.Assert(mp.Giveback(ms[4]), IsNil)
+	msp.(*MemorySegmentProxy).usedSegments = ms[5:]
+	c.Assert(mp.UnderPressure(), Equals, false)
+	c.Assert(events, DeepEquals, []bool{true, false})
+	<-recovered
+	<-mp.Recovered()
+	msp.Close()
+	c.Assert(mp.Close(), IsNil)
+}
+
+func (m *MemoryPool) TestWatermarks_CallbackGivesBack(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(10*64), WithSegmentSize(64))
+	c.Assert(err, IsNil)
+	c.Assert(mp.SetWatermarks(Watermarks{Low: 0.5, High: 0.7}), IsNil)
+	//low priority messages are dropped under pressure.
+	lowPriority := mp.NewSegmentProxy()
+	c.Assert(lowPriority.WriteMemory(make([]byte, 4*64)), IsNil)
+	var events []bool
+	mp.OnPressure(func(pressure bool) {
+		events = append(events, pressure)
+		if pressure {
+			lowPriority.Close()
+		}
+	})
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 2*64)), IsNil)
+	c.Assert(events, DeepEquals, []bool{true, false})
+	c.Assert(mp.UnderPressure(), Equals, false)
+	msp.Close()
+
+	//a pool already under pressure when the watermarks are set.
+	mp, err = NewMemoryProvider(WithPoolSize(10*64), WithSegmentSize(64))
+	c.Assert(err, IsNil)
+	msp = mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 10*64)), IsNil)
+	c.Assert(mp.SetWatermarks(Watermarks{Low: 0.1, High: 0.2}), IsNil)
+	c.Assert(mp.UnderPressure(), Equals, true)
+	msp.Close()
+	c.Assert(mp.UnderPressure(), Equals, false)
+}
@@ -1,11 +1,13 @@
 package memory
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 
-	log "github.com/sirupsen/logrus"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -16,18 +18,57 @@ var (
 	defmemSegmentSize uint = 256
 )
 
+var (
+	ErrAlreadyInitialized = fmt.Errorf("memory: memory pool already initialized.")
+	ErrProviderClosed     = fmt.Errorf("memory: memory provider closed.")
+	ErrPoolInUse          = fmt.Errorf("memory: segments of the pool are still in use.")
+	ErrNoMoreSegments     = errors.New("No more available memory segments can be use.")
+)
+
 //MemoryProvider providers lots of abilities for managing memory usages internal.
 type MemoryProvider struct {
 	memPool []byte
 	//usedSegments []*memorySegment
 	unusedSegmentHead  *memorySegment
 	unusedSegmentCount *int32
+	memSegmentSize     uint
+	//every segment of the pool, by index.
+	segments []*memorySegment
+	//unmap releases a pool mapped by InitializeMapped.
+	unmap       func() error
+	initialized bool
+	//closed refuses new borrows, the pool is only released by Close or Drain once borrowed dropped to zero.
+	closed   bool
+	borrowed int
+	//drained is closed when the last borrowed segment comes back to a closed provider.
+	drained chan struct{}
+	//budgets carved from the pool, by name.
+	budgets map[string]*Budget
+	//pressure of the pool against its watermarks, see SetWatermarks.
+	watermarks        *Watermarks
+	pressure          bool
+	recovered         chan struct{}
+	pressureCallbacks []func(pressure bool)
+	//notified is the pressure the callbacks were last told about, they're called under notifyLock.
+	notified   bool
+	notifyLock sync.Mutex
+	//logger of the provider, see SetLogger.
+	logger Logger
+	//exhausted is set once a borrow fails for want of segments, until one succeeds.
+	exhausted bool
+	//telemetry of the provider, nil unless SetTelemetry is called.
+	telemetry *telemetry
 	sync.RWMutex
 }
 
 //Initialize memory pool.
 //Passing ZERO(0) will use default values to initializes memory pool.
+//A pool is initialized once, see NewMemoryProvider for a validated configuration.
 func (mp *MemoryProvider) Initialize(memPoolSize, memSegmentSize uint) {
+	if mp.initialized {
+		mp.log().Error("Memory Pool already initialized, keeping it")
+		return
+	}
 	mps := uint(0)
 	mss := uint(0)
 	if memPoolSize == 0 {
@@ -40,15 +81,35 @@ func (mp *MemoryProvider) Initialize(memPoolSize, memSegmentSize uint) {
 	} else {
 		mss = memSegmentSize
 	}
-	log.Infof("Initializing Memory Pool, Size: %d", mps)
-	multiples := mps / mss
+	if guardPages {
+		err := mp.InitializeMapped(mps, mss, MMAP_GUARD_PAGES)
+		if err == nil {
+			return
+		}
+		mp.log().Warn("Failed to initialize Memory Pool with guard pages, falling back to the heap", "error", err)
+	}
+	mp.log().Info("Initializing Memory Pool", "size", mps, "segmentSize", mss)
 	mp.memPool = make([]byte, 0, mps)
+	mp.initSegments(mss, int(mps/mss), mp.sliceSegment, true)
+}
+
+//sliceSegment returns the raw data of a segment laid out contiguously in the pool.
+func (mp *MemoryProvider) sliceSegment(index int) []byte {
+	mss := int(mp.memSegmentSize)
+	return mp.memPool[index*mss : index*mss+mss]
+}
+
+//initSegments splits the pool into segments, they are given to the pool only if available.
+func (mp *MemoryProvider) initSegments(mss uint, multiples int, segmentData func(index int) []byte, available bool) {
+	mp.memSegmentSize = mss
+	mp.initialized = true
 	var initCnt int32 = 0
 	mp.unusedSegmentCount = &initCnt
+	mp.segments = make([]*memorySegment, 0, multiples)
 	//mp.usedSegments = make([]*memorySegment, 0, multiples)
-	for index := 0; index < int(multiples); index++ {
+	for index := 0; index < multiples; index++ {
 		//segment raw data.
-		data := mp.memPool[index*int(mss) : (index*int(mss))+int(mss)]
+		data := segmentData(index)
 		ms := &memorySegment{
 			data:          data,
 			rawDataOffset: uint(index) * mss,
@@ -56,7 +117,10 @@ func (mp *MemoryProvider) Initialize(memPoolSize, memSegmentSize uint) {
 			SegmentLength: mss,
 			bytesLeft:     mss,
 			CurrentStatus: MEM_SEGMENT_STATUS_INIT}
-		mp.Giveback(ms)
+		mp.segments = append(mp.segments, ms)
+	}
+	if available {
+		mp.GivebackN(mp.segments)
 	}
 }
 
@@ -65,32 +129,150 @@ func (mp *MemoryProvider) NewSegmentProxy() MemorySegmentProxyer {
 		usedSegments: []*memorySegment{}}
 }
 
+//NewSegmentProxyWithCapacity returns a proxy with room for writing n bytes, see MemorySegmentProxy.Reserve.
+func (mp *MemoryProvider) NewSegmentProxyWithCapacity(n uint) (MemorySegmentProxyer, error) {
+	msp := mp.NewSegmentProxy()
+	if err := msp.Reserve(n); err != nil {
+		return nil, err
+	}
+	return msp, nil
+}
+
 //GetOneAvailable method returns an in-used memory segment.
 //If there isn't any avaiable memory segment, it'll returns an error immediatelly.
+//Segments reserved for the minimums of the budgets are not available.
 func (mp *MemoryProvider) GetOneAvailable() (*memorySegment, error) {
+	var one [1]*memorySegment
+	mss, err := mp.borrow(1, nil, one[:0])
+	if err != nil {
+		return nil, err
+	}
+	return mss[0], nil
+}
+
+//GetN returns n in-used memory segments taken at once, or none and an error if there aren't enough available.
+func (mp *MemoryProvider) GetN(n int) ([]*memorySegment, error) {
+	return mp.borrow(n, nil, nil)
+}
+
+//borrow appends n segments taken out of the pool on behalf of a budget, if any, to mss: all of them or none.
+func (mp *MemoryProvider) borrow(n int, b *Budget, mss []*memorySegment) ([]*memorySegment, error) {
+	t := mp.telemetry
+	if t == nil {
+		return mp.borrowSegments(n, b, mss)
+	}
+	start := time.Now()
+	mss, err := mp.borrowSegments(n, b, mss)
+	t.recordBorrow(start, err)
+	return mss, err
+}
+
+func (mp *MemoryProvider) borrowSegments(n int, b *Budget, mss []*memorySegment) ([]*memorySegment, error) {
+	changed := false
+	defer func() {
+		if changed {
+			mp.notifyPressure()
+		}
+	}()
 	mp.Lock()
 	defer mp.Unlock()
-	if *mp.unusedSegmentCount == 0 {
-		return nil, errors.New("No more available memory segments can be use.")
+	if mp.closed {
+		return mss, ErrProviderClosed
+	}
+	if n <= 0 {
+		return mss, nil
+	}
+	if b != nil {
+		if err := b.admit(n); err != nil {
+			b.rejected += uint64(n)
+			return mss, err
+		}
+	}
+	//the segments within the minimum of the budget come from its reservation, the others can't take
+	//the reservations of any budget.
+	free := 0
+	if mp.initialized {
+		free = int(*mp.unusedSegmentCount)
+	}
+	reserved := b.reservedLeft()
+	if reserved > n {
+		reserved = n
+	}
+	if free < n || n-reserved > free-mp.reservedSegments() {
+		if b != nil {
+			b.rejected += uint64(n)
+		}
+		if !mp.exhausted {
+			mp.exhausted = true
+			mp.log().Warn("Memory Pool exhausted", "requested", n, "free", free, "reserved", mp.reservedSegments(), "budget", b.Name())
+		}
+		return mss, ErrNoMoreSegments
+	}
+	mp.exhausted = false
+	if b != nil {
+		b.used += n
+	}
+	mp.borrowed += n
+	for i := 0; i < n; i++ {
+		ms := mp.unusedSegmentHead
+		ms.CurrentStatus = MEM_SEGMENT_STATUS_BORROWED
+		ms.budget = b
+		mp.unusedSegmentHead = ms.Previous
+		mss = append(mss, ms)
 	}
-	ms := mp.unusedSegmentHead
-	ms.CurrentStatus = MEM_SEGMENT_STATUS_BORROWED
-	mp.unusedSegmentHead = ms.Previous
 	//decrease counter.
-	atomic.AddInt32(mp.unusedSegmentCount, -1)
-	return ms, nil
+	atomic.AddInt32(mp.unusedSegmentCount, -int32(n))
+	changed = mp.updatePressure()
+	return mss, nil
 }
 
 //Giveback an in-used memory segment.
 func (mp *MemoryProvider) Giveback(ms *memorySegment) error {
-	if ms == nil {
-		return errors.New("Nil Pointer being passed.")
-	}
-	if ms.CurrentStatus != MEM_SEGMENT_STATUS_BORROWED && ms.CurrentStatus != MEM_SEGMENT_STATUS_INIT {
-		return errors.New("CANNOT give the same memory segment more than once!")
+	return mp.GivebackN([]*memorySegment{ms})
+}
+
+//GivebackN gives in-used memory segments back at once. Every segment is checked under the lock, so that one given
+//twice, in the same call included, is refused. The valid segments are given back anyway, the error reports the bad ones.
+func (mp *MemoryProvider) GivebackN(mss []*memorySegment) error {
+	if len(mss) == 0 {
+		return nil
 	}
+	changed := false
+	defer func() {
+		if changed {
+			mp.notifyPressure()
+		}
+	}()
 	mp.Lock()
 	defer mp.Unlock()
+	var err error
+	for _, ms := range mss {
+		if ms == nil {
+			err = errors.New("Nil Pointer being passed.")
+			continue
+		}
+		if ms.CurrentStatus != MEM_SEGMENT_STATUS_BORROWED && ms.CurrentStatus != MEM_SEGMENT_STATUS_INIT {
+			mp.log().Error("Memory segment given back more than once", "segment", ms.rawDataOffset/ms.SegmentLength, "status", ms.CurrentStatus)
+			err = errors.New("CANNOT give the same memory segment more than once!")
+			continue
+		}
+		//giveback marks the segment as pooling, a duplicate further in mss is refused above.
+		mp.giveback(ms)
+	}
+	changed = mp.updatePressure()
+	return err
+}
+
+//giveback puts a segment back into the pool, the caller holds the lock.
+func (mp *MemoryProvider) giveback(ms *memorySegment) {
+	if ms.CurrentStatus == MEM_SEGMENT_STATUS_BORROWED {
+		mp.borrowed--
+		mp.checkDrained()
+	}
+	if ms.budget != nil {
+		ms.budget.used--
+		ms.budget = nil
+	}
 	ms.CurrentStatus = MEM_SEGMENT_STATUS_POOLING
 	ms.usedOffset = 0
 	ms.bytesLeft = ms.SegmentLength
@@ -102,5 +284,76 @@ func (mp *MemoryProvider) Giveback(ms *memorySegment) error {
 	}
 	//increase counter.
 	atomic.AddInt32(mp.unusedSegmentCount, 1)
-	return nil
+}
+
+//Outstanding returns how many segments are borrowed, or attached, and not given back yet.
+func (mp *MemoryProvider) Outstanding() int {
+	mp.RLock()
+	defer mp.RUnlock()
+	return mp.borrowed
+}
+
+//Close refuses new borrows and releases the pool, unmapping it if it was initialized by InitializeMapped.
+//While segments are outstanding it fails with ErrPoolInUse and keeps the pool: calling Close again once
+//they're given back releases it, Drain waits for them.
+func (mp *MemoryProvider) Close() error {
+	mp.Lock()
+	defer mp.Unlock()
+	mp.closed = true
+	if mp.borrowed > 0 {
+		mp.log().Warn("Closing Memory Pool with segments still in use", "outstanding", mp.borrowed)
+		return ErrPoolInUse
+	}
+	return mp.releasePool()
+}
+
+//Drain refuses new borrows, waits for the outstanding segments to be given back then releases the pool.
+//It returns the error of ctx if the segments aren't given back in time, the pool is kept then.
+func (mp *MemoryProvider) Drain(ctx context.Context) error {
+	mp.Lock()
+	mp.closed = true
+	if mp.borrowed > 0 {
+		if mp.drained == nil {
+			mp.drained = make(chan struct{})
+		}
+		drained := mp.drained
+		mp.Unlock()
+		select {
+		case <-ctx.Done():
+			mp.log().Warn("Memory Pool not drained, segments still in use", "outstanding", mp.Outstanding(), "error", ctx.Err())
+			return ctx.Err()
+		case <-drained:
+		}
+		mp.Lock()
+	}
+	defer mp.Unlock()
+	return mp.releasePool()
+}
+
+//checkDrained wakes Drain up once a closed provider has no outstanding segment, the caller holds the lock.
+func (mp *MemoryProvider) checkDrained() {
+	if mp.closed && mp.borrowed == 0 && mp.drained != nil {
+		close(mp.drained)
+		mp.drained = nil
+	}
+}
+
+//releasePool drops the pool, the caller holds the lock.
+func (mp *MemoryProvider) releasePool() error {
+	//nothing waits for a released pool to recover.
+	if mp.pressure {
+		mp.pressure = false
+		close(mp.recovered)
+	}
+	var err error
+	if mp.unmap != nil {
+		err = mp.unmap()
+		mp.unmap = nil
+	}
+	mp.memPool = nil
+	mp.segments = nil
+	mp.unusedSegmentHead = nil
+	var cnt int32 = 0
+	mp.unusedSegmentCount = &cnt
+	return err
 }
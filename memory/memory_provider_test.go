@@ -108,3 +108,86 @@ func (m *MemoryPool) TestInitializeMemoryPool_WithCustomizedParameters_GivebackN
 
 	c.Assert(mp.Giveback(nil), NotNil)
 }
+
+func (m *MemoryPool) TestDetachAttach(c *C) {
+	pool := make([]byte, 4*64)
+	owner := &MemoryProvider{}
+	owner.InitializeFrom(pool, 64, true)
+	c.Assert(*owner.unusedSegmentCount, Equals, int32(4))
+	peer := &MemoryProvider{}
+	peer.InitializeFrom(pool, 64, false)
+	c.Assert(*peer.unusedSegmentCount, Equals, int32(0))
+
+	msp := owner.NewSegmentProxy()
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	c.Assert(msp.WriteMemory(data), IsNil)
+	descs, err := owner.Detach(msp)
+	c.Assert(err, IsNil)
+	c.Assert(descs, HasLen, 2)
+	c.Assert(descs[0].Length, Equals, uint32(64))
+	c.Assert(descs[1].Length, Equals, uint32(36))
+	c.Assert(msp.GetLength(), Equals, 0)
+	msp.Close()
+	c.Assert(*owner.unusedSegmentCount, Equals, int32(2))
+	_, err = owner.Detach(peer.NewSegmentProxy())
+	c.Assert(err, Equals, ErrForeignProxy)
+
+	var released []uint32
+	attached, err := peer.Attach(descs, func(index uint32) { released = append(released, index) })
+	c.Assert(err, IsNil)
+	_, err = peer.Attach(descs[1:], nil)
+	c.Assert(err, Equals, ErrSegmentUnavailable)
+	_, err = peer.Attach([]SegmentDescriptor{{Index: 4}}, nil)
+	c.Assert(err, Equals, ErrSegmentOutOfRange)
+	received := attached.Buffers()
+	c.Assert(received, HasLen, 2)
+	c.Assert(&received[0][0], Equals, &pool[descs[0].Index*64])
+	c.Assert(append(received[0], received[1]...), DeepEquals, data)
+	attached.Close()
+	c.Assert(released, DeepEquals, []uint32{descs[0].Index, descs[1].Index})
+
+	for _, index := range released {
+		c.Assert(owner.GivebackIndex(index), IsNil)
+	}
+	c.Assert(owner.GivebackIndex(released[0]), Equals, ErrSegmentUnavailable)
+	c.Assert(*owner.unusedSegmentCount, Equals, int32(4))
+}
+
+func (m *MemoryPool) TestGetN_GivebackN(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(128, 32)
+	mss, err := mp.GetN(3)
+	c.Assert(err, IsNil)
+	c.Assert(mss, HasLen, 3)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(1))
+	_, err = mp.GetN(2)
+	c.Assert(err, Equals, ErrNoMoreSegments)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(1))
+	c.Assert(mp.Outstanding(), Equals, 3)
+
+	c.Assert(mp.GivebackN(mss), IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+	c.Assert(mp.Outstanding(), Equals, 0)
+	c.Assert(mp.GivebackN(mss), NotNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+	mss, err = mp.GetN(0)
+	c.Assert(err, IsNil)
+	c.Assert(mss, HasLen, 0)
+
+	//a segment twice in the same call is given back once, the other ones anyway.
+	mss, err = mp.GetN(2)
+	c.Assert(err, IsNil)
+	c.Assert(mp.GivebackN([]*memorySegment{mss[0], mss[0], nil, mss[1]}), NotNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+	c.Assert(mp.Outstanding(), Equals, 0)
+	mss, err = mp.GetN(4)
+	c.Assert(err, IsNil)
+	seen := map[*memorySegment]bool{}
+	for _, ms := range mss {
+		seen[ms] = true
+	}
+	c.Assert(seen, HasLen, 4)
+}
@@ -16,6 +16,8 @@ type memorySegment struct {
 	CurrentStatus uint
 	bytesLeft     uint
 	Previous      *memorySegment
+	//budget charged for the segment while borrowed.
+	budget *Budget
 }
 
 type MemorySegmentWriter interface {
@@ -26,6 +28,7 @@ type MemorySegmentWriter interface {
 	GetBuffer() []byte
 	WriteString(value string) error
 	WriteMemory(data []byte) error
+	WriteByte(value byte) error
 	Skip(length uint) error
 	//GetBuffer() ([]byte, error)
 }
@@ -34,6 +37,8 @@ const (
 	MEM_SEGMENT_STATUS_INIT = iota
 	MEM_SEGMENT_STATUS_POOLING
 	MEM_SEGMENT_STATUS_BORROWED
+	//attached by MemoryProvider.Attach, e.g. detached by another process sharing the pool.
+	MEM_SEGMENT_STATUS_ATTACHED
 )
 
 func (ms *memorySegment) HasEnoughMemory(memorySize uint) bool {
@@ -97,6 +102,13 @@ func (ms *memorySegment) WriteMemory(data []byte) error {
 	return nil
 }
 
+func (ms *memorySegment) WriteByte(value byte) error {
+	ms.data[ms.usedOffset] = value
+	ms.usedOffset++
+	ms.bytesLeft--
+	return nil
+}
+
 func (ms *memorySegment) Skip(length uint) error {
 	ms.usedOffset += length
 	ms.bytesLeft -= length
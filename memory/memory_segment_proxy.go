@@ -3,7 +3,8 @@ package memory
 import (
 	"bytes"
 	"fmt"
-	"math"
+	"hash"
+	"io"
 )
 
 type MemorySegmentProxyer interface {
@@ -12,20 +13,56 @@ type MemorySegmentProxyer interface {
 	WriteInt64(value int64, serialization_func func(v int64) ([]byte, error)) error
 	WriteUInt64(value uint64, serialization_func func(v uint64) ([]byte, error)) error
 	WriteString(value string, serialization_func func(v string) ([]byte, error)) error
+	WriteByte(value byte) error
+	WriteMemory(data []byte) error
+	WriteMemoryAt(pos *MemoryPosition, data []byte) error
+	FillFrom(r io.Reader, n uint) (uint, error)
 	GetBuffer() []byte
+	Buffers() [][]byte
+	NewReader() *MemorySegmentReader
 	GetPosition() *MemoryPosition
+	GetLength() int
 	Skip(cnt uint) error
 	GetSegmentCount() int
+	Reserve(n uint) error
+	AppendProxy(other MemorySegmentProxyer) error
+	View(off, n int) (*SegmentView, error)
+	SetHash(h hash.Hash)
 	Close()
 }
 
 var (
 	ErrSerializationFuncMissed = fmt.Errorf("serialization function is required.")
+	ErrPositionOutOfRange      = fmt.Errorf("memory position is out of the written range.")
+	ErrAppendToItself          = fmt.Errorf("memory: proxy appended to itself.")
+	ErrAttachedSegments        = fmt.Errorf("memory: proxy holding attached segments.")
 )
 
 type MemorySegmentProxy struct {
 	mp           *MemoryProvider
 	usedSegments []*memorySegment
+	hash         hash.Hash
+	//release is called for every attached segment when the proxy is closed, see MemoryProvider.Attach.
+	release func(index uint32)
+	//budget charged for the segments borrowed, if any.
+	budget *Budget
+	//reserved segments, borrowed ahead of the writes by Reserve.
+	reserved []*memorySegment
+	//references held on the segments by the views taken, nil until the first one.
+	views *viewRefs
+}
+
+//SetHash makes the proxy feed every byte written from now on into h, segment by segment.
+//Skipped bytes and bytes overwritten by WriteMemoryAt are not hashed. Passing nil stops hashing.
+func (msp *MemorySegmentProxy) SetHash(h hash.Hash) {
+	msp.hash = h
+}
+
+//hashWritten feeds the bytes written into a segment since start into the running hash.
+func (msp *MemorySegmentProxy) hashWritten(seg *memorySegment, start uint) {
+	if msp.hash != nil {
+		msp.hash.Write(seg.data[start:seg.usedOffset])
+	}
 }
 
 func (msp *MemorySegmentProxy) GetSegmentCount() int {
@@ -38,7 +75,9 @@ func (msp *MemorySegmentProxy) WriteInt32(value int32, serialization_func func(v
 		return err
 	}
 	if len(mss) == 1 {
+		start := mss[0].usedOffset
 		mss[0].WriteInt32(value)
+		msp.hashWritten(mss[0], start)
 		return nil
 	}
 	if serialization_func == nil {
@@ -57,7 +96,9 @@ func (msp *MemorySegmentProxy) WriteUInt32(value uint32, serialization_func func
 		return err
 	}
 	if len(mss) == 1 {
+		start := mss[0].usedOffset
 		mss[0].WriteUInt32(value)
+		msp.hashWritten(mss[0], start)
 		return nil
 	}
 	if serialization_func == nil {
@@ -76,7 +117,9 @@ func (msp *MemorySegmentProxy) WriteInt64(value int64, serialization_func func(v
 		return err
 	}
 	if len(mss) == 1 {
+		start := mss[0].usedOffset
 		mss[0].WriteInt64(value)
+		msp.hashWritten(mss[0], start)
 		return nil
 	}
 	if serialization_func == nil {
@@ -95,7 +138,9 @@ func (msp *MemorySegmentProxy) WriteUInt64(value uint64, serialization_func func
 		return err
 	}
 	if len(mss) == 1 {
+		start := mss[0].usedOffset
 		mss[0].WriteUInt64(value)
+		msp.hashWritten(mss[0], start)
 		return nil
 	}
 	if serialization_func == nil {
@@ -119,74 +164,63 @@ func (msp *MemorySegmentProxy) WriteString(value string, serialization_func func
 	return msp.WriteMemory(data)
 }
 
-func (msp *MemorySegmentProxy) WriteMemory(data []byte) error {
-	bytesLeft := len(data)
-	mss, err := msp.getAvailableSegment(uint(bytesLeft))
+func (msp *MemorySegmentProxy) WriteByte(value byte) error {
+	mss, err := msp.getAvailableSegment(1)
 	if err != nil {
 		return err
 	}
-	if len(mss) == 1 {
-		mss[0].WriteBytes(data)
-	} else {
-		currentOffset := 0
-		for i := 0; i < len(mss); i++ {
-			bytesWritten := msp.calcBytesCount(bytesLeft, int(mss[i].bytesLeft))
-			err := mss[i].WriteBytes(data[currentOffset:bytesWritten])
-			currentOffset += bytesWritten
-			bytesLeft -= bytesWritten
-			if err != nil {
-				return err
-			}
-		}
-	}
+	//a single byte always lands on the last segment, new or not.
+	seg := mss[len(mss)-1]
+	start := seg.usedOffset
+	seg.WriteByte(value)
+	msp.hashWritten(seg, start)
 	return nil
 }
 
+func (msp *MemorySegmentProxy) WriteMemory(data []byte) error {
+	mss, err := msp.getAvailableSegment(uint(len(data)))
+	if err != nil {
+		return err
+	}
+	return msp.WriteMemoryToSegments(data, mss)
+}
+
 func (msp *MemorySegmentProxy) WriteMemoryToSegments(data []byte, mss []*memorySegment) error {
+	if msp.hash != nil {
+		msp.hash.Write(data)
+	}
 	bytesLeft := len(data)
 	if len(mss) == 1 {
-		mss[0].WriteBytes(data)
-	} else {
-		currentOffset := 0
-		for i := 0; i < len(mss); i++ {
-			bytesWritten := msp.calcBytesCount(bytesLeft, int(mss[i].bytesLeft))
-			err := mss[i].WriteBytes(data[currentOffset:bytesWritten])
-			currentOffset += bytesWritten
-			bytesLeft -= bytesWritten
-			if err != nil {
-				return err
-			}
+		return mss[0].WriteBytes(data)
+	}
+	currentOffset := 0
+	for i := 0; i < len(mss) && bytesLeft > 0; i++ {
+		bytesWritten := msp.calcBytesCount(bytesLeft, int(mss[i].bytesLeft))
+		err := mss[i].WriteBytes(data[currentOffset : currentOffset+bytesWritten])
+		currentOffset += bytesWritten
+		bytesLeft -= bytesWritten
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
 func (msp *MemorySegmentProxy) calcBytesCount(bytesLeft, segmentBytesLeft int) int {
-	bytesWritten := 0
 	//Calc how much data SHOULD be write into the memory segment.
-	if bytesLeft >= int(defmemSegmentSize) {
-		if segmentBytesLeft >= int(defmemSegmentSize) {
-			bytesWritten = int(defmemSegmentSize)
-		} else {
-			bytesWritten = segmentBytesLeft
-		}
-	} else {
-		if segmentBytesLeft >= bytesLeft {
-			bytesWritten = bytesLeft
-		} else {
-			bytesWritten = segmentBytesLeft
-		}
+	if segmentBytesLeft >= bytesLeft {
+		return bytesLeft
 	}
-	return bytesWritten
+	return segmentBytesLeft
 }
 
 func (msp *MemorySegmentProxy) getAvailableSegment(size uint) ([]*memorySegment, error) {
-	bytesLeft := 0
+	bytesLeft := uint(0)
 	if len(msp.usedSegments) != 0 {
 		if msp.usedSegments[len(msp.usedSegments)-1].HasEnoughMemory(size) {
 			return msp.usedSegments[len(msp.usedSegments)-1:], nil
 		} else {
-			bytesLeft = int(msp.usedSegments[len(msp.usedSegments)-1].bytesLeft)
+			bytesLeft = msp.usedSegments[len(msp.usedSegments)-1].bytesLeft
 		}
 	}
 
@@ -209,20 +243,85 @@ func (msp *MemorySegmentProxy) getAvailableSegment(size uint) ([]*memorySegment,
 	} else {
 		startSegmentIndex = len(msp.usedSegments) - 1
 	}
-	//estimate how many memory segments will be use
-	totalSize := bytesLeft + int(size)
-	segmentCnt := int(math.Ceil(float64(totalSize) / float64(defmemSegmentSize)))
-	//Memory segments allocation.
-	for i := 0; i < segmentCnt; i++ {
-		seg, err := msp.mp.GetOneAvailable()
-		if err != nil {
-			return nil, err
-		}
-		msp.usedSegments = append(msp.usedSegments, seg)
+	//estimate how many new memory segments will be use,
+	//the bytes left on the last segment are consumed first.
+	segmentSize := msp.mp.memSegmentSize
+	segmentCnt := int((size - bytesLeft + segmentSize - 1) / segmentSize)
+	//Memory segments allocation, all of them or none.
+	if err := msp.take(segmentCnt); err != nil {
+		return nil, err
 	}
 	return msp.usedSegments[startSegmentIndex:], nil
 }
 
+//take appends n segments to the used ones, the reserved ones first, all of them or none.
+func (msp *MemorySegmentProxy) take(n int) error {
+	fromReserved := n
+	if fromReserved > len(msp.reserved) {
+		fromReserved = len(msp.reserved)
+	}
+	used, err := msp.mp.borrow(n-fromReserved, msp.budget, msp.usedSegments)
+	if err != nil {
+		return err
+	}
+	msp.usedSegments = append(used, msp.reserved[:fromReserved]...)
+	msp.reserved = msp.reserved[fromReserved:]
+	return nil
+}
+
+//Reserve makes room for writing n more bytes, borrowing every segment needed at once or none,
+//so that the writes within the room can't run out of segments.
+func (msp *MemorySegmentProxy) Reserve(n uint) error {
+	room := uint(len(msp.reserved)) * msp.mp.memSegmentSize
+	if len(msp.usedSegments) > 0 {
+		room += msp.usedSegments[len(msp.usedSegments)-1].bytesLeft
+	}
+	if n <= room {
+		return nil
+	}
+	segmentSize := msp.mp.memSegmentSize
+	reserved, err := msp.mp.borrow(int((n-room+segmentSize-1)/segmentSize), msp.budget, msp.reserved)
+	if err != nil {
+		return err
+	}
+	msp.reserved = reserved
+	return nil
+}
+
+//AppendProxy moves the segments of other to the end of the proxy without copying them, other is left empty
+//and its positions are no longer valid. The last segment written into keeps its unused room in the middle
+//of the proxy, the writes carry on in the last segment of other.
+//Other MUST come from the same provider, MUST NOT hold attached segments, see MemoryProvider.Attach,
+//nor segments shared with views.
+func (msp *MemorySegmentProxy) AppendProxy(other MemorySegmentProxyer) error {
+	o, ok := other.(*MemorySegmentProxy)
+	if !ok || o.mp != msp.mp {
+		return ErrForeignProxy
+	}
+	if o == msp {
+		return ErrAppendToItself
+	}
+	if o.views != nil {
+		return ErrSharedSegments
+	}
+	for _, seg := range o.usedSegments {
+		if seg.CurrentStatus == MEM_SEGMENT_STATUS_ATTACHED {
+			return ErrAttachedSegments
+		}
+	}
+	if msp.hash != nil {
+		for _, buf := range o.Buffers() {
+			msp.hash.Write(buf)
+		}
+	}
+	//the segments stay charged to the budget of other, if any, until they're given back.
+	msp.usedSegments = append(msp.usedSegments, o.usedSegments...)
+	msp.reserved = append(msp.reserved, o.reserved...)
+	o.usedSegments = nil
+	o.reserved = nil
+	return nil
+}
+
 func (msp *MemorySegmentProxy) GetBuffer() []byte {
 	if msp.usedSegments == nil || len(msp.usedSegments) == 0 {
 		return []byte{}
@@ -236,12 +335,26 @@ func (msp *MemorySegmentProxy) GetBuffer() []byte {
 			//Writes used memory data.
 			buff.Write(seg.data[:seg.usedOffset])
 		}
-		//free used memory segment.
-		msp.mp.Giveback(seg)
+		//free used memory segment, unless views still read it, it goes back once they're closed.
+		if msp.views == nil {
+			msp.mp.Giveback(seg)
+		}
 	}
 	return buff.Bytes()
 }
 
+//Buffers returns the written data of every segment without copying it, e.g. for a vectored write.
+//Unlike GetBuffer the segments are kept, the slices are only valid until the proxy is closed.
+func (msp *MemorySegmentProxy) Buffers() [][]byte {
+	buffers := make([][]byte, 0, len(msp.usedSegments))
+	for _, seg := range msp.usedSegments {
+		if seg.usedOffset > 0 {
+			buffers = append(buffers, seg.data[:seg.usedOffset])
+		}
+	}
+	return buffers
+}
+
 func (msp *MemorySegmentProxy) Skip(cnt uint) error {
 	mss, err := msp.getAvailableSegment(cnt)
 	if err != nil {
@@ -252,13 +365,10 @@ func (msp *MemorySegmentProxy) Skip(cnt uint) error {
 		return nil
 	}
 	bytesLeft := cnt
-	for i := 0; i < len(mss); i++ {
-		if mss[i].bytesLeft <= bytesLeft {
-			mss[i].Skip(mss[i].bytesLeft)
-			bytesLeft -= mss[i].bytesLeft
-		} else {
-			mss[i].Skip(bytesLeft)
-		}
+	for i := 0; i < len(mss) && bytesLeft > 0; i++ {
+		skipped := uint(msp.calcBytesCount(int(bytesLeft), int(mss[i].bytesLeft)))
+		mss[i].Skip(skipped)
+		bytesLeft -= skipped
 	}
 	return nil
 }
@@ -275,12 +385,107 @@ func (msp *MemorySegmentProxy) GetPosition() *MemoryPosition {
 	return mp
 }
 
-func (msp *MemorySegmentProxy) Close() {
-	if len(msp.usedSegments) > 0 {
-		for _, s := range msp.usedSegments {
-			msp.mp.Giveback(s)
+//GetLength returns how many bytes have been written (or skipped) so far.
+func (msp *MemorySegmentProxy) GetLength() int {
+	length := 0
+	for _, seg := range msp.usedSegments {
+		length += int(seg.usedOffset)
+	}
+	return length
+}
+
+//WriteMemoryAt overwrites data which has already been written (or skipped) from the specified position,
+//it's used for back-filling a header after its body has been written.
+func (msp *MemorySegmentProxy) WriteMemoryAt(pos *MemoryPosition, data []byte) error {
+	if pos.SegmentIndex >= len(msp.usedSegments) && len(data) > 0 {
+		return ErrPositionOutOfRange
+	}
+	//refuse partial writes.
+	start := pos.SegmentOffset
+	for i := 0; i < pos.SegmentIndex; i++ {
+		start += int(msp.usedSegments[i].usedOffset)
+	}
+	if start+len(data) > msp.GetLength() {
+		return ErrPositionOutOfRange
+	}
+	index := pos.SegmentIndex
+	offset := uint(pos.SegmentOffset)
+	for len(data) > 0 {
+		seg := msp.usedSegments[index]
+		if offset >= seg.usedOffset {
+			//the position may point at the end of a fully used segment.
+			index++
+			offset = 0
+			continue
+		}
+		copied := copy(seg.data[offset:seg.usedOffset], data)
+		data = data[copied:]
+		offset += uint(copied)
+	}
+	return nil
+}
+
+//FillFrom reads up to n bytes from r straight into memory segments.
+//It returns how many bytes have been read, which is less than n only if an error occurred,
+//so that the caller could resume the reading later on.
+func (msp *MemorySegmentProxy) FillFrom(r io.Reader, n uint) (uint, error) {
+	total := uint(0)
+	for total < n {
+		mss, err := msp.getAvailableSegment(1)
+		if err != nil {
+			return total, err
+		}
+		seg := mss[len(mss)-1]
+		size := uint(msp.calcBytesCount(int(n-total), int(seg.bytesLeft)))
+		start := seg.usedOffset
+		read, err := r.Read(seg.data[seg.usedOffset : seg.usedOffset+size])
+		seg.usedOffset += uint(read)
+		seg.bytesLeft -= uint(read)
+		msp.hashWritten(seg, start)
+		total += uint(read)
+		if err == io.EOF {
+			if total == n {
+				return total, nil
+			}
+			return total, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return total, err
 		}
+	}
+	return total, nil
+}
+
+func (msp *MemorySegmentProxy) Close() {
+	if msp.views != nil {
+		//the used segments go back once the last view is closed, the reserved ones at once.
+		msp.mp.GivebackN(msp.reserved)
+		msp.views.segments = msp.usedSegments
+		msp.views.unref()
+		msp.views = nil
+		msp.usedSegments = nil
+		msp.reserved = nil
+		return
+	}
+	if len(msp.usedSegments) > 0 || len(msp.reserved) > 0 {
+		msp.mp.givebackSegments(msp.usedSegments, msp.release)
+		msp.mp.GivebackN(msp.reserved)
 		//clear set.
 		msp.usedSegments = nil
+		msp.reserved = nil
+	}
+}
+
+//givebackSegments gives the segments of a proxy back, the attached ones to their owner, the borrowed ones
+//to the pool at once.
+func (mp *MemoryProvider) givebackSegments(segments []*memorySegment, release func(index uint32)) {
+	borrowed := segments[:0]
+	for _, s := range segments {
+		if s.CurrentStatus == MEM_SEGMENT_STATUS_ATTACHED {
+			mp.release(s, release)
+		} else {
+			borrowed = append(borrowed, s)
+		}
 	}
+	mp.GivebackN(borrowed)
 }
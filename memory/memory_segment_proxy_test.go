@@ -2,6 +2,9 @@ package memory
 
 import (
 	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
 
 	"github.com/gomsg/serializations"
 	. "gopkg.in/check.v1"
@@ -218,3 +221,226 @@ func (m *MemoryProxy) Test_GetPosition(c *C) {
 	//clear resource.
 	msp.Close()
 }
+
+func (m *MemoryProxy) Test_WriteMemoryAt_BackFill(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(128, 8)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("abcdefgh")), IsNil)
+	//the position points at the end of a fully used segment.
+	pos := msp.GetPosition()
+	c.Assert(msp.Skip(6), IsNil)
+	c.Assert(msp.WriteMemory([]byte("xyz")), IsNil)
+	c.Assert(msp.GetLength(), Equals, 17)
+	c.Assert(msp.WriteMemoryAt(pos, []byte("012345")), IsNil)
+	c.Assert(msp.WriteMemoryAt(&MemoryPosition{SegmentIndex: 1, SegmentOffset: 6}, []byte("!!!")), IsNil)
+	c.Assert(msp.WriteMemoryAt(&MemoryPosition{SegmentIndex: 2, SegmentOffset: 0}, []byte("????")), Equals, ErrPositionOutOfRange)
+	c.Assert(string(msp.GetBuffer()), Equals, "abcdefgh012345!!!")
+}
+
+func (m *MemoryProxy) Test_FillFrom(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(128, 8)
+	msp := mp.NewSegmentProxy()
+	r := strings.NewReader("0123456789abcdefghij")
+	n, err := msp.FillFrom(r, 3)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, uint(3))
+	n, err = msp.FillFrom(r, 10)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, uint(10))
+	c.Assert(msp.GetSegmentCount(), Equals, 2)
+	n, err = msp.FillFrom(r, 10)
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+	c.Assert(n, Equals, uint(7))
+	c.Assert(string(msp.GetBuffer()), Equals, "0123456789abcdefghij")
+}
+
+func (m *MemoryProxy) Test_SetHash_AcrossSegments(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(256, 8)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.Skip(2), IsNil)
+	h := crc32.NewIEEE()
+	msp.SetHash(h)
+	c.Assert(msp.WriteInt32(1, serializations.INT32_SERIALIZATION), IsNil)
+	c.Assert(msp.WriteInt32(2, serializations.INT32_SERIALIZATION), IsNil)
+	c.Assert(msp.WriteByte(3), IsNil)
+	c.Assert(msp.WriteMemory([]byte("across several segments")), IsNil)
+	_, err := msp.FillFrom(strings.NewReader("filled"), 6)
+	c.Assert(err, IsNil)
+	msp.SetHash(nil)
+	c.Assert(msp.WriteByte(4), IsNil)
+	c.Assert(msp.WriteMemoryAt(&MemoryPosition{}, []byte{9, 9}), IsNil)
+	data := msp.GetBuffer()
+	c.Assert(h.Sum32(), Equals, crc32.ChecksumIEEE(data[2:len(data)-1]))
+}
+
+func (m *MemoryProxy) Test_Buffers(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(128, 32)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.Buffers(), HasLen, 0)
+	c.Assert(msp.WriteMemory([]byte(strings.Repeat("a", 40))), IsNil)
+	buffers := msp.Buffers()
+	c.Assert(buffers, HasLen, 2)
+	c.Assert(len(buffers[0]), Equals, 32)
+	c.Assert(string(buffers[1]), Equals, "aaaaaaaa")
+	//the segments are still borrowed.
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(2))
+	msp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+}
+
+func (m *MemoryProxy) Test_Write_AllOrNothing(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(128, 32)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("abc")), IsNil)
+	//a write needing more segments than available borrows none.
+	c.Assert(msp.WriteMemory(make([]byte, 128)), Equals, ErrNoMoreSegments)
+	c.Assert(msp.GetSegmentCount(), Equals, 1)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(3))
+	c.Assert(msp.WriteMemory(make([]byte, 29+96)), IsNil)
+	c.Assert(msp.GetLength(), Equals, 128)
+	msp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+}
+
+func (m *MemoryProxy) Test_Reserve(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(128, 32)
+	msp, err := mp.NewSegmentProxyWithCapacity(40)
+	c.Assert(err, IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(2))
+	c.Assert(msp.GetSegmentCount(), Equals, 0)
+	c.Assert(msp.GetLength(), Equals, 0)
+	c.Assert(msp.Buffers(), HasLen, 0)
+
+	//writes within the room take the reserved segments.
+	c.Assert(msp.WriteMemory([]byte(strings.Repeat("a", 30))), IsNil)
+	c.Assert(msp.WriteMemory([]byte("bbbbbbbbbb")), IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(2))
+	c.Assert(msp.GetSegmentCount(), Equals, 2)
+	c.Assert(msp.Reserve(24), IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(2))
+	c.Assert(msp.Reserve(25), IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(1))
+	c.Assert(msp.Reserve(24+32+64), Equals, ErrNoMoreSegments)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(1))
+	data, err := io.ReadAll(msp.NewReader())
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, strings.Repeat("a", 30)+"bbbbbbbbbb")
+	msp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+
+	_, err = mp.NewSegmentProxyWithCapacity(129)
+	c.Assert(err, Equals, ErrNoMoreSegments)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(4))
+}
+
+func (m *MemoryProxy) Test_AppendProxy(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(256, 8)
+	b, err := mp.NewBudget("bodies", 0, 8)
+	c.Assert(err, IsNil)
+	outer := mp.NewSegmentProxy()
+	h := crc32.NewIEEE()
+	outer.SetHash(h)
+	c.Assert(outer.WriteMemory([]byte("head")), IsNil)
+	body := b.NewSegmentProxy()
+	c.Assert(body.WriteMemory([]byte("0123456789")), IsNil)
+	c.Assert(body.Reserve(20), IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(32-1-2-2))
+
+	c.Assert(outer.AppendProxy(body), IsNil)
+	//
+	//	segment-size = 8
+	//--------------------------------------------------
+	//
+	//            seg1
+	//|head----| <-- partially used, in the middle.
+	//            seg2
+	//|01234567|
+	//            seg3
+	//|89------| <-- the writes carry on here.
+	c.Assert(body.GetSegmentCount(), Equals, 0)
+	c.Assert(body.GetLength(), Equals, 0)
+	body.Close()
+	c.Assert(outer.GetSegmentCount(), Equals, 3)
+	c.Assert(outer.GetLength(), Equals, 14)
+	pos := outer.GetPosition()
+	c.Assert(pos.SegmentIndex, Equals, 2)
+	c.Assert(pos.SegmentOffset, Equals, 2)
+
+	c.Assert(outer.WriteMemory([]byte("tail")), IsNil)
+	c.Assert(outer.GetSegmentCount(), Equals, 3)
+	//the reserved segments of body are taken over as well.
+	c.Assert(outer.WriteMemory([]byte("+more bytes")), IsNil)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(32-1-2-2))
+	c.Assert(outer.WriteMemoryAt(&MemoryPosition{SegmentIndex: 0, SegmentOffset: 2}, []byte("ADxx")), IsNil)
+	c.Assert(outer.WriteMemoryAt(pos, []byte("!!")), IsNil)
+	c.Assert(h.Sum32(), Equals, crc32.ChecksumIEEE([]byte("head0123456789tail+more bytes")))
+
+	c.Assert(outer.Buffers(), DeepEquals, [][]byte{
+		[]byte("heAD"), []byte("xx234567"), []byte("89!!il+m"), []byte("ore byte"), []byte("s")})
+	data, err := io.ReadAll(outer.NewReader())
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "heADxx23456789!!il+more bytes")
+	r := outer.NewReader()
+	discarded, err := r.Discard(3)
+	c.Assert(discarded, Equals, 3)
+	c.Assert(err, IsNil)
+	c.Assert(r.Len(), Equals, len(data)-3)
+	next, err := r.ReadByte()
+	c.Assert(err, IsNil)
+	c.Assert(next, Equals, byte('D'))
+	next, err = r.ReadByte()
+	c.Assert(err, IsNil)
+	c.Assert(next, Equals, byte('x'))
+
+	//the segments of body stay charged to its budget until they're given back.
+	c.Assert(b.Stats().Used, Equals, 4)
+	outer.Close()
+	c.Assert(b.Stats().Used, Equals, 0)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(32))
+}
+
+func (m *MemoryProxy) Test_AppendProxy_Refused(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(256, 8)
+	other := &MemoryProvider{}
+	other.Initialize(256, 8)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("data")), IsNil)
+	foreign := other.NewSegmentProxy()
+	c.Assert(foreign.WriteMemory([]byte("foreign")), IsNil)
+	c.Assert(msp.AppendProxy(foreign), Equals, ErrForeignProxy)
+	c.Assert(foreign.GetLength(), Equals, 7)
+	c.Assert(msp.AppendProxy(msp), Equals, ErrAppendToItself)
+
+	c.Assert(msp.GetLength(), Equals, 4)
+
+	//segments attached from a peer sharing the pool stay owned by it.
+	pool := make([]byte, 4*64)
+	owner := &MemoryProvider{}
+	owner.InitializeFrom(pool, 64, true)
+	peer := &MemoryProvider{}
+	peer.InitializeFrom(pool, 64, false)
+	detached := owner.NewSegmentProxy()
+	c.Assert(detached.WriteMemory([]byte("shared")), IsNil)
+	descs, err := owner.Detach(detached)
+	c.Assert(err, IsNil)
+	attached, err := peer.Attach(descs, nil)
+	c.Assert(err, IsNil)
+	receiver := peer.NewSegmentProxy()
+	c.Assert(receiver.AppendProxy(attached), Equals, ErrAttachedSegments)
+	c.Assert(attached.GetLength(), Equals, 6)
+	c.Assert(receiver.GetLength(), Equals, 0)
+
+	receiver.Close()
+	attached.Close()
+	detached.Close()
+	foreign.Close()
+	msp.Close()
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"io"
+)
+
+//MemorySegmentReader reads the data of a memory segment proxy sequentially,
+//segment by segment, without flattening it into a single buffer.
+//
+//The reader DOES NOT own the segments, it must not be used after the proxy was closed.
+type MemorySegmentReader struct {
+	segments      []*memorySegment
+	segmentIndex  int
+	segmentOffset uint
+}
+
+//NewReader returns a reader over all data written into the proxy so far.
+func (msp *MemorySegmentProxy) NewReader() *MemorySegmentReader {
+	return &MemorySegmentReader{segments: msp.usedSegments}
+}
+
+//Len returns the number of unread bytes.
+func (r *MemorySegmentReader) Len() int {
+	n := 0
+	for i := r.segmentIndex; i < len(r.segments); i++ {
+		n += int(r.segments[i].usedOffset)
+	}
+	return n - int(r.segmentOffset)
+}
+
+//current returns the segment being read, moving forward to the next segment which still has unread data.
+func (r *MemorySegmentReader) current() *memorySegment {
+	for r.segmentIndex < len(r.segments) {
+		seg := r.segments[r.segmentIndex]
+		if r.segmentOffset < seg.usedOffset {
+			return seg
+		}
+		r.segmentIndex++
+		r.segmentOffset = 0
+	}
+	return nil
+}
+
+func (r *MemorySegmentReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := 0
+	for n < len(p) {
+		seg := r.current()
+		if seg == nil {
+			break
+		}
+		copied := copy(p[n:], seg.data[r.segmentOffset:seg.usedOffset])
+		r.segmentOffset += uint(copied)
+		n += copied
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (r *MemorySegmentReader) ReadByte() (byte, error) {
+	seg := r.current()
+	if seg == nil {
+		return 0, io.EOF
+	}
+	b := seg.data[r.segmentOffset]
+	r.segmentOffset++
+	return b, nil
+}
+
+//Discard skips the next n bytes, it returns the number of bytes skipped, less than n only at the end of the data.
+func (r *MemorySegmentReader) Discard(n int) (int, error) {
+	skipped := 0
+	for skipped < n {
+		seg := r.current()
+		if seg == nil {
+			return skipped, io.EOF
+		}
+		size := int(seg.usedOffset - r.segmentOffset)
+		if size > n-skipped {
+			size = n - skipped
+		}
+		r.segmentOffset += uint(size)
+		skipped += size
+	}
+	return skipped, nil
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"io"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type MemoryReader struct{}
+
+var _ = Suite(&MemoryReader{})
+
+func (m *MemoryReader) Test_WriteMemory_AcrossSegments(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(64, 8)
+	msp := mp.NewSegmentProxy().(*MemorySegmentProxy)
+	c.Assert(msp.WriteByte(0xff), IsNil)
+	data := []byte("0123456789abcdefghij")
+	c.Assert(msp.WriteMemory(data), IsNil)
+	//1 + 20 bytes takes 3 segments of 8 bytes.
+	c.Assert(msp.GetSegmentCount(), Equals, 3)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(5))
+
+	r := msp.NewReader()
+	c.Assert(r.Len(), Equals, 21)
+	b, err := r.ReadByte()
+	c.Assert(err, IsNil)
+	c.Assert(b, Equals, byte(0xff))
+	all, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(all, DeepEquals, data)
+	c.Assert(r.Len(), Equals, 0)
+	_, err = r.ReadByte()
+	c.Assert(err, Equals, io.EOF)
+
+	c.Assert(msp.GetBuffer(), DeepEquals, append([]byte{0xff}, data...))
+	msp.Close()
+}
+
+func (m *MemoryReader) Test_Read_SmallBuffer(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(64, 8)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("abcdefghijk")), IsNil)
+	r := msp.NewReader()
+	p := make([]byte, 3)
+	got := []byte{}
+	for {
+		n, err := r.Read(p)
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		got = append(got, p[:n]...)
+	}
+	c.Assert(string(got), Equals, "abcdefghijk")
+	msp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(8))
+}
+
+func (m *MemoryReader) Test_Discard(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(64, 8)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("abcdefghijklmnopq")), IsNil)
+	r := msp.NewReader()
+	n, err := r.Discard(10)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+	b, err := r.ReadByte()
+	c.Assert(err, IsNil)
+	c.Assert(b, Equals, byte('k'))
+	n, err = r.Discard(10)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(n, Equals, 6)
+	msp.Close()
+}
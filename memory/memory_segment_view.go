@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+var (
+	ErrSharedSegments = fmt.Errorf("memory: proxy segments shared with views.")
+	ErrViewClosed     = fmt.Errorf("memory: view closed.")
+)
+
+//viewRefs counts the references held on the segments of a proxy, by the proxy itself and by its views.
+//The segments are given back when the last reference is dropped.
+type viewRefs struct {
+	refs    int32
+	mp      *MemoryProvider
+	release func(index uint32)
+	//segments to give back, set when the proxy is closed.
+	segments []*memorySegment
+}
+
+func (r *viewRefs) ref() {
+	atomic.AddInt32(&r.refs, 1)
+}
+
+func (r *viewRefs) unref() {
+	if atomic.AddInt32(&r.refs, -1) == 0 {
+		r.mp.givebackSegments(r.segments, r.release)
+		r.segments = nil
+	}
+}
+
+//SegmentView is a read-only byte range over the segments of a proxy, no byte is copied.
+//
+//A view holds a reference on the segments, they go back to the pool only once the proxy and all its views
+//were closed, so that e.g. a handler can pass a field of a message downstream while closing the frame.
+//The view sees the bytes overwritten by WriteMemoryAt. It is not safe for concurrent use,
+//different views of the same proxy are.
+type SegmentView struct {
+	buffers [][]byte
+	length  int
+	refs    *viewRefs
+}
+
+//View returns a view over the n bytes written from off, ErrPositionOutOfRange if they weren't all written.
+//A proxy with views can't be detached nor appended to another one, GetBuffer keeps its segments.
+func (msp *MemorySegmentProxy) View(off, n int) (*SegmentView, error) {
+	if off < 0 || n < 0 || off+n > msp.GetLength() {
+		return nil, ErrPositionOutOfRange
+	}
+	if msp.views == nil {
+		msp.views = &viewRefs{refs: 1, mp: msp.mp, release: msp.release}
+	}
+	msp.views.ref()
+	return &SegmentView{buffers: sliceBuffers(msp.Buffers(), off, n), length: n, refs: msp.views}, nil
+}
+
+//sliceBuffers returns the buffers holding the n bytes from off.
+func sliceBuffers(buffers [][]byte, off, n int) [][]byte {
+	sliced := [][]byte{}
+	for _, buf := range buffers {
+		if n == 0 {
+			break
+		}
+		if off >= len(buf) {
+			off -= len(buf)
+			continue
+		}
+		buf = buf[off:]
+		off = 0
+		if len(buf) > n {
+			buf = buf[:n]
+		}
+		sliced = append(sliced, buf)
+		n -= len(buf)
+	}
+	return sliced
+}
+
+//Len returns the number of bytes of the view.
+func (v *SegmentView) Len() int {
+	return v.length
+}
+
+//View returns a view over the n bytes of the view from off, holding its own reference on the segments.
+func (v *SegmentView) View(off, n int) (*SegmentView, error) {
+	if v.refs == nil {
+		return nil, ErrViewClosed
+	}
+	if off < 0 || n < 0 || off+n > v.length {
+		return nil, ErrPositionOutOfRange
+	}
+	v.refs.ref()
+	return &SegmentView{buffers: sliceBuffers(v.buffers, off, n), length: n, refs: v.refs}, nil
+}
+
+func (v *SegmentView) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrPositionOutOfRange
+	}
+	if off >= int64(v.length) {
+		return 0, io.EOF
+	}
+	n := 0
+	for _, buf := range sliceBuffers(v.buffers, int(off), v.length-int(off)) {
+		n += copy(p[n:], buf)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	return n, io.EOF
+}
+
+//Bytes returns the bytes of the view, without copying them when they lie in a single segment.
+//The slice returned MUST NOT be modified nor used after the view was closed.
+func (v *SegmentView) Bytes() []byte {
+	if len(v.buffers) == 1 {
+		return v.buffers[0]
+	}
+	data := make([]byte, 0, v.length)
+	for _, buf := range v.buffers {
+		data = append(data, buf...)
+	}
+	return data
+}
+
+//Equal reports whether the view holds the same bytes as b.
+func (v *SegmentView) Equal(b []byte) bool {
+	if len(b) != v.length {
+		return false
+	}
+	for _, buf := range v.buffers {
+		if !bytes.Equal(buf, b[:len(buf)]) {
+			return false
+		}
+		b = b[len(buf):]
+	}
+	return true
+}
+
+//IndexByte returns the index of the first instance of c in the view, -1 if there is none.
+func (v *SegmentView) IndexByte(c byte) int {
+	off := 0
+	for _, buf := range v.buffers {
+		if i := bytes.IndexByte(buf, c); i >= 0 {
+			return off + i
+		}
+		off += len(buf)
+	}
+	return -1
+}
+
+//Close drops the reference of the view on the segments, closing it again does nothing.
+func (v *SegmentView) Close() {
+	if v.refs != nil {
+		v.buffers = nil
+		v.length = 0
+		v.refs.unref()
+		v.refs = nil
+	}
+}
@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"io"
+
+	. "gopkg.in/check.v1"
+)
+
+type MemoryView struct{}
+
+var _ = Suite(&MemoryView{})
+
+func (m *MemoryView) Test_View(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(64, 8)
+	msp := mp.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("key=0123456789abcdef;")), IsNil)
+	c.Assert(msp.GetSegmentCount(), Equals, 3)
+
+	//
+	//	segment-size = 8
+	//--------------------------------------------------
+	//
+	//|key=0123|456789ab|cdef;---|
+	//     |<--- value --->|
+	value, err := msp.View(4, 16)
+	c.Assert(err, IsNil)
+	c.Assert(value.Len(), Equals, 16)
+	c.Assert(value.Equal([]byte("0123456789abcdef")), Equals, true)
+	c.Assert(value.Equal([]byte("0123456789abcdeF")), Equals, false)
+	c.Assert(value.Equal([]byte("0123")), Equals, false)
+	c.Assert(string(value.Bytes()), Equals, "0123456789abcdef")
+	c.Assert(value.IndexByte('a'), Equals, 10)
+	c.Assert(value.IndexByte(';'), Equals, -1)
+
+	p := make([]byte, 6)
+	n, err := value.ReadAt(p, 2)
+	c.Assert(err, IsNil)
+	c.Assert(string(p[:n]), Equals, "234567")
+	n, err = value.ReadAt(p, 12)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(string(p[:n]), Equals, "cdef")
+	_, err = value.ReadAt(p, 16)
+	c.Assert(err, Equals, io.EOF)
+	data, err := io.ReadAll(io.NewSectionReader(value, 0, int64(value.Len())))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0123456789abcdef")
+
+	//a view within a single segment shares its memory.
+	digits, err := value.View(4, 4)
+	c.Assert(err, IsNil)
+	c.Assert(&digits.Bytes()[0], Equals, &msp.Buffers()[1][0])
+	c.Assert(digits.Equal([]byte("4567")), Equals, true)
+	_, err = value.View(10, 7)
+	c.Assert(err, Equals, ErrPositionOutOfRange)
+	_, err = msp.View(20, 2)
+	c.Assert(err, Equals, ErrPositionOutOfRange)
+	empty, err := msp.View(21, 0)
+	c.Assert(err, IsNil)
+	c.Assert(empty.Len(), Equals, 0)
+	c.Assert(empty.Bytes(), HasLen, 0)
+	empty.Close()
+
+	//the segments outlive the proxy until the last view is closed.
+	msp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(5))
+	value.Close()
+	value.Close()
+	_, err = value.View(0, 1)
+	c.Assert(err, Equals, ErrViewClosed)
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(5))
+	c.Assert(string(digits.Bytes()), Equals, "4567")
+	digits.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(8))
+	c.Assert(mp.Outstanding(), Equals, 0)
+}
+
+func (m *MemoryView) Test_View_ClosedFirst(c *C) {
+	mp := &MemoryProvider{}
+	mp.Initialize(64, 8)
+	b, err := mp.NewBudget("views", 0, 8)
+	c.Assert(err, IsNil)
+	msp := b.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("0123456789")), IsNil)
+	c.Assert(msp.Reserve(8), IsNil)
+	view, err := msp.View(0, 10)
+	c.Assert(err, IsNil)
+	view.Close()
+	c.Assert(b.Stats().Used, Equals, 3)
+
+	//the views taken keep the proxy from being moved away.
+	view, err = msp.View(8, 2)
+	c.Assert(err, IsNil)
+	_, err = mp.Detach(msp)
+	c.Assert(err, Equals, ErrSharedSegments)
+	c.Assert(mp.NewSegmentProxy().AppendProxy(msp), Equals, ErrSharedSegments)
+
+	//the reserved segment goes back with the proxy.
+	msp.Close()
+	c.Assert(b.Stats().Used, Equals, 2)
+	c.Assert(view.Equal([]byte("89")), Equals, true)
+	view.Close()
+	c.Assert(b.Stats().Used, Equals, 0)
+
+	//a closed proxy can be written into again, with views of its own which GetBuffer doesn't free.
+	c.Assert(msp.WriteMemory([]byte("again")), IsNil)
+	view, err = msp.View(1, 3)
+	c.Assert(err, IsNil)
+	c.Assert(string(msp.GetBuffer()), Equals, "again")
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(7))
+	c.Assert(string(view.Bytes()), Equals, "gai")
+	view.Close()
+	msp.Close()
+	c.Assert(*mp.unusedSegmentCount, Equals, int32(8))
+}
@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"fmt"
+)
+
+var (
+	ErrForeignProxy       = fmt.Errorf("memory: proxy not borrowed from this provider.")
+	ErrSegmentOutOfRange  = fmt.Errorf("memory: segment index out of range.")
+	ErrSegmentUnavailable = fmt.Errorf("memory: segment already in use.")
+)
+
+//SegmentDescriptor identifies the bytes written into a segment by the index of the segment in the pool,
+//which stays the same across processes mapping the same pool.
+type SegmentDescriptor struct {
+	Index  uint32
+	Length uint32
+}
+
+//InitializeFrom initializes the provider over an existing pool, e.g. memory mapped from a file shared between
+//processes. The pool is split into segments of memSegmentSize bytes, which can only be reached through Attach
+//unless available is true.
+func (mp *MemoryProvider) InitializeFrom(pool []byte, memSegmentSize uint, available bool) {
+	if mp.initialized {
+		mp.log().Error("Memory Pool already initialized, keeping it")
+		return
+	}
+	mp.log().Info("Initializing Memory Pool from existing memory", "size", len(pool), "segmentSize", memSegmentSize)
+	mp.memPool = pool[:0:len(pool)]
+	mp.initSegments(memSegmentSize, len(pool)/int(memSegmentSize), mp.sliceSegment, available)
+}
+
+//Detach takes the segments away from a proxy borrowed from this provider, leaving the proxy empty.
+//The segments stay borrowed until given back by GivebackIndex, e.g. once another process sharing the pool
+//attached and released them.
+func (mp *MemoryProvider) Detach(msp MemorySegmentProxyer) ([]SegmentDescriptor, error) {
+	p, ok := msp.(*MemorySegmentProxy)
+	if !ok || p.mp != mp {
+		return nil, ErrForeignProxy
+	}
+	if p.views != nil {
+		return nil, ErrSharedSegments
+	}
+	descs := make([]SegmentDescriptor, len(p.usedSegments))
+	for i, s := range p.usedSegments {
+		descs[i] = SegmentDescriptor{Index: uint32(s.rawDataOffset / mp.memSegmentSize), Length: uint32(s.usedOffset)}
+	}
+	p.usedSegments = nil
+	return descs, nil
+}
+
+//Attach returns a proxy over segments detached elsewhere, no byte is copied.
+//The segments MUST NOT be available in this provider, release is called with the index of every segment
+//when the proxy is closed.
+func (mp *MemoryProvider) Attach(descs []SegmentDescriptor, release func(index uint32)) (MemorySegmentProxyer, error) {
+	mp.Lock()
+	defer mp.Unlock()
+	if mp.closed {
+		return nil, ErrProviderClosed
+	}
+	segments := make([]*memorySegment, 0, len(descs))
+	for _, desc := range descs {
+		if int(desc.Index) >= len(mp.segments) || uint(desc.Length) > mp.memSegmentSize {
+			mp.resetAttached(segments)
+			return nil, ErrSegmentOutOfRange
+		}
+		ms := mp.segments[desc.Index]
+		if ms.CurrentStatus != MEM_SEGMENT_STATUS_INIT {
+			mp.resetAttached(segments)
+			return nil, ErrSegmentUnavailable
+		}
+		ms.CurrentStatus = MEM_SEGMENT_STATUS_ATTACHED
+		ms.usedOffset = uint(desc.Length)
+		ms.bytesLeft = ms.SegmentLength - ms.usedOffset
+		segments = append(segments, ms)
+	}
+	mp.borrowed += len(segments)
+	return &MemorySegmentProxy{mp: mp, usedSegments: segments, release: release}, nil
+}
+
+func (mp *MemoryProvider) resetAttached(segments []*memorySegment) {
+	for _, ms := range segments {
+		ms.CurrentStatus = MEM_SEGMENT_STATUS_INIT
+		ms.usedOffset = 0
+		ms.bytesLeft = ms.SegmentLength
+	}
+}
+
+//release hands an attached segment back to its owner.
+func (mp *MemoryProvider) release(ms *memorySegment, release func(index uint32)) {
+	mp.Lock()
+	mp.resetAttached([]*memorySegment{ms})
+	mp.borrowed--
+	mp.checkDrained()
+	mp.Unlock()
+	if release != nil {
+		release(uint32(ms.rawDataOffset / mp.memSegmentSize))
+	}
+}
+
+//GivebackIndex gives a detached segment back to the pool by its index.
+func (mp *MemoryProvider) GivebackIndex(index uint32) error {
+	mp.Lock()
+	if int(index) >= len(mp.segments) {
+		mp.Unlock()
+		return ErrSegmentOutOfRange
+	}
+	ms := mp.segments[index]
+	if ms.CurrentStatus != MEM_SEGMENT_STATUS_BORROWED {
+		mp.Unlock()
+		return ErrSegmentUnavailable
+	}
+	mp.giveback(ms)
+	changed := mp.updatePressure()
+	mp.Unlock()
+	if changed {
+		mp.notifyPressure()
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//instrumentationName is the name of the tracer and of the meter of the package.
+const instrumentationName = "github.com/gomsg/memory"
+
+//telemetry holds the instruments of a provider, see SetTelemetry.
+type telemetry struct {
+	tracer         trace.Tracer
+	borrowWait     metric.Float64Histogram
+	borrowFailures metric.Int64Counter
+	marshalSize    metric.Int64Histogram
+	registration   metric.Registration
+}
+
+//reasons of the failed borrows, as the reason attribute of gomsg.memory.borrow.failures.
+var (
+	reasonExhausted = metric.WithAttributeSet(attribute.NewSet(attribute.String("reason", "exhausted")))
+	reasonClosed    = metric.WithAttributeSet(attribute.NewSet(attribute.String("reason", "closed")))
+	reasonBudget    = metric.WithAttributeSet(attribute.NewSet(attribute.String("reason", "budget")))
+)
+
+//SetTelemetry instruments the provider with OpenTelemetry, nil providers fall back to the global ones of otel.
+//It SHOULD be set before the provider is used, telemetry being off until then since borrowing is on the hot path.
+//
+//Metrics:
+//
+//	gomsg.memory.borrow.wait       histogram, seconds a borrow waited for the pool and its segments
+//	gomsg.memory.borrow.failures   counter, borrows refused by reason: exhausted, closed or budget
+//	gomsg.memory.segments.borrowed gauge, segments borrowed and not given back yet
+//	gomsg.memory.segments.free     gauge, segments left in the pool
+//	gomsg.memory.marshal.size      histogram, bytes written by Marshal
+//
+//Marshal runs within a gomsg.memory.marshal span.
+func (mp *MemoryProvider) SetTelemetry(tp trace.TracerProvider, mtp metric.MeterProvider) error {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mtp == nil {
+		mtp = otel.GetMeterProvider()
+	}
+	meter := mtp.Meter(instrumentationName)
+	t := &telemetry{tracer: tp.Tracer(instrumentationName)}
+	var err error
+	if t.borrowWait, err = meter.Float64Histogram("gomsg.memory.borrow.wait", metric.WithUnit("s"),
+		metric.WithDescription("Time a borrow waited for the pool and its segments.")); err != nil {
+		return err
+	}
+	if t.borrowFailures, err = meter.Int64Counter("gomsg.memory.borrow.failures", metric.WithUnit("{borrow}"),
+		metric.WithDescription("Borrows refused, by reason.")); err != nil {
+		return err
+	}
+	if t.marshalSize, err = meter.Int64Histogram("gomsg.memory.marshal.size", metric.WithUnit("By"),
+		metric.WithDescription("Bytes written by a marshaling.")); err != nil {
+		return err
+	}
+	borrowed, err := meter.Int64ObservableGauge("gomsg.memory.segments.borrowed", metric.WithUnit("{segment}"),
+		metric.WithDescription("Segments borrowed and not given back yet."))
+	if err != nil {
+		return err
+	}
+	free, err := meter.Int64ObservableGauge("gomsg.memory.segments.free", metric.WithUnit("{segment}"),
+		metric.WithDescription("Segments left in the pool."))
+	if err != nil {
+		return err
+	}
+	if t.registration, err = meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		mp.RLock()
+		defer mp.RUnlock()
+		o.ObserveInt64(borrowed, int64(mp.borrowed))
+		if mp.unusedSegmentCount != nil {
+			o.ObserveInt64(free, int64(*mp.unusedSegmentCount))
+		}
+		return nil
+	}, borrowed, free); err != nil {
+		return err
+	}
+
+	mp.Lock()
+	previous := mp.telemetry
+	mp.telemetry = t
+	mp.Unlock()
+	//unregistering outside the lock, the callback takes it while being collected.
+	if previous != nil {
+		return previous.registration.Unregister()
+	}
+	return nil
+}
+
+//recordBorrow records how long a borrow which started at start waited, and why it failed if it did.
+func (t *telemetry) recordBorrow(start time.Time, err error) {
+	ctx := context.Background()
+	t.borrowWait.Record(ctx, time.Since(start).Seconds())
+	switch err {
+	case nil:
+	case ErrNoMoreSegments:
+		t.borrowFailures.Add(ctx, 1, reasonExhausted)
+	case ErrProviderClosed:
+		t.borrowFailures.Add(ctx, 1, reasonClosed)
+	default:
+		t.borrowFailures.Add(ctx, 1, reasonBudget)
+	}
+}
+
+//Marshal runs marshal writing into msp within a span child of the one of ctx, recording how many bytes
+//and segments it took. Without telemetry, see SetTelemetry, it merely runs marshal.
+func (mp *MemoryProvider) Marshal(ctx context.Context, msp MemorySegmentProxyer, marshal func(w MemorySegmentProxyer) error) error {
+	t := mp.telemetry
+	if t == nil {
+		return marshal(msp)
+	}
+	ctx, span := t.tracer.Start(ctx, "gomsg.memory.marshal")
+	defer span.End()
+	before := msp.GetLength()
+	err := marshal(msp)
+	size := msp.GetLength() - before
+	span.SetAttributes(attribute.Int("gomsg.memory.bytes", size), attribute.Int("gomsg.memory.segments", msp.GetSegmentCount()))
+	t.marshalSize.Record(ctx, int64(size))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
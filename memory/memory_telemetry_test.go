@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	. "gopkg.in/check.v1"
+)
+
+//collect returns the metrics collected by reader, by name.
+func collect(c *C, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	var rm metricdata.ResourceMetrics
+	c.Assert(reader.Collect(context.Background(), &rm), IsNil)
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func (m *MemoryPool) TestTelemetry(c *C) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	mtp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	mp, err := NewMemoryProvider(WithPoolSize(64), WithSegmentSize(16), WithTelemetry(tp, mtp), WithLogger(&recorder{}))
+	c.Assert(err, IsNil)
+
+	msp := mp.NewSegmentProxy()
+	c.Assert(mp.Marshal(context.Background(), msp, func(w MemorySegmentProxyer) error {
+		return w.WriteMemory(make([]byte, 40))
+	}), IsNil)
+	c.Assert(mp.Marshal(context.Background(), msp, func(w MemorySegmentProxyer) error {
+		return w.WriteMemory(make([]byte, 40))
+	}), Equals, ErrNoMoreSegments)
+
+	spans := exporter.GetSpans()
+	c.Assert(spans, HasLen, 2)
+	c.Assert(spans[0].Name, Equals, "gomsg.memory.marshal")
+	c.Assert(spans[0].Attributes, DeepEquals, []attribute.KeyValue{
+		attribute.Int("gomsg.memory.bytes", 40), attribute.Int("gomsg.memory.segments", 3)})
+	c.Assert(spans[0].Status.Code, Equals, codes.Unset)
+	c.Assert(spans[1].Attributes[0], Equals, attribute.Int("gomsg.memory.bytes", 0))
+	c.Assert(spans[1].Status.Code, Equals, codes.Error)
+	c.Assert(spans[1].Events[0].Name, Equals, "exception")
+
+	metrics := collect(c, reader)
+	wait := metrics["gomsg.memory.borrow.wait"].(metricdata.Histogram[float64])
+	//3 segments taken at once, then 3 more refused since only one is left.
+	c.Assert(wait.DataPoints[0].Count, Equals, uint64(2))
+	failures := metrics["gomsg.memory.borrow.failures"].(metricdata.Sum[int64])
+	c.Assert(failures.DataPoints, HasLen, 1)
+	c.Assert(failures.DataPoints[0].Value, Equals, int64(1))
+	reason, _ := failures.DataPoints[0].Attributes.Value("reason")
+	c.Assert(reason.AsString(), Equals, "exhausted")
+	borrowed := metrics["gomsg.memory.segments.borrowed"].(metricdata.Gauge[int64])
+	c.Assert(borrowed.DataPoints[0].Value, Equals, int64(3))
+	free := metrics["gomsg.memory.segments.free"].(metricdata.Gauge[int64])
+	c.Assert(free.DataPoints[0].Value, Equals, int64(1))
+	size := metrics["gomsg.memory.marshal.size"].(metricdata.Histogram[int64])
+	c.Assert(size.DataPoints[0].Count, Equals, uint64(2))
+	c.Assert(size.DataPoints[0].Sum, Equals, int64(40))
+
+	msp.Close()
+	c.Assert(mp.Close(), IsNil)
+}
+
+func (m *MemoryPool) TestTelemetry_Off(c *C) {
+	mp, err := NewMemoryProvider(WithPoolSize(64), WithSegmentSize(16))
+	c.Assert(err, IsNil)
+	msp := mp.NewSegmentProxy()
+	c.Assert(mp.Marshal(context.Background(), msp, func(w MemorySegmentProxyer) error {
+		return w.WriteMemory([]byte("marshaled"))
+	}), IsNil)
+	c.Assert(string(msp.GetBuffer()), Equals, "marshaled")
+	msp.Close()
+	c.Assert(mp.Close(), IsNil)
+}
@@ -0,0 +1,32 @@
+//Package zaplogger adapts zap to memory.Logger, apart from the memory package so that it doesn't depend on zap.
+package zaplogger
+
+import (
+	"github.com/gomsg/memory"
+	"go.uber.org/zap"
+)
+
+type logger struct {
+	l *zap.SugaredLogger
+}
+
+//New adapts a zap logger, the keys and values become its fields.
+func New(l *zap.Logger) memory.Logger {
+	return &logger{l: l.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+func (l *logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.l.Debugw(msg, keysAndValues...)
+}
+
+func (l *logger) Info(msg string, keysAndValues ...interface{}) {
+	l.l.Infow(msg, keysAndValues...)
+}
+
+func (l *logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.l.Warnw(msg, keysAndValues...)
+}
+
+func (l *logger) Error(msg string, keysAndValues ...interface{}) {
+	l.l.Errorw(msg, keysAndValues...)
+}
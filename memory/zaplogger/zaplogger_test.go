@@ -0,0 +1,31 @@
+package zaplogger
+
+import (
+	"testing"
+
+	"github.com/gomsg/memory"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type ZapSuite struct{}
+
+var _ = Suite(&ZapSuite{})
+
+func (s *ZapSuite) TestNew(c *C) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	mp, err := memory.NewMemoryProvider(memory.WithPoolSize(128), memory.WithSegmentSize(64), memory.WithLogger(New(zap.New(core))))
+	c.Assert(err, IsNil)
+	defer mp.Close()
+	entries := logs.TakeAll()
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Level, Equals, zapcore.InfoLevel)
+	c.Assert(entries[0].Message, Matches, "Initializing Memory .*Pool.*")
+	c.Assert(entries[0].ContextMap()["size"], Equals, uint64(128))
+	c.Assert(entries[0].ContextMap()["segmentSize"], Equals, uint64(64))
+}
@@ -0,0 +1,150 @@
+package pubsub
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gomsg/memory"
+)
+
+//Subscription receives the messages whose topic matches its pattern through a bounded queue.
+//
+//Publishers never wait for a slow subscriber: a message arriving while the queue is full is dropped
+//for that subscriber and counted by Dropped.
+type Subscription struct {
+	Pattern     string
+	words       []string
+	queue       chan *Message
+	dropped     uint64
+	unsubscribe func() error
+	once        sync.Once
+}
+
+func newSubscription(pattern string, queueSize int) (*Subscription, error) {
+	words, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	return &Subscription{Pattern: pattern, words: words, queue: make(chan *Message, queueSize)}, nil
+}
+
+//C returns the queue of the subscription, it's closed once unsubscribed. Every message received MUST be released.
+func (s *Subscription) C() <-chan *Message {
+	return s.queue
+}
+
+//Dropped returns how many messages were dropped because the queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+//Unsubscribe stops the subscription, the messages still queued are released.
+func (s *Subscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		err = s.unsubscribe()
+	})
+	return err
+}
+
+//offer queues a message without waiting, it takes a reference to the message only if it was queued.
+//The caller MUST prevent the queue from being closed meanwhile.
+func (s *Subscription) offer(m *Message) bool {
+	m.Retain()
+	select {
+	case s.queue <- m:
+		return true
+	default:
+		m.Release()
+		atomic.AddUint64(&s.dropped, 1)
+		return false
+	}
+}
+
+//close closes the queue and releases the messages left in it.
+func (s *Subscription) close() {
+	close(s.queue)
+	for m := range s.queue {
+		m.Release()
+	}
+}
+
+//Broker is an in-process broker, it's safe for concurrent use.
+type Broker struct {
+	mp     *memory.MemoryProvider
+	subs   map[*Subscription]struct{}
+	closed bool
+	sync.RWMutex
+}
+
+//NewBroker returns a broker serializing the published messages into segments borrowed from mp.
+func NewBroker(mp *memory.MemoryProvider) *Broker {
+	return &Broker{mp: mp, subs: make(map[*Subscription]struct{})}
+}
+
+//Subscribe returns a subscription whose queue holds up to queueSize messages.
+func (b *Broker) Subscribe(pattern string, queueSize int) (*Subscription, error) {
+	s, err := newSubscription(pattern, queueSize)
+	if err != nil {
+		return nil, err
+	}
+	s.unsubscribe = func() error {
+		b.Lock()
+		defer b.Unlock()
+		if _, ok := b.subs[s]; ok {
+			delete(b.subs, s)
+			s.close()
+		}
+		return nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	b.subs[s] = struct{}{}
+	return s, nil
+}
+
+//Publish sends a copy of the payload to every matching subscription, the caller keeps the payload.
+//It returns how many subscriptions the message was queued for.
+func (b *Broker) Publish(topic string, payload memory.MemorySegmentProxyer) (int, error) {
+	m, err := newMessage(b.mp, topic, payload)
+	if err != nil {
+		return 0, err
+	}
+	return b.publish(m)
+}
+
+//publish fans a message out and drops the reference of the publisher.
+func (b *Broker) publish(m *Message) (int, error) {
+	defer m.Release()
+	topic := strings.Split(m.Topic, ".")
+	b.RLock()
+	defer b.RUnlock()
+	if b.closed {
+		return 0, ErrBrokerClosed
+	}
+	queued := 0
+	for s := range b.subs {
+		if match(s.words, topic) && s.offer(m) {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+//Close unsubscribes every subscription, publishing fails afterwards.
+func (b *Broker) Close() {
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	for s := range b.subs {
+		delete(b.subs, s)
+		s.close()
+	}
+}
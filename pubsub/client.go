@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	"github.com/gomsg/rpc"
+	"github.com/gomsg/transport"
+)
+
+//Client publishes and subscribes through a remote broker, it's safe for concurrent use.
+type Client struct {
+	rpc    *rpc.Client
+	mp     *memory.MemoryProvider
+	subs   map[uint64]*Subscription
+	nextID uint64
+	lock   sync.Mutex
+}
+
+//Dial connects to a pubsub Server, messages are serialized into and delivered into segments borrowed from mp.
+func Dial(addr string, mp *memory.MemoryProvider) (*Client, error) {
+	rc, err := rpc.Dial(addr, mp)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{rpc: rc, mp: mp, subs: make(map[uint64]*Subscription)}
+	rc.Conn().HandleFunc(MESSAGE_TYPE_DELIVER, c.deliver)
+	return c, nil
+}
+
+//Publish sends a message to the broker, the caller keeps the payload.
+//Like publishing to a Broker, nothing waits for the subscribers.
+func (c *Client) Publish(topic string, payload memory.MemorySegmentProxyer) error {
+	m, err := newMessage(c.mp, topic, payload)
+	if err != nil {
+		return err
+	}
+	defer m.Release()
+	return c.rpc.Conn().Send(MESSAGE_TYPE_PUBLISH, 0, 0, m.body)
+}
+
+//Subscribe returns a subscription whose queue holds up to queueSize messages, the broker queues as many for it,
+//up to the MaxQueueSize of the server.
+func (c *Client) Subscribe(ctx context.Context, pattern string, queueSize int) (*Subscription, error) {
+	s, err := newSubscription(pattern, queueSize)
+	if err != nil {
+		return nil, err
+	}
+	id := atomic.AddUint64(&c.nextID, 1)
+	req := make(rpc.Bytes, 12+len(pattern))
+	binary.LittleEndian.PutUint64(req, id)
+	binary.LittleEndian.PutUint32(req[8:], uint32(cap(s.queue)))
+	copy(req[12:], pattern)
+	s.unsubscribe = func() error {
+		c.forget(id)
+		return c.rpc.Call(context.Background(), methodUnsubscribe, req[:8], nil)
+	}
+
+	c.lock.Lock()
+	c.subs[id] = s
+	c.lock.Unlock()
+	if err := c.rpc.Call(ctx, methodSubscribe, req, nil); err != nil {
+		c.forget(id)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Client) forget(id uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s := c.subs[id]; s != nil {
+		delete(c.subs, id)
+		s.close()
+	}
+}
+
+func (c *Client) deliver(conn *transport.Conn, f *frame.Frame) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := c.subs[f.CorrelationID]
+	if s == nil {
+		f.Close()
+		return
+	}
+	m, err := parseMessage(f.Body)
+	if err != nil {
+		f.Close()
+		return
+	}
+	f.Body = nil
+	s.offer(m)
+	m.Release()
+}
+
+//Close closes the connection and the queues of the subscriptions.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	for id, s := range c.subs {
+		delete(c.subs, id)
+		s.close()
+	}
+	c.lock.Unlock()
+	return c.rpc.Close()
+}
@@ -0,0 +1,159 @@
+//Package pubsub implements a publish/subscribe broker, in process or over the network.
+//
+//Topics are words separated by dots, e.g. "orders.eu.created". A subscription pattern may use
+//"*" for exactly one word and "#" for zero or more words, e.g. "orders.*.created" or "orders.#".
+//
+//A published message is serialized once into pooled memory segments, all its subscribers share that buffer,
+//which goes back to the pool once every subscriber released the message.
+package pubsub
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gomsg/memory"
+)
+
+var (
+	ErrInvalidTopic   = fmt.Errorf("pubsub: invalid topic.")
+	ErrInvalidPattern = fmt.Errorf("pubsub: invalid pattern.")
+	ErrBrokerClosed   = fmt.Errorf("pubsub: broker closed.")
+)
+
+//max length of a topic, it's written as a 2 bytes length.
+const maxTopicLength = 0xffff
+
+//Message is a published message shared by all its subscribers, every subscriber MUST release it.
+//
+//Its buffer holds the 2 bytes little endian length of the topic, the topic and the payload,
+//which is also the body of the frames carrying the message over the network.
+type Message struct {
+	Topic string
+	body  memory.MemorySegmentProxyer
+	refs  int32
+}
+
+//newMessage serializes a message, the payload is copied once for all the subscribers.
+func newMessage(mp *memory.MemoryProvider, topic string, payload memory.MemorySegmentProxyer) (*Message, error) {
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+	body := mp.NewSegmentProxy()
+	header := make([]byte, 2+len(topic))
+	binary.LittleEndian.PutUint16(header, uint16(len(topic)))
+	copy(header[2:], topic)
+	if err := body.WriteMemory(header); err != nil {
+		body.Close()
+		return nil, err
+	}
+	if payload != nil {
+		for _, buf := range payload.Buffers() {
+			if err := body.WriteMemory(buf); err != nil {
+				body.Close()
+				return nil, err
+			}
+		}
+	}
+	return &Message{Topic: topic, body: body, refs: 1}, nil
+}
+
+//parseMessage adopts a serialized message, e.g. the body of a frame.
+func parseMessage(body memory.MemorySegmentProxyer) (*Message, error) {
+	r := body.NewReader()
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, ErrInvalidTopic
+	}
+	topic := make([]byte, binary.LittleEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, topic); err != nil {
+		return nil, ErrInvalidTopic
+	}
+	if err := validateTopic(string(topic)); err != nil {
+		return nil, err
+	}
+	return &Message{Topic: string(topic), body: body, refs: 1}, nil
+}
+
+//Payload returns a reader over the payload, it MUST NOT be used after the message was released.
+func (m *Message) Payload() *memory.MemorySegmentReader {
+	r := m.body.NewReader()
+	r.Discard(2 + len(m.Topic))
+	return r
+}
+
+//Retain adds a reference, e.g. before handing the message over to another goroutine which releases it.
+func (m *Message) Retain() {
+	atomic.AddInt32(&m.refs, 1)
+}
+
+//Release drops a reference, the buffer goes back to the pool with the last one.
+func (m *Message) Release() {
+	refs := atomic.AddInt32(&m.refs, -1)
+	if refs == 0 {
+		m.body.Close()
+	} else if refs < 0 {
+		panic("BUG: pubsub message released more than retained.")
+	}
+}
+
+func validateTopic(topic string) error {
+	if topic == "" || len(topic) > maxTopicLength {
+		return ErrInvalidTopic
+	}
+	for _, word := range strings.Split(topic, ".") {
+		if word == "" || strings.ContainsAny(word, "*#") {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+func parsePattern(pattern string) ([]string, error) {
+	if pattern == "" || len(pattern) > maxTopicLength {
+		return nil, ErrInvalidPattern
+	}
+	words := strings.Split(pattern, ".")
+	collapsed := words[:0]
+	for _, word := range words {
+		if word == "" || (word != "*" && word != "#" && strings.ContainsAny(word, "*#")) {
+			return nil, ErrInvalidPattern
+		}
+		//adjacent "#" match the same words as a single one.
+		if word == "#" && len(collapsed) > 0 && collapsed[len(collapsed)-1] == "#" {
+			continue
+		}
+		collapsed = append(collapsed, word)
+	}
+	return collapsed, nil
+}
+
+//match reports whether the words of a topic match the words of a pattern.
+//
+//It fills a table of the pattern prefixes by the topic prefixes row by row, so that a pattern of many "#"
+//takes len(pattern)*len(topic) steps rather than trying every split. matched[j] tells whether the pattern
+//words seen so far match the first j words of the topic.
+func match(pattern, topic []string) bool {
+	matched := make([]bool, len(topic)+1)
+	next := make([]bool, len(topic)+1)
+	matched[0] = true
+	for _, word := range pattern {
+		switch word {
+		case "#":
+			//"#" takes zero or more words.
+			next[0] = matched[0]
+			for j := 1; j <= len(topic); j++ {
+				next[j] = matched[j] || next[j-1]
+			}
+		default:
+			next[0] = false
+			for j := 1; j <= len(topic); j++ {
+				next[j] = matched[j-1] && (word == "*" || word == topic[j-1])
+			}
+		}
+		matched, next = next, matched
+	}
+	return matched[len(topic)]
+}
@@ -0,0 +1,288 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomsg/memory"
+	"github.com/gomsg/rpc"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type PubSubSuite struct{}
+
+var _ = Suite(&PubSubSuite{})
+
+func newProvider(segments uint) *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(segments*256, 256)
+	return mp
+}
+
+//available returns how many segments the pool has left.
+func available(mp *memory.MemoryProvider) int {
+	n := 0
+	for {
+		ms, err := mp.GetOneAvailable()
+		if err != nil {
+			break
+		}
+		defer mp.Giveback(ms)
+		n++
+	}
+	return n
+}
+
+func payload(mp *memory.MemoryProvider, data string) memory.MemorySegmentProxyer {
+	msp := mp.NewSegmentProxy()
+	if data != "" {
+		msp.WriteMemory([]byte(data))
+	}
+	return msp
+}
+
+func read(c *C, m *Message) string {
+	data, err := io.ReadAll(m.Payload())
+	c.Assert(err, IsNil)
+	return string(data)
+}
+
+func receive(c *C, s *Subscription) *Message {
+	select {
+	case m := <-s.C():
+		c.Assert(m, NotNil)
+		return m
+	case <-time.After(5 * time.Second):
+		c.Fatalf("no message received on %s", s.Pattern)
+	}
+	return nil
+}
+
+func (s *PubSubSuite) Test_Match(c *C) {
+	tests := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"orders.eu.created", "orders.eu.created", true},
+		{"orders.eu.created", "orders.us.created", false},
+		{"orders.*.created", "orders.us.created", true},
+		{"orders.*.created", "orders.created", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.#", "orders", true},
+		{"orders.#", "orders.eu.created", true},
+		{"#", "orders.eu.created", true},
+		{"#.created", "orders.eu.created", true},
+		{"#.created", "orders.eu.deleted", false},
+		{"orders.#.created", "orders.created", true},
+		{"orders.#.created", "orders.eu.fr.created", true},
+		{"*.#.*", "orders", false},
+		{"*.#.*", "orders.created", true},
+		{"orders.#.#.created", "orders.created", true},
+		{"#.eu.#", "orders.eu.fr.created", true},
+		{"#.eu.#", "orders.us.created", false},
+		//as many "#" as words would try every split of the topic if it were matched recursively.
+		{strings.Repeat("#.", 12) + "none", strings.Repeat("a.", 24) + "b", false},
+		{strings.Repeat("#.a.", 12) + "b", strings.Repeat("a.", 24) + "b", true},
+		{strings.Repeat("#.a.", 12) + "none", strings.Repeat("a.", 24) + "b", false},
+	}
+	for _, t := range tests {
+		words, err := parsePattern(t.pattern)
+		c.Assert(err, IsNil)
+		c.Check(match(words, strings.Split(t.topic, ".")), Equals, t.match, Commentf("%s %s", t.pattern, t.topic))
+	}
+	words, err := parsePattern("orders.#.#.#.created.#")
+	c.Assert(err, IsNil)
+	c.Assert(words, DeepEquals, []string{"orders", "#", "created", "#"})
+	for _, pattern := range []string{"", "orders..created", "orders.eu*", "orders.#a"} {
+		_, err := parsePattern(pattern)
+		c.Check(err, Equals, ErrInvalidPattern, Commentf(pattern))
+	}
+	for _, topic := range []string{"", "orders.", "orders.*", "orders.#"} {
+		c.Check(validateTopic(topic), Equals, ErrInvalidTopic, Commentf(topic))
+	}
+}
+
+func (s *PubSubSuite) Test_Publish_Shared(c *C) {
+	mp := newProvider(64)
+	b := NewBroker(mp)
+	defer b.Close()
+	all, err := b.Subscribe("#", 4)
+	c.Assert(err, IsNil)
+	created, err := b.Subscribe("orders.*.created", 4)
+	c.Assert(err, IsNil)
+	deleted, err := b.Subscribe("orders.*.deleted", 4)
+	c.Assert(err, IsNil)
+
+	data := strings.Repeat("x", 1000)
+	p := payload(mp, data)
+	before := available(mp)
+	n, err := b.Publish("orders.eu.created", p)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	p.Close()
+
+	//both subscribers share a single copy of the payload.
+	m1, m2 := receive(c, all), receive(c, created)
+	c.Assert(m1, Equals, m2)
+	c.Assert(before-available(mp) <= 5, Equals, true)
+	c.Assert(m1.Topic, Equals, "orders.eu.created")
+	c.Assert(read(c, m1), Equals, data)
+	c.Assert(len(deleted.C()), Equals, 0)
+
+	m1.Release()
+	c.Assert(read(c, m2), Equals, data)
+	m2.Release()
+	c.Assert(available(mp), Equals, 64)
+	c.Assert(func() { m2.Release() }, PanicMatches, ".*released more than retained.*")
+}
+
+func (s *PubSubSuite) Test_Publish_Drop(c *C) {
+	mp := newProvider(64)
+	b := NewBroker(mp)
+	defer b.Close()
+	slow, err := b.Subscribe("events", 2)
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		p := payload(mp, fmt.Sprint(i))
+		_, err := b.Publish("events", p)
+		c.Assert(err, IsNil)
+		p.Close()
+	}
+	c.Assert(slow.Dropped(), Equals, uint64(3))
+	for i := 0; i < 2; i++ {
+		m := receive(c, slow)
+		c.Assert(read(c, m), Equals, fmt.Sprint(i))
+		m.Release()
+	}
+	c.Assert(available(mp), Equals, 64)
+
+	_, err = b.Publish("events.*", nil)
+	c.Assert(err, Equals, ErrInvalidTopic)
+}
+
+func (s *PubSubSuite) Test_Unsubscribe(c *C) {
+	mp := newProvider(64)
+	b := NewBroker(mp)
+	sub, err := b.Subscribe("events.#", 4)
+	c.Assert(err, IsNil)
+	other, err := b.Subscribe("events", 4)
+	c.Assert(err, IsNil)
+	_, err = b.Publish("events.a", nil)
+	c.Assert(err, IsNil)
+	_, err = b.Publish("events", nil)
+	c.Assert(err, IsNil)
+
+	//the messages left in the queues go back to the pool.
+	c.Assert(sub.Unsubscribe(), IsNil)
+	c.Assert(sub.Unsubscribe(), IsNil)
+	_, ok := <-sub.C()
+	c.Assert(ok, Equals, false)
+	n, err := b.Publish("events", nil)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	b.Close()
+	_, ok = <-other.C()
+	c.Assert(ok, Equals, false)
+	c.Assert(other.Unsubscribe(), IsNil)
+	_, err = b.Publish("events", nil)
+	c.Assert(err, Equals, ErrBrokerClosed)
+	_, err = b.Subscribe("events", 1)
+	c.Assert(err, Equals, ErrBrokerClosed)
+}
+
+func (s *PubSubSuite) Test_Remote(c *C) {
+	mp := newProvider(4096)
+	b := NewBroker(mp)
+	defer b.Close()
+	server := NewServer(b, mp)
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	defer server.Shutdown(context.Background())
+
+	publisher, err := Dial(server.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	defer publisher.Close()
+	subscriber, err := Dial(server.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	defer subscriber.Close()
+
+	_, err = subscriber.Subscribe(context.Background(), "orders..created", 4)
+	c.Assert(err, Equals, ErrInvalidPattern)
+	created, err := subscriber.Subscribe(context.Background(), "orders.*.created", 16)
+	c.Assert(err, IsNil)
+	local, err := b.Subscribe("orders.#", 16)
+	c.Assert(err, IsNil)
+
+	data := strings.Repeat("y", 3000)
+	c.Assert(publisher.Publish("orders.eu.created", payload(mp, data)), IsNil)
+	c.Assert(publisher.Publish("orders.eu.deleted", payload(mp, "deleted")), IsNil)
+
+	m := receive(c, created)
+	c.Assert(m.Topic, Equals, "orders.eu.created")
+	c.Assert(read(c, m), Equals, data)
+	m.Release()
+	for _, topic := range []string{"orders.eu.created", "orders.eu.deleted"} {
+		m := receive(c, local)
+		c.Assert(m.Topic, Equals, topic)
+		m.Release()
+	}
+
+	//the broker forgets the subscription.
+	c.Assert(created.Unsubscribe(), IsNil)
+	_, ok := <-created.C()
+	c.Assert(ok, Equals, false)
+	n, err := b.Publish("orders.us.created", nil)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	receive(c, local).Release()
+
+	//closing the connection of the subscriber unsubscribes it on the server.
+	again, err := subscriber.Subscribe(context.Background(), "orders.#", 16)
+	c.Assert(err, IsNil)
+	subscriber.Close()
+	_, ok = <-again.C()
+	c.Assert(ok, Equals, false)
+	for i := 0; i < 100; i++ {
+		if n, _ = b.Publish("orders.us.created", nil); n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(n, Equals, 1)
+	receive(c, local).Release()
+}
+
+func (s *PubSubSuite) Test_Remote_MaxQueueSize(c *C) {
+	mp := newProvider(4096)
+	b := NewBroker(mp)
+	defer b.Close()
+	server := NewServer(b, mp)
+	server.MaxQueueSize = 8
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	defer server.Shutdown(context.Background())
+	subscriber, err := Dial(server.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	defer subscriber.Close()
+
+	//the queue size requested by the remote subscriber is capped.
+	req := make(rpc.Bytes, 12+len("orders.#"))
+	binary.LittleEndian.PutUint64(req, 1)
+	binary.LittleEndian.PutUint32(req[8:], 0xffffffff)
+	copy(req[12:], "orders.#")
+	c.Assert(subscriber.rpc.Call(context.Background(), methodSubscribe, req, nil), IsNil)
+	b.RLock()
+	c.Assert(b.subs, HasLen, 1)
+	for sub := range b.subs {
+		c.Assert(cap(sub.queue), Equals, 8)
+	}
+	b.RUnlock()
+}
@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	"github.com/gomsg/rpc"
+	"github.com/gomsg/transport"
+	log "github.com/sirupsen/logrus"
+)
+
+//Message types of the frames carrying messages, their body is the buffer of the message.
+//The correlation id of a delivered message is the id of the subscription.
+const (
+	MESSAGE_TYPE_PUBLISH uint32 = 0x50534200 + iota
+	MESSAGE_TYPE_DELIVER
+)
+
+//rpc methods of the server.
+const (
+	methodSubscribe   = "pubsub.subscribe"
+	methodUnsubscribe = "pubsub.unsubscribe"
+)
+
+const (
+	serverWorkers   = 4
+	serverQueueSize = 64
+	//max queue size of a remote subscription by default.
+	defMaxQueueSize = 4096
+)
+
+type remoteKey struct {
+	conn *transport.Conn
+	id   uint64
+}
+
+//Server makes a broker available over the network.
+//
+//Subscriptions are managed through rpc calls, while messages are published and delivered as plain frames.
+//Every remote subscription has its own bounded queue on the server, it's unsubscribed when its connection closes.
+type Server struct {
+	*rpc.Server
+	//MaxQueueSize caps the queue size requested by a remote subscriber, set it before listening.
+	MaxQueueSize int
+
+	broker *Broker
+	subs   map[remoteKey]*Subscription
+	lock   sync.Mutex
+}
+
+func NewServer(b *Broker, mp *memory.MemoryProvider) *Server {
+	s := &Server{
+		Server:       rpc.NewServer(mp, serverWorkers, serverQueueSize),
+		MaxQueueSize: defMaxQueueSize,
+		broker:       b,
+		subs:         make(map[remoteKey]*Subscription)}
+	s.Register(methodSubscribe, s.subscribe)
+	s.Register(methodUnsubscribe, s.unsubscribe)
+	s.HandleFunc(MESSAGE_TYPE_PUBLISH, s.publish)
+	return s
+}
+
+//subscribe reads the 8 bytes id of the subscription, its 4 bytes queue size and its pattern.
+//The queue size comes from the remote subscriber, it's capped by MaxQueueSize.
+func (s *Server) subscribe(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+	var header [12]byte
+	if _, err := io.ReadFull(req, header[:]); err != nil {
+		return rpc.ErrInvalidRequest
+	}
+	pattern, err := io.ReadAll(req)
+	if err != nil {
+		return err
+	}
+	queueSize := binary.LittleEndian.Uint32(header[8:])
+	if uint64(queueSize) > uint64(s.MaxQueueSize) {
+		queueSize = uint32(s.MaxQueueSize)
+	}
+	sub, err := s.broker.Subscribe(string(pattern), int(queueSize))
+	if err != nil {
+		return rpc.NewError(rpc.CODE_INVALID_REQUEST, "%v", err)
+	}
+	key := remoteKey{rpc.ConnFromContext(ctx), binary.LittleEndian.Uint64(header[:8])}
+	s.lock.Lock()
+	if previous := s.subs[key]; previous != nil {
+		previous.Unsubscribe()
+	}
+	s.subs[key] = sub
+	s.lock.Unlock()
+	go s.forward(key, sub)
+	return nil
+}
+
+func (s *Server) unsubscribe(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+	var id [8]byte
+	if _, err := io.ReadFull(req, id[:]); err != nil {
+		return rpc.ErrInvalidRequest
+	}
+	s.drop(remoteKey{rpc.ConnFromContext(ctx), binary.LittleEndian.Uint64(id[:])}, nil)
+	return nil
+}
+
+//drop forgets a remote subscription and unsubscribes it, sub is only forgotten if it's still the current one.
+func (s *Server) drop(key remoteKey, sub *Subscription) {
+	s.lock.Lock()
+	current := s.subs[key]
+	if current != nil && (sub == nil || sub == current) {
+		delete(s.subs, key)
+	}
+	s.lock.Unlock()
+	if sub == nil {
+		sub = current
+	}
+	if sub != nil {
+		sub.Unsubscribe()
+	}
+}
+
+//forward delivers the messages of a remote subscription until it's unsubscribed or its connection closes.
+func (s *Server) forward(key remoteKey, sub *Subscription) {
+	defer s.drop(key, sub)
+	for {
+		select {
+		case m, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			err := key.conn.Send(MESSAGE_TYPE_DELIVER, key.id, 0, m.body)
+			m.Release()
+			if err != nil {
+				return
+			}
+		case <-key.conn.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) publish(conn *transport.Conn, f *frame.Frame) {
+	m, err := parseMessage(f.Body)
+	if err != nil {
+		log.Warnf("Dropping message published by %s: %v", conn.RemoteAddr(), err)
+		f.Close()
+		return
+	}
+	//the message owns the body from now on.
+	f.Body = nil
+	s.broker.publish(m)
+}
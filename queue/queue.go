@@ -0,0 +1,385 @@
+//Package queue implements a work queue with at-least-once delivery.
+//
+//A dequeued message stays invisible to the other consumers for the visibility timeout, it's removed once acked.
+//A message neither acked nor nacked in time, or nacked, is delivered again, after an exponential backoff
+//when nacked. A message delivered MaxDeliveries times without being acked is moved to the dead-letter queue.
+//
+//Messages are kept in pooled memory segments. When the queue has a wal.Log, every message enqueued and every
+//ack is appended to it and the queue recovers its messages from the log when created, so no message is lost
+//when the process crashes. Delivery counts are not persisted, a recovered message starts over.
+package queue
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomsg/memory"
+	"github.com/gomsg/wal"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrQueueClosed    = fmt.Errorf("queue: queue closed.")
+	ErrStaleMessage   = fmt.Errorf("queue: message no longer delivered to this consumer.")
+	ErrCorruptRecord  = fmt.Errorf("queue: corrupted log record.")
+	ErrInvalidOptions = fmt.Errorf("queue: invalid options.")
+)
+
+const (
+	DefaultVisibilityTimeout = 30 * time.Second
+	DefaultBackoffBase       = 100 * time.Millisecond
+	DefaultBackoffMax        = time.Minute
+)
+
+//Options of a queue, zero values take the defaults.
+type Options struct {
+	VisibilityTimeout time.Duration
+	//MaxDeliveries moves a message to DeadLetter once delivered that many times, 0 delivers it forever.
+	MaxDeliveries int
+	//BackoffBase is the delay before redelivering a message nacked once, it doubles with every delivery
+	//up to BackoffMax.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	//DeadLetter receives the messages delivered too many times, they are dropped if it's nil.
+	//It MUST NOT lead back to this queue.
+	DeadLetter *Queue
+	//Log persists the queue, it MUST only be used by this queue and stays open when the queue is closed.
+	Log *wal.Log
+}
+
+type entry struct {
+	id         uint64
+	record     memory.MemorySegmentProxyer
+	offset     uint64
+	deliveries int
+	inflight   bool
+	timer      *time.Timer
+	elem       *list.Element
+}
+
+//Queue is a work queue, it's safe for concurrent use.
+type Queue struct {
+	mp      *memory.MemoryProvider
+	opts    Options
+	entries map[uint64]*entry
+	ready   *list.List
+	nextID  uint64
+	lowest  uint64
+	notify  chan struct{}
+	closed  bool
+	done    chan struct{}
+	lock    sync.Mutex
+}
+
+//NewQueue returns a queue storing its messages into segments borrowed from mp,
+//the messages left in opts.Log are recovered.
+func NewQueue(mp *memory.MemoryProvider, opts Options) (*Queue, error) {
+	if opts.VisibilityTimeout < 0 || opts.MaxDeliveries < 0 || opts.BackoffBase < 0 || opts.BackoffMax < 0 {
+		return nil, ErrInvalidOptions
+	}
+	if opts.VisibilityTimeout == 0 {
+		opts.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if opts.BackoffBase == 0 {
+		opts.BackoffBase = DefaultBackoffBase
+	}
+	if opts.BackoffMax == 0 {
+		opts.BackoffMax = DefaultBackoffMax
+	}
+	q := &Queue{
+		mp:      mp,
+		opts:    opts,
+		entries: make(map[uint64]*entry),
+		ready:   list.New(),
+		nextID:  1,
+		lowest:  1,
+		notify:  make(chan struct{}),
+		done:    make(chan struct{})}
+	if opts.Log != nil {
+		if err := q.recover(); err != nil {
+			q.Close()
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+//Enqueue adds a message, the caller keeps the body. With a log, it returns once the message was appended.
+func (q *Queue) Enqueue(body memory.MemorySegmentProxyer) (uint64, error) {
+	var buffers [][]byte
+	if body != nil {
+		buffers = body.Buffers()
+	}
+	return q.enqueue(buffers)
+}
+
+func (q *Queue) enqueue(body [][]byte) (uint64, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+	id := q.nextID
+	record, err := newRecord(q.mp, RECORD_ENQUEUE, id, body)
+	if err != nil {
+		return 0, err
+	}
+	e := &entry{id: id, record: record}
+	if q.opts.Log != nil {
+		if e.offset, err = q.opts.Log.Append(record); err != nil {
+			record.Close()
+			return 0, err
+		}
+	}
+	q.nextID++
+	q.entries[id] = e
+	q.makeReady(e)
+	return id, nil
+}
+
+//Dequeue waits for a message and delivers it, the message MUST be acked or nacked before its visibility timeout.
+func (q *Queue) Dequeue(ctx context.Context) (*Message, error) {
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return nil, ErrQueueClosed
+		}
+		if front := q.ready.Front(); front != nil {
+			m := q.deliver(q.ready.Remove(front).(*entry))
+			q.lock.Unlock()
+			return m, nil
+		}
+		notify := q.notify
+		q.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-q.done:
+			return nil, ErrQueueClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *Queue) deliver(e *entry) *Message {
+	e.elem = nil
+	e.inflight = true
+	e.deliveries++
+	deliveries := e.deliveries
+	e.timer = time.AfterFunc(q.opts.VisibilityTimeout, func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		if !q.closed && e.inflight && e.deliveries == deliveries {
+			e.inflight = false
+			q.redeliver(e)
+		}
+	})
+	return &Message{ID: e.id, Deliveries: deliveries, q: q, e: e}
+}
+
+//makeReady queues a message for delivery and wakes the waiting consumers up.
+func (q *Queue) makeReady(e *entry) {
+	e.timer = nil
+	e.elem = q.ready.PushBack(e)
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+//redeliver makes a message ready again, or moves it to the dead-letter queue once delivered too many times.
+func (q *Queue) redeliver(e *entry) {
+	if q.opts.MaxDeliveries > 0 && e.deliveries >= q.opts.MaxDeliveries {
+		q.deadLetter(e)
+		return
+	}
+	q.makeReady(e)
+}
+
+func (q *Queue) deadLetter(e *entry) {
+	if q.opts.DeadLetter != nil {
+		if _, err := q.opts.DeadLetter.enqueue(recordBody(e.record)); err != nil {
+			log.Errorf("Failed moving message %d to the dead-letter queue, redelivering it: %v", e.id, err)
+			q.makeReady(e)
+			return
+		}
+	} else {
+		log.Warnf("Dropping message %d delivered %d times.", e.id, e.deliveries)
+	}
+	q.removeDead(e)
+}
+
+//removeDead removes a message moved to the dead-letter queue or dropped. If its ack can't be appended,
+//the message is kept and its removal retried after a backoff, so that it isn't recovered as a live message.
+func (q *Queue) removeDead(e *entry) {
+	if err := q.appendAck(e); err != nil {
+		log.Errorf("Failed removing message %d delivered %d times, retrying: %v", e.id, e.deliveries, err)
+		e.timer = time.AfterFunc(q.backoff(e.deliveries), func() {
+			q.lock.Lock()
+			defer q.lock.Unlock()
+			if !q.closed && q.entries[e.id] == e {
+				q.removeDead(e)
+			}
+		})
+		return
+	}
+	if err := q.forget(e); err != nil {
+		log.Errorf("Failed truncating the log after removing message %d: %v", e.id, err)
+	}
+}
+
+//appendAck appends the ack of a message to the log, if any. The message is left as it is.
+func (q *Queue) appendAck(e *entry) error {
+	if q.opts.Log == nil {
+		return nil
+	}
+	ack, err := newRecord(q.mp, RECORD_ACK, e.id, nil)
+	if err != nil {
+		return err
+	}
+	_, err = q.opts.Log.Append(ack)
+	ack.Close()
+	return err
+}
+
+//forget forgets a message whose ack was appended, dropping the log records no message needs anymore.
+func (q *Queue) forget(e *entry) error {
+	delete(q.entries, e.id)
+	e.record.Close()
+	if q.opts.Log != nil && e.id == q.lowest {
+		for q.lowest < q.nextID && q.entries[q.lowest] == nil {
+			q.lowest++
+		}
+		before := q.opts.Log.NextOffset()
+		if oldest := q.entries[q.lowest]; oldest != nil {
+			before = oldest.offset
+		}
+		return q.opts.Log.TruncateBefore(before)
+	}
+	return nil
+}
+
+func (q *Queue) ack(m *Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err := q.check(m); err != nil {
+		return err
+	}
+	//the message stays in flight until its ack is appended, a failed ack can be retried.
+	if err := q.appendAck(m.e); err != nil {
+		return err
+	}
+	m.e.timer.Stop()
+	m.e.inflight = false
+	return q.forget(m.e)
+}
+
+func (q *Queue) nack(m *Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err := q.check(m); err != nil {
+		return err
+	}
+	e := m.e
+	e.timer.Stop()
+	e.inflight = false
+	if q.opts.MaxDeliveries > 0 && e.deliveries >= q.opts.MaxDeliveries {
+		q.deadLetter(e)
+		return nil
+	}
+	deliveries := e.deliveries
+	e.timer = time.AfterFunc(q.backoff(deliveries), func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		if !q.closed && !e.inflight && e.elem == nil && e.deliveries == deliveries && q.entries[e.id] == e {
+			q.makeReady(e)
+		}
+	})
+	return nil
+}
+
+//check fails if the message was delivered again since, or removed.
+func (q *Queue) check(m *Message) error {
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.entries[m.ID] != m.e || !m.e.inflight || m.e.deliveries != m.Deliveries {
+		return ErrStaleMessage
+	}
+	return nil
+}
+
+//backoff returns the delay before redelivering a message nacked after its n-th delivery.
+func (q *Queue) backoff(n int) time.Duration {
+	delay := q.opts.BackoffBase
+	for i := 1; i < n && delay < q.opts.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > q.opts.BackoffMax {
+		delay = q.opts.BackoffMax
+	}
+	return delay
+}
+
+//Len returns how many messages the queue holds, including the ones being delivered.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.entries)
+}
+
+//Ready returns how many messages wait for a consumer.
+func (q *Queue) Ready() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.ready.Len()
+}
+
+//Close stops the queue and releases its messages, the ones persisted are recovered by the next queue using the log.
+func (q *Queue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+	for id, e := range q.entries {
+		if e.timer != nil {
+			e.timer.Stop()
+		}
+		e.record.Close()
+		delete(q.entries, id)
+	}
+	q.ready.Init()
+}
+
+//Message is a delivered message.
+type Message struct {
+	ID uint64
+	//Deliveries counts the deliveries of the message, including this one.
+	Deliveries int
+	q          *Queue
+	e          *entry
+}
+
+//Body returns a reader over the body, it MUST NOT be used after the message was acked or nacked.
+func (m *Message) Body() *memory.MemorySegmentReader {
+	r := m.e.record.NewReader()
+	r.Discard(recordHeaderSize)
+	return r
+}
+
+//Ack removes the message from the queue.
+//It fails with ErrStaleMessage if the visibility timeout expired, the message may be delivered again then.
+func (m *Message) Ack() error {
+	return m.q.ack(m)
+}
+
+//Nack gives the message back for being delivered again after a backoff.
+func (m *Message) Nack() error {
+	return m.q.nack(m)
+}
@@ -0,0 +1,279 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gomsg/memory"
+	"github.com/gomsg/wal"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type QueueSuite struct{}
+
+var _ = Suite(&QueueSuite{})
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(1024*256, 256)
+	return mp
+}
+
+//available returns how many segments the pool has left.
+func available(mp *memory.MemoryProvider) int {
+	n := 0
+	for {
+		ms, err := mp.GetOneAvailable()
+		if err != nil {
+			break
+		}
+		defer mp.Giveback(ms)
+		n++
+	}
+	return n
+}
+
+func enqueue(c *C, q *Queue, mp *memory.MemoryProvider, body string) uint64 {
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(msp.WriteMemory([]byte(body)), IsNil)
+	id, err := q.Enqueue(msp)
+	c.Assert(err, IsNil)
+	return id
+}
+
+func dequeue(c *C, q *Queue) (*Message, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m, err := q.Dequeue(ctx)
+	c.Assert(err, IsNil)
+	body, err := io.ReadAll(m.Body())
+	c.Assert(err, IsNil)
+	return m, string(body)
+}
+
+func (s *QueueSuite) Test_Ack(c *C) {
+	mp := newProvider()
+	q, err := NewQueue(mp, Options{})
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		c.Assert(enqueue(c, q, mp, fmt.Sprintf("task %d", i)), Equals, uint64(i+1))
+	}
+	c.Assert(q.Len(), Equals, 3)
+	for i := 0; i < 3; i++ {
+		m, body := dequeue(c, q)
+		c.Assert(body, Equals, fmt.Sprintf("task %d", i))
+		c.Assert(m.Deliveries, Equals, 1)
+		c.Assert(q.Ready(), Equals, 2-i)
+		c.Assert(m.Ack(), IsNil)
+		c.Assert(m.Ack(), Equals, ErrStaleMessage)
+	}
+	c.Assert(q.Len(), Equals, 0)
+	c.Assert(available(mp), Equals, 1024)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = q.Dequeue(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
+
+func (s *QueueSuite) Test_VisibilityTimeout(c *C) {
+	mp := newProvider()
+	q, err := NewQueue(mp, Options{VisibilityTimeout: 50 * time.Millisecond})
+	c.Assert(err, IsNil)
+	defer q.Close()
+	enqueue(c, q, mp, "task")
+
+	first, _ := dequeue(c, q)
+	c.Assert(q.Ready(), Equals, 0)
+	second, body := dequeue(c, q)
+	c.Assert(body, Equals, "task")
+	c.Assert(second.ID, Equals, first.ID)
+	c.Assert(second.Deliveries, Equals, 2)
+	c.Assert(first.Ack(), Equals, ErrStaleMessage)
+	c.Assert(first.Nack(), Equals, ErrStaleMessage)
+	c.Assert(second.Ack(), IsNil)
+	c.Assert(q.Len(), Equals, 0)
+}
+
+func (s *QueueSuite) Test_Nack_Backoff(c *C) {
+	mp := newProvider()
+	q, err := NewQueue(mp, Options{BackoffBase: 40 * time.Millisecond, BackoffMax: 100 * time.Millisecond})
+	c.Assert(err, IsNil)
+	defer q.Close()
+	c.Assert(q.backoff(1), Equals, 40*time.Millisecond)
+	c.Assert(q.backoff(2), Equals, 80*time.Millisecond)
+	c.Assert(q.backoff(3), Equals, 100*time.Millisecond)
+	c.Assert(q.backoff(100), Equals, 100*time.Millisecond)
+
+	enqueue(c, q, mp, "task")
+	m, _ := dequeue(c, q)
+	nacked := time.Now()
+	c.Assert(m.Nack(), IsNil)
+	c.Assert(q.Ready(), Equals, 0)
+	c.Assert(q.Len(), Equals, 1)
+	m, body := dequeue(c, q)
+	c.Assert(time.Since(nacked) >= 40*time.Millisecond, Equals, true)
+	c.Assert(body, Equals, "task")
+	c.Assert(m.Deliveries, Equals, 2)
+	c.Assert(m.Ack(), IsNil)
+}
+
+func (s *QueueSuite) Test_DeadLetter(c *C) {
+	mp := newProvider()
+	dlq, err := NewQueue(mp, Options{})
+	c.Assert(err, IsNil)
+	defer dlq.Close()
+	q, err := NewQueue(mp, Options{
+		VisibilityTimeout: 20 * time.Millisecond,
+		MaxDeliveries:     3,
+		BackoffBase:       time.Millisecond,
+		DeadLetter:        dlq})
+	c.Assert(err, IsNil)
+	defer q.Close()
+
+	enqueue(c, q, mp, "poison")
+	m, _ := dequeue(c, q)
+	c.Assert(m.Nack(), IsNil)
+	m, _ = dequeue(c, q)
+	c.Assert(m.Nack(), IsNil)
+	//the third delivery times out.
+	m, _ = dequeue(c, q)
+	c.Assert(m.Deliveries, Equals, 3)
+
+	dead, body := dequeue(c, dlq)
+	c.Assert(body, Equals, "poison")
+	c.Assert(dead.Deliveries, Equals, 1)
+	c.Assert(dead.Ack(), IsNil)
+	c.Assert(q.Len(), Equals, 0)
+	c.Assert(m.Ack(), Equals, ErrStaleMessage)
+}
+
+func (s *QueueSuite) Test_Close(c *C) {
+	mp := newProvider()
+	q, err := NewQueue(mp, Options{})
+	c.Assert(err, IsNil)
+	enqueue(c, q, mp, "ready")
+	enqueue(c, q, mp, "delivered")
+	dequeue(c, q)
+
+	waiting := make(chan error)
+	empty, err := NewQueue(mp, Options{})
+	c.Assert(err, IsNil)
+	go func() {
+		_, err := empty.Dequeue(context.Background())
+		waiting <- err
+	}()
+	empty.Close()
+	c.Assert(<-waiting, Equals, ErrQueueClosed)
+
+	q.Close()
+	c.Assert(available(mp), Equals, 1024)
+	_, err = q.Enqueue(nil)
+	c.Assert(err, Equals, ErrQueueClosed)
+	_, err = NewQueue(mp, Options{MaxDeliveries: -1})
+	c.Assert(err, Equals, ErrInvalidOptions)
+}
+
+func (s *QueueSuite) Test_Persistence(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	l, err := wal.Open(dir, mp, wal.Options{SegmentSize: 1024})
+	c.Assert(err, IsNil)
+	q, err := NewQueue(mp, Options{Log: l})
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		enqueue(c, q, mp, fmt.Sprintf("task %d", i))
+	}
+	for i := 0; i < 2; i++ {
+		m, _ := dequeue(c, q)
+		c.Assert(m.Ack(), IsNil)
+	}
+	//the consumer crashes before acking.
+	dequeue(c, q)
+	q.Close()
+	c.Assert(l.Close(), IsNil)
+
+	l, err = wal.Open(dir, mp, wal.Options{SegmentSize: 1024})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	q, err = NewQueue(mp, Options{Log: l})
+	c.Assert(err, IsNil)
+	defer q.Close()
+	c.Assert(q.Len(), Equals, 3)
+	for i := 2; i < 5; i++ {
+		m, body := dequeue(c, q)
+		c.Assert(body, Equals, fmt.Sprintf("task %d", i))
+		c.Assert(m.ID, Equals, uint64(i+1))
+		c.Assert(m.Ack(), IsNil)
+	}
+	c.Assert(enqueue(c, q, mp, "pending"), Equals, uint64(6))
+	pending, _ := dequeue(c, q)
+
+	//the log is kept from the oldest message not acked.
+	for i := 0; i < 100; i++ {
+		enqueue(c, q, mp, fmt.Sprintf("task %0100d", i))
+		m, _ := dequeue(c, q)
+		c.Assert(m.Ack(), IsNil)
+	}
+	c.Assert(l.FirstOffset() <= q.entries[pending.ID].offset, Equals, true)
+	c.Assert(pending.Ack(), IsNil)
+	c.Assert(l.FirstOffset() > 100, Equals, true)
+}
+
+//exhaust borrows every segment left in the pool, returning a function giving them back.
+func exhaust(mp *memory.MemoryProvider) func() {
+	var borrowed []memory.MemorySegmentProxyer
+	for {
+		msp := mp.NewSegmentProxy()
+		if msp.WriteMemory(make([]byte, 256)) != nil {
+			msp.Close()
+			break
+		}
+		borrowed = append(borrowed, msp)
+	}
+	return func() {
+		for _, msp := range borrowed {
+			msp.Close()
+		}
+	}
+}
+
+func (s *QueueSuite) Test_Ack_PoolExhausted(c *C) {
+	mp := newProvider()
+	l, err := wal.Open(c.MkDir(), mp, wal.Options{SegmentSize: 4096})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	q, err := NewQueue(mp, Options{Log: l, MaxDeliveries: 1, BackoffBase: time.Millisecond, BackoffMax: time.Millisecond})
+	c.Assert(err, IsNil)
+	defer q.Close()
+
+	//the ack can't be appended, the message stays in flight and the ack can be retried.
+	enqueue(c, q, mp, "acked")
+	m, _ := dequeue(c, q)
+	giveback := exhaust(mp)
+	c.Assert(m.Ack(), Equals, memory.ErrNoMoreSegments)
+	giveback()
+	c.Assert(m.Ack(), IsNil)
+	c.Assert(q.Len(), Equals, 0)
+
+	//a message dropped after too many deliveries is removed once its ack can be appended.
+	enqueue(c, q, mp, "dropped")
+	m, _ = dequeue(c, q)
+	giveback = exhaust(mp)
+	c.Assert(m.Nack(), IsNil)
+	time.Sleep(10 * time.Millisecond)
+	c.Assert(q.Len(), Equals, 1)
+	giveback()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(q.Len(), Equals, 0)
+}
@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/gomsg/memory"
+)
+
+//Types of the log records, a record holds its type, the 8 bytes little endian id of the message
+//and, for RECORD_ENQUEUE, the body.
+const (
+	RECORD_ENQUEUE byte = iota + 1
+	RECORD_ACK
+)
+
+const recordHeaderSize = 9
+
+func newRecord(mp *memory.MemoryProvider, recordType byte, id uint64, body [][]byte) (memory.MemorySegmentProxyer, error) {
+	record := mp.NewSegmentProxy()
+	var header [recordHeaderSize]byte
+	header[0] = recordType
+	binary.LittleEndian.PutUint64(header[1:], id)
+	if err := record.WriteMemory(header[:]); err != nil {
+		record.Close()
+		return nil, err
+	}
+	for _, buf := range body {
+		if len(buf) == 0 {
+			continue
+		}
+		if err := record.WriteMemory(buf); err != nil {
+			record.Close()
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func readRecordHeader(record memory.MemorySegmentProxyer) (byte, uint64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(record.NewReader(), header[:]); err != nil {
+		return 0, 0, ErrCorruptRecord
+	}
+	if header[0] != RECORD_ENQUEUE && header[0] != RECORD_ACK {
+		return 0, 0, ErrCorruptRecord
+	}
+	return header[0], binary.LittleEndian.Uint64(header[1:]), nil
+}
+
+//recordBody returns the buffers of the body of a record, without copying them.
+func recordBody(record memory.MemorySegmentProxyer) [][]byte {
+	skip := recordHeaderSize
+	var body [][]byte
+	for _, buf := range record.Buffers() {
+		if skip >= len(buf) {
+			skip -= len(buf)
+			continue
+		}
+		body = append(body, buf[skip:])
+		skip = 0
+	}
+	return body
+}
+
+//recover loads the messages enqueued and not acked from the log.
+func (q *Queue) recover() error {
+	r := q.opts.Log.NewReader(q.opts.Log.FirstOffset())
+	for {
+		offset, record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		recordType, id, err := readRecordHeader(record)
+		if err != nil {
+			record.Close()
+			return err
+		}
+		switch recordType {
+		case RECORD_ENQUEUE:
+			if previous := q.entries[id]; previous != nil {
+				previous.record.Close()
+			}
+			q.entries[id] = &entry{id: id, record: record, offset: offset}
+		case RECORD_ACK:
+			record.Close()
+			if e := q.entries[id]; e != nil {
+				e.record.Close()
+				delete(q.entries, id)
+			}
+		}
+		if id >= q.nextID {
+			q.nextID = id + 1
+		}
+	}
+	//messages are delivered in the order they were enqueued.
+	ids := make([]uint64, 0, len(q.entries))
+	for id := range q.entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	q.lowest = q.nextID
+	if len(ids) > 0 {
+		q.lowest = ids[0]
+	}
+	for _, id := range ids {
+		e := q.entries[id]
+		e.elem = q.ready.PushBack(e)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	"github.com/gomsg/transport"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//Client multiplexes calls over a single connection, it's safe for concurrent use.
+type Client struct {
+	conn    *transport.Client
+	mp      *memory.MemoryProvider
+	nextID  uint64
+	pending map[uint64]chan *frame.Frame
+	lock    sync.Mutex
+	//telemetry of the calls, see SetTelemetry.
+	telemetry atomic.Pointer[telemetry]
+}
+
+//Dial connects to an rpc Server, the requests are written into and the replies read into segments borrowed from mp.
+func Dial(addr string, mp *memory.MemoryProvider) (*Client, error) {
+	conn, err := transport.Dial(addr, mp)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, mp: mp, pending: make(map[uint64]chan *frame.Frame)}
+	c.telemetry.Store(newTelemetry(conn.Telemetry(), trace.SpanKindClient))
+	conn.HandleFunc(MESSAGE_TYPE_RESPONSE, c.deliver)
+	conn.HandleFunc(MESSAGE_TYPE_ERROR, c.deliver)
+	return c, nil
+}
+
+//Conn returns the underlying connection, e.g. for exchanging frames of other message types along with the calls.
+func (c *Client) Conn() *transport.Client {
+	return c.conn
+}
+
+//SetTelemetry instruments the calls and their connection with t, see transport.Telemetry.
+//nil restores the default one using the global providers of otel.
+func (c *Client) SetTelemetry(t *transport.Telemetry) {
+	c.conn.SetTelemetry(t)
+	c.telemetry.Store(newTelemetry(c.conn.Telemetry(), trace.SpanKindClient))
+}
+
+//deliver hands a reply over to its call, the reply of a call which gave up is dropped.
+func (c *Client) deliver(conn *transport.Conn, f *frame.Frame) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	done, ok := c.pending[f.CorrelationID]
+	if !ok {
+		f.Close()
+		return
+	}
+	delete(c.pending, f.CorrelationID)
+	//never blocks, so a call giving up finds the reply either pending or in its channel.
+	done <- f
+}
+
+//Call invokes a method and waits for its response, req and resp may be nil.
+//
+//The deadline of ctx is sent along with the request, and the server is told to cancel the call
+//if ctx is canceled before the response arrives. Errors returned by the handler are *RemoteError.
+//The call runs within a span child of the one of ctx, which the server links its own span to.
+func (c *Client) Call(ctx context.Context, method string, req Marshaler, resp Unmarshaler) error {
+	t := c.telemetry.Load()
+	ctx, span := t.start(ctx, method)
+	start := time.Now()
+	err := c.call(ctx, method, req, resp)
+	t.end(ctx, span, method, start, err)
+	return err
+}
+
+func (c *Client) call(ctx context.Context, method string, req Marshaler, resp Unmarshaler) error {
+	var timeout int64
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = int64(time.Until(deadline))
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+	body := c.mp.NewSegmentProxy()
+	defer body.Close()
+	if err := writeRequestHeader(body, method, timeout); err != nil {
+		return err
+	}
+	if req != nil {
+		if err := c.mp.Marshal(ctx, body, req.Marshal); err != nil {
+			return err
+		}
+	}
+
+	id := atomic.AddUint64(&c.nextID, 1)
+	done := make(chan *frame.Frame, 1)
+	c.lock.Lock()
+	c.pending[id] = done
+	c.lock.Unlock()
+	if err := c.conn.SendContext(ctx, MESSAGE_TYPE_REQUEST, id, 0, body); err != nil {
+		c.forget(id, done)
+		return err
+	}
+	//the request is out, give its segments back before waiting.
+	body.Close()
+
+	select {
+	case f := <-done:
+		defer f.Close()
+		if f.MessageType == MESSAGE_TYPE_ERROR {
+			return readError(f.Body.NewReader())
+		}
+		if resp == nil {
+			return nil
+		}
+		return resp.Unmarshal(f.Body.NewReader())
+	case <-ctx.Done():
+		//the server is aware of the deadline, only a cancellation needs to be told.
+		if c.forget(id, done) && ctx.Err() == context.Canceled {
+			c.conn.SendContext(ctx, MESSAGE_TYPE_CANCEL, id, 0, nil)
+		}
+		return ctx.Err()
+	case <-c.conn.Done():
+		c.forget(id, done)
+		return ErrClientClosed
+	}
+}
+
+//forget removes a pending call, a reply which already arrived is dropped.
+//It returns false if the call wasn't pending anymore.
+func (c *Client) forget(id uint64, done chan *frame.Frame) bool {
+	c.lock.Lock()
+	_, ok := c.pending[id]
+	delete(c.pending, id)
+	c.lock.Unlock()
+	if !ok {
+		select {
+		case f := <-done:
+			f.Close()
+		default:
+		}
+	}
+	return ok
+}
+
+//Close closes the connection, pending calls fail with ErrClientClosed.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
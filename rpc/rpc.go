@@ -0,0 +1,152 @@
+//Package rpc implements request/response calls over framed connections.
+//
+//Many calls are multiplexed over a single connection, each one is identified by the correlation id of its frames.
+//A request frame carries the method name and the time left before the deadline of the call, then the request:
+//
+//	size      field
+//	2         length of the method name, little endian
+//	          method name
+//	8         time left in nanoseconds, little endian, 0 means no deadline
+//	          request
+//
+//The server replies with a response frame holding the response, or an error frame holding a 4 bytes error code
+//followed by the error message. A client giving up on a call sends a cancel frame, which cancels
+//the context of the handler serving it.
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/gomsg/memory"
+)
+
+//Message types of the frames.
+const (
+	MESSAGE_TYPE_REQUEST uint32 = 0x52504300 + iota
+	MESSAGE_TYPE_RESPONSE
+	MESSAGE_TYPE_ERROR
+	MESSAGE_TYPE_CANCEL
+)
+
+//Error codes of RemoteError.
+const (
+	CODE_INTERNAL uint32 = iota + 1
+	CODE_UNKNOWN_METHOD
+	CODE_INVALID_REQUEST
+	CODE_DEADLINE_EXCEEDED
+	CODE_CANCELED
+	CODE_OVERLOADED
+	CODE_UNAVAILABLE
+	//CODE_USER is the first code left to applications.
+	CODE_USER uint32 = 1000
+)
+
+var (
+	ErrUnknownMethod  = &RemoteError{Code: CODE_UNKNOWN_METHOD, Message: "unknown method"}
+	ErrInvalidRequest = &RemoteError{Code: CODE_INVALID_REQUEST, Message: "invalid request"}
+	ErrOverloaded     = &RemoteError{Code: CODE_OVERLOADED, Message: "server overloaded"}
+	ErrUnavailable    = &RemoteError{Code: CODE_UNAVAILABLE, Message: "server shutting down"}
+)
+
+var (
+	ErrMethodNameTooLong = fmt.Errorf("rpc: method name too long.")
+	ErrClientClosed      = fmt.Errorf("rpc: client closed.")
+)
+
+//RemoteError is an error returned by a handler, as received by the client.
+//
+//errors.Is matches a RemoteError with any RemoteError of the same code, and with context.DeadlineExceeded
+//or context.Canceled for CODE_DEADLINE_EXCEEDED and CODE_CANCELED.
+type RemoteError struct {
+	Code    uint32
+	Message string
+}
+
+//NewError returns an error a handler could return for sending a specific code to the client,
+//any other error is sent as CODE_INTERNAL.
+func NewError(code uint32, format string, args ...interface{}) *RemoteError {
+	return &RemoteError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("rpc: remote error %d: %s", e.Code, e.Message)
+}
+
+func (e *RemoteError) Is(target error) bool {
+	switch t := target.(type) {
+	case *RemoteError:
+		return t.Code == e.Code
+	}
+	return (target == context.DeadlineExceeded && e.Code == CODE_DEADLINE_EXCEEDED) ||
+		(target == context.Canceled && e.Code == CODE_CANCELED)
+}
+
+//Marshaler writes a request or a response, e.g. *schema.DynamicMessage.
+type Marshaler interface {
+	Marshal(w memory.MemorySegmentProxyer) error
+}
+
+//Unmarshaler reads a response.
+type Unmarshaler interface {
+	Unmarshal(r io.Reader) error
+}
+
+//Bytes is a raw request or response.
+type Bytes []byte
+
+func (b Bytes) Marshal(w memory.MemorySegmentProxyer) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return w.WriteMemory(b)
+}
+
+func (b *Bytes) Unmarshal(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	*b = data
+	return err
+}
+
+func writeRequestHeader(w memory.MemorySegmentProxyer, method string, timeout int64) error {
+	if len(method) > 0xffff {
+		return ErrMethodNameTooLong
+	}
+	header := make([]byte, 2+len(method)+8)
+	binary.LittleEndian.PutUint16(header, uint16(len(method)))
+	copy(header[2:], method)
+	binary.LittleEndian.PutUint64(header[2+len(method):], uint64(timeout))
+	return w.WriteMemory(header)
+}
+
+func readRequestHeader(r io.Reader) (string, int64, error) {
+	var size [8]byte
+	if _, err := io.ReadFull(r, size[:2]); err != nil {
+		return "", 0, ErrInvalidRequest
+	}
+	method := make([]byte, binary.LittleEndian.Uint16(size[:2]))
+	if _, err := io.ReadFull(r, method); err != nil {
+		return "", 0, ErrInvalidRequest
+	}
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", 0, ErrInvalidRequest
+	}
+	return string(method), int64(binary.LittleEndian.Uint64(size[:])), nil
+}
+
+func writeError(w memory.MemorySegmentProxyer, e *RemoteError) error {
+	data := make([]byte, 4+len(e.Message))
+	binary.LittleEndian.PutUint32(data, e.Code)
+	copy(data[4:], e.Message)
+	return w.WriteMemory(data)
+}
+
+func readError(r io.Reader) *RemoteError {
+	data, err := io.ReadAll(r)
+	if err != nil || len(data) < 4 {
+		return &RemoteError{Code: CODE_INTERNAL, Message: "malformed error frame"}
+	}
+	return &RemoteError{Code: binary.LittleEndian.Uint32(data), Message: string(data[4:])}
+}
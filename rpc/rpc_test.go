@@ -0,0 +1,234 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type RPCSuite struct{}
+
+var _ = Suite(&RPCSuite{})
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(4*1024*1024, 256)
+	return mp
+}
+
+func echo(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+	data, err := io.ReadAll(req)
+	if err != nil {
+		return err
+	}
+	return Bytes(data).Marshal(resp)
+}
+
+//start returns a connected client and server, the server also has a "block" method which waits for its context,
+//reporting the context error on blocked, and a "deadline" method which returns the time left before its deadline.
+func start(c *C, workers, queueSize int) (*Server, *Client, chan error) {
+	mp := newProvider()
+	s := NewServer(mp, workers, queueSize)
+	s.Register("echo", echo)
+	blocked := make(chan error, 16)
+	s.Register("block", func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+		<-ctx.Done()
+		blocked <- ctx.Err()
+		return ctx.Err()
+	})
+	s.Register("deadline", func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return NewError(CODE_USER, "no deadline")
+		}
+		return Bytes(time.Until(deadline).String()).Marshal(resp)
+	})
+	c.Assert(s.Listen("127.0.0.1:0"), IsNil)
+	cl, err := Dial(s.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	return s, cl, blocked
+}
+
+func (s *RPCSuite) Test_Call_Concurrent(c *C) {
+	server, cl, _ := start(c, 4, 64)
+	defer server.Shutdown(context.Background())
+	defer cl.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := Bytes(fmt.Sprintf("request %d %0500d", i, i))
+			var resp Bytes
+			c.Check(cl.Call(context.Background(), "echo", req, &resp), IsNil)
+			c.Check(string(resp), Equals, string(req))
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(cl.Call(context.Background(), "echo", nil, nil), IsNil)
+}
+
+func (s *RPCSuite) Test_Call_Deadline(c *C) {
+	server, cl, blocked := start(c, 2, 8)
+	defer server.Shutdown(context.Background())
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var left Bytes
+	c.Assert(cl.Call(ctx, "deadline", nil, &left), IsNil)
+	d, err := time.ParseDuration(string(left))
+	c.Assert(err, IsNil)
+	c.Assert(d > 50*time.Second && d <= time.Minute, Equals, true, Commentf("%v", d))
+	c.Assert(errors.Is(cl.Call(context.Background(), "deadline", nil, nil), &RemoteError{Code: CODE_USER}), Equals, true)
+
+	//the handler gives up at the same deadline as the client.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = cl.Call(ctx, "block", nil, nil)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	c.Assert(<-blocked, Equals, context.DeadlineExceeded)
+}
+
+func (s *RPCSuite) Test_Call_Cancel(c *C) {
+	server, cl, blocked := start(c, 2, 8)
+	defer server.Shutdown(context.Background())
+	defer cl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	c.Assert(cl.Call(ctx, "block", nil, nil), Equals, context.Canceled)
+	select {
+	case err := <-blocked:
+		c.Assert(err, Equals, context.Canceled)
+	case <-time.After(5 * time.Second):
+		c.Fatal("the cancellation didn't reach the handler")
+	}
+	//the connection is still usable.
+	var resp Bytes
+	c.Assert(cl.Call(context.Background(), "echo", Bytes("after"), &resp), IsNil)
+	c.Assert(string(resp), Equals, "after")
+}
+
+func (s *RPCSuite) Test_RemoteErrors(c *C) {
+	server, cl, _ := start(c, 1, 1)
+	defer cl.Close()
+	server.Register("fail", func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+		return NewError(CODE_USER+1, "not found: %s", "x")
+	})
+	server.Register("panic", func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+		return errors.New("boom")
+	})
+
+	err := cl.Call(context.Background(), "fail", nil, nil)
+	remote, ok := err.(*RemoteError)
+	c.Assert(ok, Equals, true)
+	c.Assert(remote.Code, Equals, CODE_USER+1)
+	c.Assert(remote.Message, Equals, "not found: x")
+
+	err = cl.Call(context.Background(), "panic", nil, nil)
+	c.Assert(err, DeepEquals, &RemoteError{Code: CODE_INTERNAL, Message: "boom"})
+	c.Assert(errors.Is(cl.Call(context.Background(), "missing", nil, nil), ErrUnknownMethod), Equals, true)
+
+	//a single worker with a queue of one refuses a third call while the first one is served.
+	started := make(chan struct{})
+	server.Register("hold", func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	called := make(chan error)
+	go func() {
+		called <- cl.Call(ctx, "hold", nil, nil)
+	}()
+	<-started
+	queued := make(chan error)
+	go func() {
+		queued <- cl.Call(context.Background(), "echo", nil, nil)
+	}()
+	for len(server.jobs) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(errors.Is(cl.Call(context.Background(), "echo", nil, nil), ErrOverloaded), Equals, true)
+	cancel()
+	c.Assert(<-called, Equals, context.Canceled)
+	c.Assert(<-queued, IsNil)
+
+	c.Assert(server.Shutdown(context.Background()), IsNil)
+	err = cl.Call(context.Background(), "echo", nil, nil)
+	c.Assert(err == ErrClientClosed || errors.Is(err, ErrUnavailable), Equals, true, Commentf("%v", err))
+}
+
+func (s *RPCSuite) Test_Shutdown_Drains(c *C) {
+	mp := newProvider()
+	server := NewServer(mp, 2, 8)
+	release := make(chan struct{})
+	server.Register("slow", func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error {
+		<-release
+		return resp.WriteMemory([]byte("done"))
+	})
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	cl, err := Dial(server.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	defer cl.Close()
+
+	results := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			var resp Bytes
+			err := cl.Call(context.Background(), "slow", nil, &resp)
+			if err == nil && string(resp) != "done" {
+				err = fmt.Errorf("unexpected response %q", resp)
+			}
+			results <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	c.Assert(<-shutdown, IsNil)
+	for i := 0; i < 3; i++ {
+		c.Assert(<-results, IsNil)
+	}
+}
+
+func (s *RPCSuite) Test_RemoteError_Is(c *C) {
+	err := error(&RemoteError{Code: CODE_DEADLINE_EXCEEDED})
+	c.Assert(errors.Is(err, context.DeadlineExceeded), Equals, true)
+	c.Assert(errors.Is(err, context.Canceled), Equals, false)
+	c.Assert(errors.Is(err, ErrOverloaded), Equals, false)
+	c.Assert(errors.Is(fmt.Errorf("wrapped: %w", ErrOverloaded), &RemoteError{Code: CODE_OVERLOADED}), Equals, true)
+}
+
+func (s *RPCSuite) Test_RefusedUnderPressure(c *C) {
+	server, cl, _ := start(c, 1, 8)
+	defer server.Shutdown(context.Background())
+	defer cl.Close()
+	c.Assert(server.mp.SetWatermarks(memory.Watermarks{Low: 0.5, High: 0.6}), IsNil)
+
+	hog := server.mp.NewSegmentProxy()
+	c.Assert(hog.WriteMemory(make([]byte, 2304*1024)), IsNil)
+	c.Assert(server.mp.UnderPressure(), Equals, true)
+	c.Assert(errors.Is(cl.Call(context.Background(), "echo", nil, nil), ErrOverloaded), Equals, true)
+	hog.Close()
+	c.Assert(cl.Call(context.Background(), "echo", nil, nil), IsNil)
+}
@@ -0,0 +1,268 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	"github.com/gomsg/transport"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//HandlerFunc serves a call, it reads the request from req and writes the response into resp.
+//It should give up once ctx is done, which happens when the deadline of the call expires,
+//the client cancels the call or the server is forced to shut down.
+type HandlerFunc func(ctx context.Context, req *memory.MemorySegmentReader, resp memory.MemorySegmentProxyer) error
+
+type connContextKey struct{}
+
+//ConnFromContext returns the connection a call came from, from the context passed to its handler.
+func ConnFromContext(ctx context.Context) *transport.Conn {
+	conn, _ := ctx.Value(connContextKey{}).(*transport.Conn)
+	return conn
+}
+
+//callKey identifies a call being served, correlation ids are only unique per connection.
+type callKey struct {
+	conn *transport.Conn
+	id   uint64
+}
+
+type job struct {
+	conn   *transport.Conn
+	f      *frame.Frame
+	req    *memory.MemorySegmentReader
+	method string
+	ctx    context.Context
+	cancel context.CancelFunc
+	span   trace.Span
+	start  time.Time
+}
+
+//Server dispatches calls to their handlers on a bounded pool of workers.
+//
+//Requests are queued for the workers, a request arriving while the queue is full is refused with ErrOverloaded
+//rather than blocking the connection, so that cancel frames keep flowing. So is a request arriving while the pool
+//is under pressure, see memory.MemoryProvider.SetWatermarks.
+//
+//The calls are instrumented with the Telemetry of the transport, which SHOULD be set before Listen.
+type Server struct {
+	*transport.Server
+	mp       *memory.MemoryProvider
+	methods  map[string]HandlerFunc
+	jobs     chan *job
+	calls    map[callKey]context.CancelFunc
+	closing  bool
+	workers  sync.WaitGroup
+	baseCtx  context.Context
+	abortAll context.CancelFunc
+	lock     sync.RWMutex
+	//telemetry of the calls, created from the Telemetry of the transport by the first call.
+	telemetry     *telemetry
+	telemetryOnce sync.Once
+}
+
+//NewServer returns a server running its handlers on workers goroutines, up to queueSize requests wait for a worker.
+func NewServer(mp *memory.MemoryProvider, workers, queueSize int) *Server {
+	if workers <= 0 {
+		workers = 1
+	}
+	s := &Server{
+		Server:  transport.NewServer(mp),
+		mp:      mp,
+		methods: make(map[string]HandlerFunc),
+		jobs:    make(chan *job, queueSize),
+		calls:   make(map[callKey]context.CancelFunc)}
+	s.baseCtx, s.abortAll = context.WithCancel(context.Background())
+	s.HandleFunc(MESSAGE_TYPE_REQUEST, s.enqueue)
+	s.HandleFunc(MESSAGE_TYPE_CANCEL, s.cancel)
+	s.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+//Register sets the handler of a method, replacing the previous one.
+func (s *Server) Register(method string, h HandlerFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.methods[method] = h
+}
+
+func (s *Server) enqueue(conn *transport.Conn, f *frame.Frame) {
+	req := f.Body.NewReader()
+	method, timeout, err := readRequestHeader(req)
+	if err != nil {
+		s.replyError(context.Background(), conn, f, ErrInvalidRequest)
+		return
+	}
+	j := &job{conn: conn, f: f, req: req, method: method, start: time.Now()}
+	ctx, span := s.getTelemetry().start(transport.FrameContext(s.baseCtx, f), method)
+	j.span = span
+	ctx = context.WithValue(ctx, connContextKey{}, conn)
+	if timeout > 0 {
+		j.ctx, j.cancel = context.WithTimeout(ctx, time.Duration(timeout))
+	} else {
+		j.ctx, j.cancel = context.WithCancel(ctx)
+	}
+	key := callKey{conn, f.CorrelationID}
+
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		s.refuse(j, ErrUnavailable)
+		return
+	}
+	if s.mp.UnderPressure() {
+		s.lock.Unlock()
+		s.refuse(j, ErrOverloaded)
+		return
+	}
+	select {
+	case s.jobs <- j:
+		s.calls[key] = j.cancel
+		s.lock.Unlock()
+	default:
+		s.lock.Unlock()
+		s.refuse(j, ErrOverloaded)
+	}
+}
+
+//refuse replies to a call which won't be served.
+func (s *Server) refuse(j *job, e *RemoteError) {
+	s.replyError(j.ctx, j.conn, j.f, e)
+	s.endCall(j, e)
+}
+
+//getTelemetry returns the telemetry of the calls.
+func (s *Server) getTelemetry() *telemetry {
+	s.telemetryOnce.Do(func() {
+		t := s.Telemetry
+		if t == nil {
+			t = &transport.Telemetry{}
+		}
+		s.telemetry = newTelemetry(t, trace.SpanKindServer)
+	})
+	return s.telemetry
+}
+
+//endCall ends the span of a call, then cancels its context.
+func (s *Server) endCall(j *job, err error) {
+	s.getTelemetry().end(j.ctx, j.span, j.method, j.start, err)
+	j.cancel()
+}
+
+func (s *Server) cancel(conn *transport.Conn, f *frame.Frame) {
+	defer f.Close()
+	s.lock.RLock()
+	cancel := s.calls[callKey{conn, f.CorrelationID}]
+	s.lock.RUnlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *Server) work() {
+	defer s.workers.Done()
+	for j := range s.jobs {
+		err := s.serve(j)
+		s.lock.Lock()
+		delete(s.calls, callKey{j.conn, j.f.CorrelationID})
+		s.lock.Unlock()
+		s.endCall(j, err)
+	}
+}
+
+//serve runs the handler of a call and replies, it returns the error replied if any.
+func (s *Server) serve(j *job) error {
+	defer j.f.Close()
+	s.lock.RLock()
+	h := s.methods[j.method]
+	s.lock.RUnlock()
+	if h == nil {
+		e := NewError(CODE_UNKNOWN_METHOD, "unknown method %q", j.method)
+		s.replyErrorTo(j.ctx, j.conn, j.f.CorrelationID, e)
+		return e
+	}
+	if err := j.ctx.Err(); err != nil {
+		s.replyCtxError(j, err)
+		return err
+	}
+	resp := s.mp.NewSegmentProxy()
+	defer resp.Close()
+	err := h(j.ctx, j.req, resp)
+	if err == nil {
+		if err := j.conn.SendContext(j.ctx, MESSAGE_TYPE_RESPONSE, j.f.CorrelationID, 0, resp); err != nil {
+			log.Warnf("Failed to reply %s to %s: %v", j.method, j.conn.RemoteAddr(), err)
+		}
+		return nil
+	}
+	if ctxErr := j.ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		s.replyCtxError(j, ctxErr)
+		return ctxErr
+	}
+	var remote *RemoteError
+	if !errors.As(err, &remote) {
+		remote = &RemoteError{Code: CODE_INTERNAL, Message: err.Error()}
+	}
+	s.replyErrorTo(j.ctx, j.conn, j.f.CorrelationID, remote)
+	return remote
+}
+
+//replyCtxError replies to a call whose context is done, nothing is sent to a client which canceled the call.
+func (s *Server) replyCtxError(j *job, err error) {
+	if err == context.DeadlineExceeded {
+		s.replyErrorTo(j.ctx, j.conn, j.f.CorrelationID, NewError(CODE_DEADLINE_EXCEEDED, "deadline exceeded"))
+	} else if s.baseCtx.Err() != nil {
+		s.replyErrorTo(j.ctx, j.conn, j.f.CorrelationID, ErrUnavailable)
+	}
+}
+
+//replyError replies to a request which won't be served and closes its frame.
+func (s *Server) replyError(ctx context.Context, conn *transport.Conn, f *frame.Frame, e *RemoteError) {
+	s.replyErrorTo(ctx, conn, f.CorrelationID, e)
+	f.Close()
+}
+
+func (s *Server) replyErrorTo(ctx context.Context, conn *transport.Conn, id uint64, e *RemoteError) {
+	body := s.mp.NewSegmentProxy()
+	defer body.Close()
+	if err := writeError(body, e); err != nil {
+		log.Warnf("Failed to write error reply: %v", err)
+		return
+	}
+	if err := conn.SendContext(ctx, MESSAGE_TYPE_ERROR, id, 0, body); err != nil {
+		log.Warnf("Failed to reply error to %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+//Shutdown refuses new calls with ErrUnavailable, waits for the calls queued or being served,
+//then shuts the transport down. If ctx expires first, the handlers still running are canceled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.closing {
+		s.closing = true
+		close(s.jobs)
+	}
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.abortAll()
+		<-done
+	}
+	err := s.Server.Shutdown(ctx)
+	s.abortAll()
+	return err
+}
@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gomsg/transport"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//instrumentationName is the name of the tracer and of the meter of the package.
+const instrumentationName = "github.com/gomsg/rpc"
+
+//telemetry instruments the calls of a client or a server with the providers of the telemetry of its transport.
+//
+//Every call runs within a span named after its method, a client span around Call and a server span from the request
+//being queued to the reply, child of the client one across the connection. Their duration is recorded,
+//by method and code, in gomsg.rpc.client.duration and gomsg.rpc.server.duration. The requests are marshaled
+//within the spans of memory.MemoryProvider.Marshal.
+type telemetry struct {
+	tracer   trace.Tracer
+	kind     trace.SpanKind
+	duration metric.Float64Histogram
+}
+
+func newTelemetry(t *transport.Telemetry, kind trace.SpanKind) *telemetry {
+	name := "gomsg.rpc.client.duration"
+	if kind == trace.SpanKindServer {
+		name = "gomsg.rpc.server.duration"
+	}
+	duration, err := t.Meter(instrumentationName).Float64Histogram(name, metric.WithUnit("s"),
+		metric.WithDescription("Duration of the calls, by method and code."))
+	if err != nil {
+		log.Warnf("Failed to create %s: %v", name, err)
+		duration, _ = noop.Meter{}.Float64Histogram(name)
+	}
+	return &telemetry{tracer: t.Tracer(instrumentationName), kind: kind, duration: duration}
+}
+
+//start starts the span of a call.
+func (t *telemetry) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, method, trace.WithSpanKind(t.kind), trace.WithAttributes(
+		attribute.String("rpc.system", "gomsg"),
+		attribute.String("rpc.method", method)))
+}
+
+//end ends the span of a call which started at start and failed with err, if any, recording its duration.
+func (t *telemetry) end(ctx context.Context, span trace.Span, method string, start time.Time, err error) {
+	code := errorCode(err)
+	t.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("rpc.method", method),
+		attribute.Int64("rpc.gomsg.code", int64(code))))
+	span.SetAttributes(attribute.Int64("rpc.gomsg.code", int64(code)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//errorCode returns the code of the RemoteError err stands for, 0 for nil.
+func errorCode(err error) uint32 {
+	var remote *RemoteError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &remote):
+		return remote.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return CODE_DEADLINE_EXCEEDED
+	case errors.Is(err, context.Canceled):
+		return CODE_CANCELED
+	case err == ErrClientClosed:
+		return CODE_UNAVAILABLE
+	}
+	return CODE_INTERNAL
+}
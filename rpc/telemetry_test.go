@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gomsg/memory"
+	"github.com/gomsg/transport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RPCSuite) Test_Telemetry(c *C) {
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	telemetry := &transport.Telemetry{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))}
+	mp, err := memory.NewMemoryProvider(memory.WithPoolSize(1024*1024),
+		memory.WithTelemetry(telemetry.TracerProvider, telemetry.MeterProvider))
+	c.Assert(err, IsNil)
+	server := NewServer(mp, 1, 1)
+	server.Telemetry = telemetry
+	server.Register("echo", echo)
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	defer server.Shutdown(context.Background())
+	cl, err := Dial(server.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	defer cl.Close()
+	cl.SetTelemetry(telemetry)
+
+	ctx, root := telemetry.Tracer("test").Start(context.Background(), "root")
+	var resp Bytes
+	c.Assert(cl.Call(ctx, "echo", Bytes("traced"), &resp), IsNil)
+	c.Assert(string(resp), Equals, "traced")
+	c.Assert(errors.Is(cl.Call(ctx, "missing", nil, nil), ErrUnknownMethod), Equals, true)
+	root.End()
+
+	//root, then the spans of both calls: client, request sent and received, server, reply sent and received,
+	//along with the marshaling of the echo request.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.GetSpans()) < 1+2*6+1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	spans := exporter.GetSpans()
+	c.Assert(len(spans), Equals, 1+2*6+1)
+	byID := map[trace.SpanID]tracetest.SpanStub{}
+	calls := map[trace.SpanKind][]tracetest.SpanStub{}
+	for _, span := range spans {
+		c.Assert(span.SpanContext.TraceID(), Equals, root.SpanContext().TraceID())
+		byID[span.SpanContext.SpanID()] = span
+		if span.SpanKind == trace.SpanKindClient || span.SpanKind == trace.SpanKindServer {
+			calls[span.SpanKind] = append(calls[span.SpanKind], span)
+		}
+	}
+	c.Assert(calls[trace.SpanKindClient], HasLen, 2)
+	c.Assert(calls[trace.SpanKindServer], HasLen, 2)
+	for _, call := range calls[trace.SpanKindClient] {
+		c.Assert(call.Parent.SpanID(), Equals, root.SpanContext().SpanID())
+	}
+	//the server span is a child of the request sent by the client span.
+	for _, call := range calls[trace.SpanKindServer] {
+		request := byID[call.Parent.SpanID()]
+		c.Assert(request.Name, Equals, "gomsg.send")
+		client := byID[request.Parent.SpanID()]
+		c.Assert(client.SpanKind, Equals, trace.SpanKindClient)
+		c.Assert(client.Name, Equals, call.Name)
+		switch call.Name {
+		case "echo":
+			c.Assert(call.Status.Code, Equals, codes.Unset)
+			c.Assert(call.Attributes, DeepEquals, []attribute.KeyValue{
+				attribute.String("rpc.system", "gomsg"),
+				attribute.String("rpc.method", "echo"),
+				attribute.Int64("rpc.gomsg.code", 0)})
+		case "missing":
+			c.Assert(call.Status.Code, Equals, codes.Error)
+			c.Assert(client.Status.Code, Equals, codes.Error)
+			c.Assert(client.Attributes[2], Equals, attribute.Int64("rpc.gomsg.code", int64(CODE_UNKNOWN_METHOD)))
+		default:
+			c.Fatalf("unexpected call %s", call.Name)
+		}
+	}
+	marshaled := 0
+	for _, span := range spans {
+		if span.Name == "gomsg.memory.marshal" {
+			marshaled++
+			c.Assert(byID[span.Parent.SpanID()].Name, Equals, "echo")
+			c.Assert(byID[span.Parent.SpanID()].SpanKind, Equals, trace.SpanKindClient)
+		}
+	}
+	c.Assert(marshaled, Equals, 1)
+
+	var rm metricdata.ResourceMetrics
+	c.Assert(reader.Collect(context.Background(), &rm), IsNil)
+	durations := map[string]map[string]uint64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "gomsg.rpc.client.duration" && m.Name != "gomsg.rpc.server.duration" {
+				continue
+			}
+			durations[m.Name] = map[string]uint64{}
+			for _, dp := range m.Data.(metricdata.Histogram[float64]).DataPoints {
+				method, _ := dp.Attributes.Value("rpc.method")
+				code, _ := dp.Attributes.Value("rpc.gomsg.code")
+				durations[m.Name][method.Emit()+"/"+code.Emit()] = dp.Count
+			}
+		}
+	}
+	expected := map[string]uint64{"echo/0": 1, "missing/2": 1}
+	c.Assert(durations, DeepEquals, map[string]map[string]uint64{
+		"gomsg.rpc.client.duration": expected,
+		"gomsg.rpc.server.duration": expected})
+}
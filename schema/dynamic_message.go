@@ -0,0 +1,285 @@
+package schema
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gomsg/memory"
+)
+
+var (
+	//max length of a single string or bytes field being read.
+	maxFieldLength uint32 = 1024 * 1024 * 16
+	//max number of bytes allocated ahead of a field, the length read is untrusted.
+	maxPrealloc uint32 = 1024
+)
+
+//DynamicMessage is a message whose fields are accessed by name through its schema.
+//
+//Fields unknown to the schema (e.g. added by a newer version of the sender) are kept as they were read
+//and written back by Marshal, so a message can be rewritten without losing them.
+type DynamicMessage struct {
+	schema  *Schema
+	values  []interface{}
+	unknown [][]byte
+}
+
+func NewDynamicMessage(s *Schema) *DynamicMessage {
+	return &DynamicMessage{schema: s, values: make([]interface{}, len(s.Fields))}
+}
+
+func (m *DynamicMessage) Schema() *Schema {
+	return m.schema
+}
+
+func (m *DynamicMessage) fieldIndex(name string) (int, error) {
+	for i, f := range m.schema.Fields {
+		if f.Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w %s.%s", ErrUnknownField, m.schema.Name, name)
+}
+
+//Get returns the value of a field, or its default value when it isn't set.
+func (m *DynamicMessage) Get(name string) (interface{}, error) {
+	i, err := m.fieldIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	if m.values[i] == nil {
+		return m.schema.Fields[i].defaultValue(), nil
+	}
+	return m.values[i], nil
+}
+
+//Set assigns a field, v MUST be the exact Go type of the field (e.g. int32 for an int32 field).
+func (m *DynamicMessage) Set(name string, v interface{}) error {
+	i, err := m.fieldIndex(name)
+	if err != nil {
+		return err
+	}
+	f := m.schema.Fields[i]
+	if !f.Type.accepts(v) {
+		return fmt.Errorf("%w %s.%s is %s, got %T.", ErrFieldTypeMismatch, m.schema.Name, name, f.Type, v)
+	}
+	m.values[i] = v
+	return nil
+}
+
+//Has returns true if the field was set or read.
+func (m *DynamicMessage) Has(name string) bool {
+	i, err := m.fieldIndex(name)
+	return err == nil && m.values[i] != nil
+}
+
+//Clear unsets a field, Get returns its default value afterwards.
+func (m *DynamicMessage) Clear(name string) error {
+	i, err := m.fieldIndex(name)
+	if err != nil {
+		return err
+	}
+	m.values[i] = nil
+	return nil
+}
+
+//Marshal writes the set fields, then the unknown fields, then an end marker.
+//
+//Each field is written as a 4 bytes tag (field id << 3 | wire type) followed by a fixed 4 or 8 bytes value,
+//or a 4 bytes length and the data. All integers are little endian.
+func (m *DynamicMessage) Marshal(w memory.MemorySegmentProxyer) error {
+	for i, f := range m.schema.Fields {
+		if m.values[i] == nil {
+			continue
+		}
+		if err := w.WriteUInt32(uint32(f.ID)<<3|f.Type.wireType(), uint32Serialization); err != nil {
+			return err
+		}
+		if err := writeValue(w, m.values[i]); err != nil {
+			return err
+		}
+	}
+	for _, raw := range m.unknown {
+		if err := w.WriteMemory(raw); err != nil {
+			return err
+		}
+	}
+	return w.WriteUInt32(0, uint32Serialization)
+}
+
+func writeValue(w memory.MemorySegmentProxyer, v interface{}) error {
+	switch value := v.(type) {
+	case bool:
+		if value {
+			return w.WriteUInt32(1, uint32Serialization)
+		}
+		return w.WriteUInt32(0, uint32Serialization)
+	case int32:
+		return w.WriteInt32(value, int32Serialization)
+	case uint32:
+		return w.WriteUInt32(value, uint32Serialization)
+	case int64:
+		return w.WriteInt64(value, int64Serialization)
+	case uint64:
+		return w.WriteUInt64(value, uint64Serialization)
+	case float64:
+		return w.WriteUInt64(math.Float64bits(value), uint64Serialization)
+	case string:
+		if err := w.WriteUInt32(uint32(len(value)), uint32Serialization); err != nil {
+			return err
+		}
+		return w.WriteMemory([]byte(value))
+	case []byte:
+		if err := w.WriteUInt32(uint32(len(value)), uint32Serialization); err != nil {
+			return err
+		}
+		return w.WriteMemory(value)
+	}
+	return fmt.Errorf("%w %T", ErrFieldTypeMismatch, v)
+}
+
+//Unmarshal reads a message written by Marshal, following the evolution rules:
+//fields missing from the data keep their default values and fields unknown to s are kept aside.
+func Unmarshal(s *Schema, r io.Reader) (*DynamicMessage, error) {
+	m := NewDynamicMessage(s)
+	buf := make([]byte, 4)
+	for {
+		if err := readFull(r, buf[:4]); err != nil {
+			return nil, err
+		}
+		tag := binary.LittleEndian.Uint32(buf[:4])
+		if tag == 0 {
+			return m, nil
+		}
+		//field ids are 16 bits and 0 is reserved, an id beyond can't be written back by Marshal.
+		id := tag >> 3
+		if id == 0 || id > math.MaxUint16 {
+			return nil, fmt.Errorf("%w %s: field id %d.", ErrInvalidFieldID, s.Name, id)
+		}
+		wireType := tag & 0x7
+		raw, offset, err := readPayload(r, wireType)
+		if err != nil {
+			return nil, err
+		}
+		f := s.FieldByID(uint16(id))
+		if f == nil {
+			m.unknown = append(m.unknown, append(buf[:4:4], raw...))
+			continue
+		}
+		if f.Type.wireType() != wireType {
+			return nil, fmt.Errorf("%w %s.%s is %s, got wire type %d.", ErrFieldTypeMismatch, s.Name, f.Name, f.Type, wireType)
+		}
+		for i := range s.Fields {
+			if s.Fields[i] == f {
+				m.values[i] = decodeValue(f.Type, raw[offset:])
+				break
+			}
+		}
+	}
+}
+
+//readPayload returns the raw field data following the tag and the offset of the value within it,
+//which skips the length of a length-delimited field.
+func readPayload(r io.Reader, wireType uint32) ([]byte, int, error) {
+	var raw []byte
+	switch wireType {
+	case wireFixed32:
+		raw = make([]byte, 4)
+	case wireFixed64:
+		raw = make([]byte, 8)
+	case wireLengthDelimited:
+		raw, err := readLengthDelimited(r)
+		return raw, 4, err
+	default:
+		return nil, 0, fmt.Errorf("schema: unknown wire type %d.", wireType)
+	}
+	if err := readFull(r, raw); err != nil {
+		return nil, 0, err
+	}
+	return raw, 0, nil
+}
+
+//readLengthDelimited returns the length of a field followed by its data,
+//the length is untrusted so the data grows only as its bytes come in.
+func readLengthDelimited(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if err := readFull(r, header); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(header)
+	if n > maxFieldLength {
+		return nil, fmt.Errorf("schema: field length %d exceeded max length.", n)
+	}
+	prealloc := n
+	if prealloc > maxPrealloc {
+		prealloc = maxPrealloc
+	}
+	size := int(4 + n)
+	raw := append(make([]byte, 0, 4+prealloc), header...)
+	for len(raw) < size {
+		if len(raw) == cap(raw) {
+			raw = append(raw, 0)[:len(raw)]
+		}
+		end := cap(raw)
+		if end > size {
+			end = size
+		}
+		if err := readFull(r, raw[len(raw):end]); err != nil {
+			return nil, err
+		}
+		raw = raw[:end]
+	}
+	return raw, nil
+}
+
+func decodeValue(ft FieldType, payload []byte) interface{} {
+	switch ft {
+	case BOOL:
+		return binary.LittleEndian.Uint32(payload) != 0
+	case INT32:
+		return int32(binary.LittleEndian.Uint32(payload))
+	case UINT32:
+		return binary.LittleEndian.Uint32(payload)
+	case INT64:
+		return int64(binary.LittleEndian.Uint64(payload))
+	case UINT64:
+		return binary.LittleEndian.Uint64(payload)
+	case FLOAT64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(payload))
+	case STRING:
+		return string(payload)
+	}
+	return payload
+}
+
+func readFull(r io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func int32Serialization(v int32) ([]byte, error) {
+	return uint32Serialization(uint32(v))
+}
+
+func uint32Serialization(v uint32) ([]byte, error) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, v)
+	return data, nil
+}
+
+func int64Serialization(v int64) ([]byte, error) {
+	return uint64Serialization(uint64(v))
+}
+
+func uint64Serialization(v uint64) ([]byte, error) {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, v)
+	return data, nil
+}
@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var fieldTypesByName = map[string]FieldType{}
+
+func init() {
+	for ft, name := range fieldTypeNames {
+		fieldTypesByName[name] = ft
+	}
+}
+
+//Parse reads message schemas from their text definition.
+//
+//	# comments start with a hash.
+//	message 100 Order {
+//	    1 id     uint64
+//	    2 symbol string
+//	    3 qty    int32  = 1
+//	    4 note   string = "n/a"
+//	    5 raw    bytes  = 0xcafe
+//	}
+//
+//A message is declared by its type id and name, each field by its id, name, type and an optional default value.
+func Parse(text string) ([]*Schema, error) {
+	schemas := []*Schema{}
+	var current *Schema
+	for i, line := range strings.Split(text, "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if current == nil {
+			s, err := parseMessageHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("schema: line %d: %s", lineNo, err.Error())
+			}
+			current = s
+			continue
+		}
+		if line == "}" {
+			if err := current.init(); err != nil {
+				return nil, err
+			}
+			schemas = append(schemas, current)
+			current = nil
+			continue
+		}
+		f, err := parseField(line)
+		if err != nil {
+			return nil, fmt.Errorf("schema: line %d: %s", lineNo, err.Error())
+		}
+		current.Fields = append(current.Fields, f)
+	}
+	if current != nil {
+		return nil, fmt.Errorf("schema: message %s isn't closed.", current.Name)
+	}
+	return schemas, nil
+}
+
+//stripComment removes everything after a hash which isn't quoted.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func parseMessageHeader(line string) (*Schema, error) {
+	parts := strings.Fields(line)
+	if len(parts) != 4 || parts[0] != "message" || parts[3] != "{" {
+		return nil, fmt.Errorf("expected \"message <type id> <name> {\", got %q.", line)
+	}
+	typeID, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message type id %q.", parts[1])
+	}
+	return &Schema{TypeID: uint32(typeID), Name: parts[2]}, nil
+}
+
+func parseField(line string) (*Field, error) {
+	definition, defaultLiteral := line, ""
+	if idx := strings.Index(line, "="); idx >= 0 {
+		definition, defaultLiteral = line[:idx], strings.TrimSpace(line[idx+1:])
+		if defaultLiteral == "" {
+			return nil, fmt.Errorf("missing default value.")
+		}
+	}
+	parts := strings.Fields(definition)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("expected \"<id> <name> <type> [= <default>]\", got %q.", line)
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field id %q.", parts[0])
+	}
+	ft, ok := fieldTypesByName[parts[2]]
+	if !ok {
+		return nil, fmt.Errorf("unknown field type %q.", parts[2])
+	}
+	f := &Field{ID: uint16(id), Name: parts[1], Type: ft}
+	if defaultLiteral != "" {
+		if f.Default, err = parseLiteral(ft, defaultLiteral); err != nil {
+			return nil, fmt.Errorf("invalid default value of %s: %s", f.Name, err.Error())
+		}
+	}
+	return f, nil
+}
+
+func parseLiteral(ft FieldType, literal string) (interface{}, error) {
+	switch ft {
+	case BOOL:
+		return strconv.ParseBool(literal)
+	case INT32:
+		v, err := strconv.ParseInt(literal, 0, 32)
+		return int32(v), err
+	case INT64:
+		return strconv.ParseInt(literal, 0, 64)
+	case UINT32:
+		v, err := strconv.ParseUint(literal, 0, 32)
+		return uint32(v), err
+	case UINT64:
+		return strconv.ParseUint(literal, 0, 64)
+	case FLOAT64:
+		return strconv.ParseFloat(literal, 64)
+	case STRING:
+		return strconv.Unquote(literal)
+	}
+	if !strings.HasPrefix(literal, "0x") {
+		return nil, fmt.Errorf("bytes literal must start with 0x.")
+	}
+	return hex.DecodeString(literal[2:])
+}
@@ -0,0 +1,90 @@
+package schema
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+//Registry holds the current schema of every message type, keyed by the message type id.
+//
+//A schema can be registered again with a newer definition as long as the evolution is compatible:
+//fields may be added or removed, but a field id always keeps the type it was first registered with,
+//even after the field was removed, so that old data is never read as a different type.
+type Registry struct {
+	schemas map[uint32]*Schema
+	//every field id ever registered per message type, with its type.
+	fieldTypes map[uint32]map[uint16]FieldType
+	sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		schemas:    make(map[uint32]*Schema),
+		fieldTypes: make(map[uint32]map[uint16]FieldType)}
+}
+
+//Register adds a schema, or replaces the schema of the same message type after checking the evolution rules.
+func (r *Registry) Register(s *Schema) error {
+	if s.byName == nil {
+		if err := s.init(); err != nil {
+			return err
+		}
+	}
+	r.Lock()
+	defer r.Unlock()
+	history := r.fieldTypes[s.TypeID]
+	for _, f := range s.Fields {
+		if ft, ok := history[f.ID]; ok && ft != f.Type {
+			return fmt.Errorf("%w %s: field %d was %s, cannot become %s.", ErrIncompatibleSchema, s.Name, f.ID, ft, f.Type)
+		}
+	}
+	if history == nil {
+		history = make(map[uint16]FieldType, len(s.Fields))
+		r.fieldTypes[s.TypeID] = history
+	}
+	for _, f := range s.Fields {
+		history[f.ID] = f.Type
+	}
+	r.schemas[s.TypeID] = s
+	return nil
+}
+
+//RegisterText parses schema definitions and registers all of them.
+func (r *Registry) RegisterText(text string) error {
+	schemas, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, s := range schemas {
+		if err := r.Register(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Lookup returns nil if the message type was never registered.
+func (r *Registry) Lookup(typeID uint32) *Schema {
+	r.RLock()
+	defer r.RUnlock()
+	return r.schemas[typeID]
+}
+
+//NewMessage returns an empty message of a registered message type.
+func (r *Registry) NewMessage(typeID uint32) (*DynamicMessage, error) {
+	s := r.Lookup(typeID)
+	if s == nil {
+		return nil, ErrUnknownMessageType
+	}
+	return NewDynamicMessage(s), nil
+}
+
+//Unmarshal reads a message of a registered message type.
+func (r *Registry) Unmarshal(typeID uint32, reader io.Reader) (*DynamicMessage, error) {
+	s := r.Lookup(typeID)
+	if s == nil {
+		return nil, ErrUnknownMessageType
+	}
+	return Unmarshal(s, reader)
+}
@@ -0,0 +1,183 @@
+//Package schema describes messages at runtime, so that a message can be inspected and rewritten
+//by its field names without any compiled type.
+package schema
+
+import (
+	"fmt"
+)
+
+//FieldType is the type of a field value.
+type FieldType byte
+
+const (
+	BOOL FieldType = iota + 1
+	INT32
+	INT64
+	UINT32
+	UINT64
+	FLOAT64
+	STRING
+	BYTES
+)
+
+var fieldTypeNames = map[FieldType]string{
+	BOOL:    "bool",
+	INT32:   "int32",
+	INT64:   "int64",
+	UINT32:  "uint32",
+	UINT64:  "uint64",
+	FLOAT64: "float64",
+	STRING:  "string",
+	BYTES:   "bytes",
+}
+
+func (ft FieldType) String() string {
+	if name, ok := fieldTypeNames[ft]; ok {
+		return name
+	}
+	return fmt.Sprintf("FieldType(%d)", ft)
+}
+
+//wire types tell a reader how to skip a field it doesn't know.
+const (
+	wireFixed32 uint32 = iota + 1
+	wireFixed64
+	wireLengthDelimited
+)
+
+func (ft FieldType) wireType() uint32 {
+	switch ft {
+	case BOOL, INT32, UINT32:
+		return wireFixed32
+	case INT64, UINT64, FLOAT64:
+		return wireFixed64
+	}
+	return wireLengthDelimited
+}
+
+//zero returns the Go zero value of a field type.
+func (ft FieldType) zero() interface{} {
+	switch ft {
+	case BOOL:
+		return false
+	case INT32:
+		return int32(0)
+	case INT64:
+		return int64(0)
+	case UINT32:
+		return uint32(0)
+	case UINT64:
+		return uint64(0)
+	case FLOAT64:
+		return float64(0)
+	case STRING:
+		return ""
+	}
+	return []byte{}
+}
+
+//accepts returns true if v is the Go representation of the field type.
+func (ft FieldType) accepts(v interface{}) bool {
+	switch v.(type) {
+	case bool:
+		return ft == BOOL
+	case int32:
+		return ft == INT32
+	case int64:
+		return ft == INT64
+	case uint32:
+		return ft == UINT32
+	case uint64:
+		return ft == UINT64
+	case float64:
+		return ft == FLOAT64
+	case string:
+		return ft == STRING
+	case []byte:
+		return ft == BYTES
+	}
+	return false
+}
+
+//Field describes one field of a message.
+//Default is returned for a field which isn't set, it's the zero value of the type when it's nil.
+type Field struct {
+	ID      uint16
+	Name    string
+	Type    FieldType
+	Default interface{}
+}
+
+//Schema describes a message type.
+type Schema struct {
+	TypeID uint32
+	Name   string
+	Fields []*Field
+	byName map[string]*Field
+	byID   map[uint16]*Field
+}
+
+var (
+	ErrUnknownField       = fmt.Errorf("schema: unknown field.")
+	ErrFieldTypeMismatch  = fmt.Errorf("schema: value doesn't match the field type.")
+	ErrUnknownMessageType = fmt.Errorf("schema: unknown message type.")
+	ErrIncompatibleSchema = fmt.Errorf("schema: incompatible schema evolution.")
+	ErrInvalidFieldID     = fmt.Errorf("schema: invalid field id.")
+)
+
+//NewSchema validates the fields and returns a schema which is ready for use.
+func NewSchema(typeID uint32, name string, fields ...*Field) (*Schema, error) {
+	s := &Schema{TypeID: typeID, Name: name, Fields: fields}
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) init() error {
+	if s.Name == "" {
+		return fmt.Errorf("schema: message type %d has no name.", s.TypeID)
+	}
+	s.byName = make(map[string]*Field, len(s.Fields))
+	s.byID = make(map[uint16]*Field, len(s.Fields))
+	for _, f := range s.Fields {
+		if f.ID == 0 {
+			return fmt.Errorf("schema: %s.%s: field id 0 is reserved.", s.Name, f.Name)
+		}
+		if f.Name == "" {
+			return fmt.Errorf("schema: %s: field %d has no name.", s.Name, f.ID)
+		}
+		if _, ok := fieldTypeNames[f.Type]; !ok {
+			return fmt.Errorf("schema: %s.%s: unknown field type %d.", s.Name, f.Name, f.Type)
+		}
+		if _, ok := s.byID[f.ID]; ok {
+			return fmt.Errorf("schema: %s: duplicate field id %d.", s.Name, f.ID)
+		}
+		if _, ok := s.byName[f.Name]; ok {
+			return fmt.Errorf("schema: %s: duplicate field name %s.", s.Name, f.Name)
+		}
+		if f.Default != nil && !f.Type.accepts(f.Default) {
+			return fmt.Errorf("schema: %s.%s: default value %v isn't a %s.", s.Name, f.Name, f.Default, f.Type)
+		}
+		s.byName[f.Name] = f
+		s.byID[f.ID] = f
+	}
+	return nil
+}
+
+//FieldByName returns nil if there is no such field.
+func (s *Schema) FieldByName(name string) *Field {
+	return s.byName[name]
+}
+
+//FieldByID returns nil if there is no such field.
+func (s *Schema) FieldByID(id uint16) *Field {
+	return s.byID[id]
+}
+
+func (f *Field) defaultValue() interface{} {
+	if f.Default != nil {
+		return f.Default
+	}
+	return f.Type.zero()
+}
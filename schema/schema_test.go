@@ -0,0 +1,203 @@
+package schema
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type DynamicSchema struct{}
+
+var _ = Suite(&DynamicSchema{})
+
+const orderV1 = `
+# first version of the order message.
+message 100 Order {
+    1 id     uint64
+    2 symbol string
+    3 qty    int32  = 1
+    4 note   string = "n/a # not a comment"  # a comment
+}
+`
+
+//orderV2 removes "note" and adds "price" and "flags".
+const orderV2 = `
+message 100 Order {
+    1 id     uint64
+    2 symbol string
+    3 qty    int32   = 1
+    5 price  float64 = 9.5
+    6 flags  bytes   = 0xcafe
+    7 urgent bool
+}
+`
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(64*1024, 16)
+	return mp
+}
+
+func (s *DynamicSchema) Test_Parse(c *C) {
+	schemas, err := Parse(orderV1)
+	c.Assert(err, IsNil)
+	c.Assert(len(schemas), Equals, 1)
+	order := schemas[0]
+	c.Assert(order.TypeID, Equals, uint32(100))
+	c.Assert(order.Name, Equals, "Order")
+	c.Assert(len(order.Fields), Equals, 4)
+	c.Assert(order.FieldByName("qty").Default, Equals, int32(1))
+	c.Assert(order.FieldByID(4).Default, Equals, "n/a # not a comment")
+
+	for _, text := range []string{
+		"message 1 A {\n 1 a int8\n}",
+		"message 1 A {\n 1 a int32\n 1 b int32\n}",
+		"message 1 A {\n 1 a int32\n 2 a int32\n}",
+		"message 1 A {\n 0 a int32\n}",
+		"message 1 A {\n 1 a int32 = x\n}",
+		"message 1 A {\n 1 a int32\n",
+		"message A {\n}",
+	} {
+		_, err := Parse(text)
+		c.Assert(err, NotNil, Commentf(text))
+	}
+}
+
+func (s *DynamicSchema) Test_GetSet(c *C) {
+	r := NewRegistry()
+	c.Assert(r.RegisterText(orderV1), IsNil)
+	m, err := r.NewMessage(100)
+	c.Assert(err, IsNil)
+	v, err := m.Get("qty")
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, int32(1))
+	c.Assert(m.Has("qty"), Equals, false)
+	c.Assert(m.Set("qty", int32(5)), IsNil)
+	c.Assert(m.Has("qty"), Equals, true)
+	v, _ = m.Get("qty")
+	c.Assert(v, Equals, int32(5))
+	c.Assert(errors.Is(m.Set("qty", 5), ErrFieldTypeMismatch), Equals, true)
+	c.Assert(errors.Is(m.Set("price", 1.0), ErrUnknownField), Equals, true)
+	c.Assert(m.Clear("qty"), IsNil)
+	v, _ = m.Get("qty")
+	c.Assert(v, Equals, int32(1))
+
+	_, err = r.NewMessage(101)
+	c.Assert(err, Equals, ErrUnknownMessageType)
+}
+
+func (s *DynamicSchema) Test_MarshalUnmarshal(c *C) {
+	r := NewRegistry()
+	c.Assert(r.RegisterText(orderV2), IsNil)
+	m, _ := r.NewMessage(100)
+	c.Assert(m.Set("id", uint64(1<<40)), IsNil)
+	c.Assert(m.Set("symbol", "a symbol longer than a single memory segment"), IsNil)
+	c.Assert(m.Set("price", 12.25), IsNil)
+	c.Assert(m.Set("urgent", true), IsNil)
+
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(m.Marshal(msp), IsNil)
+	decoded, err := r.Unmarshal(100, msp.NewReader())
+	c.Assert(err, IsNil)
+	for _, name := range []string{"id", "symbol", "price", "urgent", "qty", "flags"} {
+		expected, _ := m.Get(name)
+		v, err := decoded.Get(name)
+		c.Assert(err, IsNil)
+		c.Assert(v, DeepEquals, expected, Commentf(name))
+	}
+	c.Assert(decoded.Has("qty"), Equals, false)
+}
+
+func (s *DynamicSchema) Test_Unmarshal_Malformed(c *C) {
+	r := NewRegistry()
+	c.Assert(r.RegisterText(orderV2), IsNil)
+	m, _ := r.NewMessage(100)
+	c.Assert(m.Set("symbol", strings.Repeat("s", 5000)), IsNil)
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(m.Marshal(msp), IsNil)
+	decoded, err := r.Unmarshal(100, msp.NewReader())
+	c.Assert(err, IsNil)
+	symbol, _ := decoded.Get("symbol")
+	c.Assert(symbol, Equals, strings.Repeat("s", 5000))
+
+	for _, data := range []struct {
+		hex string
+		err error
+	}{
+		//a length up to the max length claimed by a few bytes fails once the data runs out.
+		{"13000000" + "ffffff00" + "73", io.ErrUnexpectedEOF},
+		//field ids are 16 bits, 65537 would have been read as field 1.
+		{"0a000800" + "0100000000000000", ErrInvalidFieldID},
+		//field id 0 is reserved.
+		{"01000000" + "01000000", ErrInvalidFieldID},
+	} {
+		raw, _ := hex.DecodeString(data.hex)
+		msp := mp.NewSegmentProxy()
+		defer msp.Close()
+		c.Assert(msp.WriteMemory(raw), IsNil)
+		_, err := r.Unmarshal(100, msp.NewReader())
+		c.Assert(errors.Is(err, data.err), Equals, true, Commentf("%s: %v", data.hex, err))
+	}
+}
+
+func (s *DynamicSchema) Test_Evolution(c *C) {
+	v1, v2 := NewRegistry(), NewRegistry()
+	c.Assert(v1.RegisterText(orderV1), IsNil)
+	c.Assert(v2.RegisterText(orderV1), IsNil)
+	c.Assert(v2.RegisterText(orderV2), IsNil)
+
+	mp := newProvider()
+	//an old writer talks to a new reader: the removed field is kept aside, added fields take their defaults.
+	old, _ := v1.NewMessage(100)
+	c.Assert(old.Set("id", uint64(7)), IsNil)
+	c.Assert(old.Set("note", "urgent"), IsNil)
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(old.Marshal(msp), IsNil)
+	m, err := v2.Unmarshal(100, msp.NewReader())
+	c.Assert(err, IsNil)
+	price, _ := m.Get("price")
+	c.Assert(price, Equals, 9.5)
+	flags, _ := m.Get("flags")
+	c.Assert(flags, DeepEquals, []byte{0xca, 0xfe})
+	_, err = m.Get("note")
+	c.Assert(errors.Is(err, ErrUnknownField), Equals, true)
+
+	//the new reader rewrites the message and forwards it to an old reader, which still sees the note.
+	c.Assert(m.Set("qty", int32(3)), IsNil)
+	forwarded := mp.NewSegmentProxy()
+	defer forwarded.Close()
+	c.Assert(m.Marshal(forwarded), IsNil)
+	back, err := v1.Unmarshal(100, forwarded.NewReader())
+	c.Assert(err, IsNil)
+	note, _ := back.Get("note")
+	c.Assert(note, Equals, "urgent")
+	qty, _ := back.Get("qty")
+	c.Assert(qty, Equals, int32(3))
+	id, _ := back.Get("id")
+	c.Assert(id, Equals, uint64(7))
+}
+
+func (s *DynamicSchema) Test_Register_IncompatibleEvolution(c *C) {
+	r := NewRegistry()
+	c.Assert(r.RegisterText(orderV1), IsNil)
+	c.Assert(r.RegisterText(orderV2), IsNil)
+	//field 4 was a string before it was removed, it cannot come back as another type.
+	err := r.RegisterText("message 100 Order {\n 1 id uint64\n 4 note int64\n}")
+	c.Assert(errors.Is(err, ErrIncompatibleSchema), Equals, true)
+	c.Assert(r.Lookup(100).FieldByName("price"), NotNil)
+	err = r.RegisterText("message 100 Order {\n 1 id int32\n}")
+	c.Assert(errors.Is(err, ErrIncompatibleSchema), Equals, true)
+}
@@ -0,0 +1,129 @@
+package shm
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	futexWait = 0
+	futexWake = 1
+	//a side sleeping on a futex wakes up that often for checking its context.
+	pollInterval = 50 * time.Millisecond
+)
+
+//ring is a single-producer/single-consumer ring of 8 bytes slots in shared memory.
+//
+//Its control block holds the free-running counters of the slots written and read, on separate cache lines,
+//each followed by a flag telling the other side that it's sleeping on the counter.
+type ring struct {
+	tail          *uint32
+	writerWaiting *uint32
+	head          *uint32
+	readerWaiting *uint32
+	slots         []uint64
+	mask          uint32
+}
+
+const ringControlSize = 128
+
+func newRing(region []byte, offset int, size uint32) *ring {
+	control := region[offset : offset+ringControlSize]
+	return &ring{
+		tail:          (*uint32)(unsafe.Pointer(&control[0])),
+		writerWaiting: (*uint32)(unsafe.Pointer(&control[4])),
+		head:          (*uint32)(unsafe.Pointer(&control[64])),
+		readerWaiting: (*uint32)(unsafe.Pointer(&control[68])),
+		slots:         unsafe.Slice((*uint64)(unsafe.Pointer(&region[offset+ringControlSize])), size),
+		mask:          size - 1}
+}
+
+func ringLength(size uint32) int {
+	return ringControlSize + int(size)*8
+}
+
+func (r *ring) size() uint32 {
+	return uint32(len(r.slots))
+}
+
+//readable returns how many slots can be read.
+func (r *ring) readable() uint32 {
+	return atomic.LoadUint32(r.tail) - atomic.LoadUint32(r.head)
+}
+
+//writable returns how many slots can be written.
+func (r *ring) writable() uint32 {
+	return r.size() - r.readable()
+}
+
+//push writes slots, the caller checked that they fit.
+func (r *ring) push(values ...uint64) {
+	tail := atomic.LoadUint32(r.tail)
+	for i, v := range values {
+		atomic.StoreUint64(&r.slots[(tail+uint32(i))&r.mask], v)
+	}
+	atomic.StoreUint32(r.tail, tail+uint32(len(values)))
+	if atomic.LoadUint32(r.readerWaiting) != 0 {
+		wake(r.tail)
+	}
+}
+
+//peek returns the i-th slot readable.
+func (r *ring) peek(i uint32) uint64 {
+	return atomic.LoadUint64(&r.slots[(atomic.LoadUint32(r.head)+i)&r.mask])
+}
+
+//advance frees the n slots read.
+func (r *ring) advance(n uint32) {
+	atomic.StoreUint32(r.head, atomic.LoadUint32(r.head)+n)
+	if atomic.LoadUint32(r.writerWaiting) != 0 {
+		wake(r.head)
+	}
+}
+
+//waitReadable waits until at least n slots can be read.
+func (r *ring) waitReadable(ctx context.Context, n uint32, done <-chan struct{}) error {
+	return r.wait(ctx, done, r.tail, r.readerWaiting, func() bool { return r.readable() >= n })
+}
+
+//waitWritable waits until at least n slots can be written.
+func (r *ring) waitWritable(ctx context.Context, n uint32, done <-chan struct{}) error {
+	return r.wait(ctx, done, r.head, r.writerWaiting, func() bool { return r.writable() >= n })
+}
+
+//wait sleeps on the counter moved by the other side until ready.
+//The other side moves the counter then checks the flag while this side sets the flag then checks the counter,
+//so either this side sees the counter moved or the other side sees the flag and wakes it up.
+func (r *ring) wait(ctx context.Context, done <-chan struct{}, counter, waiting *uint32, ready func() bool) error {
+	for !ready() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return ErrClosed
+		default:
+		}
+		atomic.StoreUint32(waiting, 1)
+		value := atomic.LoadUint32(counter)
+		if !ready() {
+			sleep(counter, value, pollInterval)
+		}
+		atomic.StoreUint32(waiting, 0)
+	}
+	return nil
+}
+
+//sleep waits on a shared futex while it holds value, for timeout at most.
+func sleep(addr *uint32, value uint32, timeout time.Duration) {
+	ts := unix.NsecToTimespec(int64(timeout))
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWait, uintptr(value), uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+//wake wakes the waiters of a shared futex up.
+func wake(addr *uint32) {
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWake, uintptr(1<<31-1), 0, 0, 0)
+}
@@ -0,0 +1,65 @@
+//Package shm passes messages between processes of the same host through shared memory, on Linux.
+//
+//A region is a file, e.g. a memfd, mapped by a producer and a consumer. It holds a pool of memory segments and
+//two single-producer/single-consumer rings of segment descriptors:
+//
+//	offset      field
+//	0           header: magic number ("GSHM"), version, segment size, segment count, sizes of the rings
+//	64          ring of messages, from the producer to the consumer
+//	            ring of segments released by the consumer, back to the producer
+//	page        pool of segments
+//
+//The producer fills a MemorySegmentProxy borrowed from the pool and sends it, which only pushes the indexes and
+//lengths of its segments into the ring. The consumer gets a MemorySegmentProxy over the very same segments,
+//which go back to the producer once the consumer closes it: no byte is copied and no socket is involved.
+//A side waiting on an empty or full ring sleeps on a futex in the region, woken up by the other side.
+package shm
+
+import (
+	"fmt"
+)
+
+var (
+	ErrInvalidOptions  = fmt.Errorf("shm: invalid options.")
+	ErrInvalidRegion   = fmt.Errorf("shm: invalid shared memory region.")
+	ErrMessageTooLarge = fmt.Errorf("shm: message has more segments than the ring holds.")
+	ErrClosed          = fmt.Errorf("shm: closed.")
+)
+
+const (
+	DefaultSegmentSize  uint32 = 4096
+	DefaultSegmentCount uint32 = 1024
+	DefaultRingSize     uint32 = 1024
+)
+
+//Options of a region, zero values take the defaults.
+type Options struct {
+	SegmentSize  uint32
+	SegmentCount uint32
+	//RingSize is the number of segment descriptors the ring of messages holds, a power of 2.
+	RingSize uint32
+}
+
+func (o *Options) withDefaults() (Options, error) {
+	opts := *o
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.SegmentCount == 0 {
+		opts.SegmentCount = DefaultSegmentCount
+	}
+	if opts.RingSize == 0 {
+		opts.RingSize = DefaultRingSize
+	}
+	if opts.SegmentSize > maxSegmentSize || opts.SegmentCount > maxSegmentCount ||
+		opts.RingSize > maxRingSize || opts.RingSize&(opts.RingSize-1) != 0 {
+		return opts, ErrInvalidOptions
+	}
+	return opts, nil
+}
+
+const (
+	maxSegmentSize  = 1 << 30
+	maxSegmentCount = 1 << 30
+	maxRingSize     = 1 << 30
+)
@@ -0,0 +1,339 @@
+package shm
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/gomsg/memory"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	//MagicNumber is "GSHM" in little endian.
+	MagicNumber uint32 = 0x4d485347
+	Version     uint32 = 1
+
+	headerSize = 64
+	cacheLine  = 64
+)
+
+//offsets of the header fields.
+const (
+	magicOffset        = 0
+	versionOffset      = 4
+	segmentSizeOffset  = 8
+	segmentCountOffset = 12
+	ringSizeOffset     = 16
+)
+
+//a slot of the ring of messages holds the index of a segment, its length and whether it's the last of its message.
+const (
+	slotLast  uint64 = 1 << 63
+	noSegment uint32 = 1<<32 - 1
+)
+
+func encodeSlot(desc memory.SegmentDescriptor, last bool) uint64 {
+	v := uint64(desc.Index) | uint64(desc.Length)<<32
+	if last {
+		v |= slotLast
+	}
+	return v
+}
+
+func decodeSlot(v uint64) (memory.SegmentDescriptor, bool) {
+	return memory.SegmentDescriptor{Index: uint32(v), Length: uint32(v>>32) &^ (1 << 31)}, v&slotLast != 0
+}
+
+type layout struct {
+	segmentSize  uint32
+	segmentCount uint32
+	ringSize     uint32
+	returnsSize  uint32
+	messages     int
+	returns      int
+	pool         int
+	size         int
+}
+
+func newLayout(segmentSize, segmentCount, ringSize uint32) layout {
+	//the ring of released segments holds every segment, so pushing into it never waits.
+	returnsSize := uint32(1)
+	for returnsSize < segmentCount {
+		returnsSize <<= 1
+	}
+	l := layout{segmentSize: segmentSize, segmentCount: segmentCount, ringSize: ringSize, returnsSize: returnsSize}
+	l.messages = headerSize
+	l.returns = align(l.messages+ringLength(ringSize), cacheLine)
+	l.pool = align(l.returns+ringLength(returnsSize), os.Getpagesize())
+	l.size = l.pool + int(segmentSize)*int(segmentCount)
+	return l
+}
+
+func align(n, alignment int) int {
+	return (n + alignment - 1) / alignment * alignment
+}
+
+//NewMemfd returns an anonymous file living in memory, e.g. for sending it to another process over
+//a Unix domain socket (see transport.Conn.SendFiles) before creating a region in it.
+func NewMemfd(name string) (*os.File, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+//region is a mapped shared memory region.
+type region struct {
+	data      []byte
+	layout    layout
+	mp        *memory.MemoryProvider
+	messages  *ring
+	returns   *ring
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func mapRegion(f *os.File, size int) ([]byte, error) {
+	return unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+}
+
+func newRegion(data []byte, l layout, available bool) *region {
+	r := &region{
+		data:     data,
+		layout:   l,
+		mp:       &memory.MemoryProvider{},
+		messages: newRing(data, l.messages, l.ringSize),
+		returns:  newRing(data, l.returns, l.returnsSize),
+		done:     make(chan struct{})}
+	r.mp.InitializeFrom(data[l.pool:l.size], uint(l.segmentSize), available)
+	return r
+}
+
+//unmap unmaps the region, the caller holds the locks of its side.
+func (r *region) unmap() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return unix.Munmap(r.data)
+}
+
+//Producer sends messages into a region, there MUST be a single producer per region.
+type Producer struct {
+	*region
+	lock sync.Mutex
+	//segments sent and not reclaimed yet, borrowed from the pool but not by proxies of this process.
+	sent int
+}
+
+//Create lays a new region out in f, resizing it, and returns its producer.
+func Create(f *os.File, opts Options) (*Producer, error) {
+	o, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	l := newLayout(o.SegmentSize, o.SegmentCount, o.RingSize)
+	if err := f.Truncate(int64(l.size)); err != nil {
+		return nil, err
+	}
+	data, err := mapRegion(f, l.size)
+	if err != nil {
+		return nil, err
+	}
+	//the file may be reused, the rings start empty.
+	for i := range data[:l.pool] {
+		data[i] = 0
+	}
+	binary.LittleEndian.PutUint32(data[versionOffset:], Version)
+	binary.LittleEndian.PutUint32(data[segmentSizeOffset:], l.segmentSize)
+	binary.LittleEndian.PutUint32(data[segmentCountOffset:], l.segmentCount)
+	binary.LittleEndian.PutUint32(data[ringSizeOffset:], l.ringSize)
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&data[magicOffset])), MagicNumber)
+	return &Producer{region: newRegion(data, l, true)}, nil
+}
+
+//MemoryProvider returns the provider of the segments of the region, messages sent MUST be borrowed from it.
+func (p *Producer) MemoryProvider() *memory.MemoryProvider {
+	return p.mp
+}
+
+//NewSegmentProxy reclaims the segments released by the consumer, then returns a proxy borrowing from the region.
+func (p *Producer) NewSegmentProxy() memory.MemorySegmentProxyer {
+	p.Reclaim()
+	return p.mp.NewSegmentProxy()
+}
+
+//Reclaim gives the segments released by the consumer back to the pool and returns how many there were.
+//Sending does it too.
+func (p *Producer) Reclaim() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return 0
+	}
+	return p.reclaim()
+}
+
+func (p *Producer) reclaim() int {
+	n := p.returns.readable()
+	for i := uint32(0); i < n; i++ {
+		if err := p.mp.GivebackIndex(uint32(p.returns.peek(i))); err != nil {
+			log.Warnf("Invalid segment %d released by the consumer: %v", uint32(p.returns.peek(i)), err)
+		} else {
+			p.sent--
+		}
+	}
+	p.returns.advance(n)
+	return int(n)
+}
+
+//Send hands the segments of msp over to the consumer, waiting for room in the ring. msp is left empty.
+func (p *Producer) Send(ctx context.Context, msp memory.MemorySegmentProxyer) error {
+	slots := uint32(msp.GetSegmentCount())
+	if slots == 0 {
+		slots = 1
+	}
+	if slots > p.messages.size() {
+		return ErrMessageTooLarge
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	p.reclaim()
+	if err := p.messages.waitWritable(ctx, slots, p.done); err != nil {
+		return err
+	}
+	descs, err := p.mp.Detach(msp)
+	if err != nil {
+		return err
+	}
+	if len(descs) == 0 {
+		p.messages.push(encodeSlot(memory.SegmentDescriptor{Index: noSegment}, true))
+		return nil
+	}
+	values := make([]uint64, len(descs))
+	for i, desc := range descs {
+		values[i] = encodeSlot(desc, i == len(descs)-1)
+	}
+	p.messages.push(values...)
+	p.sent += len(descs)
+	return nil
+}
+
+//Close unmaps the region, the messages sent and not received yet are lost.
+//While proxies borrowing from the region are still open it fails with memory.ErrPoolInUse and keeps
+//the region mapped: calling Close again once they're closed unmaps it.
+func (p *Producer) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.reclaim()
+	if p.mp.Outstanding() > p.sent {
+		return memory.ErrPoolInUse
+	}
+	return p.unmap()
+}
+
+//Consumer receives the messages of a region, there MUST be a single consumer per region.
+type Consumer struct {
+	*region
+	readLock    sync.Mutex
+	releaseLock sync.Mutex
+}
+
+//Open maps a region created by Create, e.g. in another process, and returns its consumer.
+func Open(f *os.File) (*Consumer, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < headerSize {
+		return nil, ErrInvalidRegion
+	}
+	data, err := mapRegion(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	if atomic.LoadUint32((*uint32)(unsafe.Pointer(&data[magicOffset]))) != MagicNumber ||
+		binary.LittleEndian.Uint32(data[versionOffset:]) != Version {
+		unix.Munmap(data)
+		return nil, ErrInvalidRegion
+	}
+	opts := Options{
+		SegmentSize:  binary.LittleEndian.Uint32(data[segmentSizeOffset:]),
+		SegmentCount: binary.LittleEndian.Uint32(data[segmentCountOffset:]),
+		RingSize:     binary.LittleEndian.Uint32(data[ringSizeOffset:])}
+	o, err := opts.withDefaults()
+	if err != nil || o != opts {
+		unix.Munmap(data)
+		return nil, ErrInvalidRegion
+	}
+	l := newLayout(o.SegmentSize, o.SegmentCount, o.RingSize)
+	if l.size > len(data) {
+		unix.Munmap(data)
+		return nil, ErrInvalidRegion
+	}
+	return &Consumer{region: newRegion(data, l, false)}, nil
+}
+
+//Receive waits for a message and returns a proxy over its segments, the caller MUST close it
+//for giving the segments back to the producer.
+func (c *Consumer) Receive(ctx context.Context) (memory.MemorySegmentProxyer, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if err := c.messages.waitReadable(ctx, 1, c.done); err != nil {
+		return nil, err
+	}
+	//the slots of a message are published at once.
+	readable := c.messages.readable()
+	var descs []memory.SegmentDescriptor
+	n := uint32(0)
+	for {
+		if n == readable {
+			return nil, ErrInvalidRegion
+		}
+		desc, last := decodeSlot(c.messages.peek(n))
+		n++
+		if desc.Index != noSegment {
+			descs = append(descs, desc)
+		}
+		if last {
+			break
+		}
+	}
+	c.messages.advance(n)
+	return c.mp.Attach(descs, c.release)
+}
+
+func (c *Consumer) release(index uint32) {
+	c.releaseLock.Lock()
+	defer c.releaseLock.Unlock()
+	if !c.closed {
+		c.returns.push(uint64(index))
+	}
+}
+
+//Close unmaps the region, every message received MUST be closed first.
+func (c *Consumer) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	c.releaseLock.Lock()
+	defer c.releaseLock.Unlock()
+	return c.unmap()
+}
@@ -0,0 +1,235 @@
+package shm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type SHMSuite struct{}
+
+var _ = Suite(&SHMSuite{})
+
+func message(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, i*100)
+}
+
+//pair returns a producer and a consumer mapping the same memfd separately, as two processes would.
+func pair(c *C, opts Options) (*Producer, *Consumer) {
+	f, err := NewMemfd("gomsg-test")
+	c.Assert(err, IsNil)
+	defer f.Close()
+	p, err := Create(f, opts)
+	c.Assert(err, IsNil)
+	consumer, err := Open(f)
+	c.Assert(err, IsNil)
+	return p, consumer
+}
+
+func send(c *C, p *Producer, data []byte) {
+	msp := p.NewSegmentProxy()
+	defer msp.Close()
+	if len(data) > 0 {
+		c.Assert(msp.WriteMemory(data), IsNil)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.Assert(p.Send(ctx, msp), IsNil)
+	c.Assert(msp.GetSegmentCount(), Equals, 0)
+}
+
+func receive(c *C, consumer *Consumer) memory.MemorySegmentProxyer {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msp, err := consumer.Receive(ctx)
+	c.Assert(err, IsNil)
+	return msp
+}
+
+func (s *SHMSuite) Test_SendReceive(c *C) {
+	p, consumer := pair(c, Options{SegmentSize: 256, SegmentCount: 64, RingSize: 32})
+	defer p.Close()
+	defer consumer.Close()
+
+	for i := 0; i < 10; i++ {
+		send(c, p, message(i))
+	}
+	segments := 0
+	for i := 0; i < 10; i++ {
+		msp := receive(c, consumer)
+		data, err := io.ReadAll(msp.NewReader())
+		c.Assert(err, IsNil)
+		c.Assert(data, DeepEquals, message(i))
+		segments += msp.GetSegmentCount()
+		//the consumer reads the segments written by the producer.
+		for _, buf := range msp.Buffers() {
+			address := uintptr(unsafe.Pointer(&buf[0]))
+			start := uintptr(unsafe.Pointer(&consumer.data[0]))
+			c.Assert(address >= start+uintptr(consumer.layout.pool) && address < start+uintptr(len(consumer.data)), Equals, true)
+		}
+		msp.Close()
+	}
+	c.Assert(p.Reclaim(), Equals, segments)
+	c.Assert(p.Reclaim(), Equals, 0)
+
+	//every segment goes back to the pool.
+	msp := p.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 64*256)), IsNil)
+	_, err := p.mp.GetOneAvailable()
+	c.Assert(err, NotNil)
+	msp.Close()
+}
+
+func (s *SHMSuite) Test_Wait(c *C) {
+	p, consumer := pair(c, Options{SegmentSize: 64, SegmentCount: 64, RingSize: 4})
+	defer p.Close()
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := consumer.Receive(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	//a consumer sleeping on an empty ring is woken up.
+	received := make(chan memory.MemorySegmentProxyer)
+	go func() {
+		msp, err := consumer.Receive(context.Background())
+		c.Check(err, IsNil)
+		received <- msp
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sent := time.Now()
+	send(c, p, []byte("wake up"))
+	msp := <-received
+	c.Assert(time.Since(sent) < pollInterval, Equals, true)
+	c.Assert(string(msp.Buffers()[0]), Equals, "wake up")
+	msp.Close()
+
+	//a producer waits for room in the ring.
+	send(c, p, make([]byte, 64*3))
+	msp = p.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 64*2)), IsNil)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	c.Assert(p.Send(ctx, msp), Equals, context.DeadlineExceeded)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		receive(c, consumer).Close()
+	}()
+	c.Assert(p.Send(context.Background(), msp), IsNil)
+	msp.Close()
+	receive(c, consumer).Close()
+
+	msp = p.NewSegmentProxy()
+	c.Assert(msp.WriteMemory(make([]byte, 64*5)), IsNil)
+	c.Assert(p.Send(context.Background(), msp), Equals, ErrMessageTooLarge)
+	msp.Close()
+	send(c, p, nil)
+	msp = receive(c, consumer)
+	c.Assert(msp.GetLength(), Equals, 0)
+	msp.Close()
+}
+
+func (s *SHMSuite) Test_Close(c *C) {
+	p, consumer := pair(c, Options{})
+	waiting := make(chan error)
+	go func() {
+		_, err := consumer.Receive(context.Background())
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Assert(consumer.Close(), IsNil)
+	c.Assert(<-waiting, Equals, ErrClosed)
+	c.Assert(consumer.Close(), IsNil)
+
+	//the region stays mapped while a proxy borrows from it, not for the messages sent.
+	send(c, p, []byte("lost"))
+	msp := p.NewSegmentProxy()
+	c.Assert(msp.WriteMemory([]byte("in use")), IsNil)
+	c.Assert(p.Close(), Equals, memory.ErrPoolInUse)
+	c.Assert(string(msp.Buffers()[0]), Equals, "in use")
+	msp.Close()
+	c.Assert(p.Close(), IsNil)
+	c.Assert(p.Close(), IsNil)
+	c.Assert(p.Send(context.Background(), &memory.MemorySegmentProxy{}), Equals, ErrClosed)
+
+	f, err := NewMemfd("gomsg-test")
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = Open(f)
+	c.Assert(err, Equals, ErrInvalidRegion)
+	c.Assert(f.Truncate(4096), IsNil)
+	_, err = Open(f)
+	c.Assert(err, Equals, ErrInvalidRegion)
+	_, err = Create(f, Options{RingSize: 3})
+	c.Assert(err, Equals, ErrInvalidOptions)
+}
+
+const childEnv = "GOMSG_SHM_CHILD"
+
+//Test_Process runs the consumer in another process, the memfd is inherited as its fd 3.
+func (s *SHMSuite) Test_Process(c *C) {
+	f, err := NewMemfd("gomsg-test")
+	c.Assert(err, IsNil)
+	defer f.Close()
+	p, err := Create(f, Options{SegmentSize: 512, SegmentCount: 16, RingSize: 8})
+	c.Assert(err, IsNil)
+	defer p.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestChildConsumer$")
+	cmd.Env = append(os.Environ(), childEnv+"=1")
+	cmd.ExtraFiles = []*os.File{f}
+	output := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	c.Assert(cmd.Start(), IsNil)
+	//more data than the pool holds, the segments go round.
+	for i := 0; i < 50; i++ {
+		msp := p.NewSegmentProxy()
+		for i%20 > 0 && msp.WriteMemory(message(i%20)) != nil {
+			msp.Close()
+			time.Sleep(time.Millisecond)
+			msp = p.NewSegmentProxy()
+		}
+		c.Assert(p.Send(context.Background(), msp), IsNil)
+		msp.Close()
+	}
+	c.Assert(cmd.Wait(), IsNil, Commentf("%s", output))
+}
+
+//TestChildConsumer is the consumer process of Test_Process.
+func TestChildConsumer(t *testing.T) {
+	if os.Getenv(childEnv) != "1" {
+		return
+	}
+	consumer, err := Open(os.NewFile(3, "memfd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		msp, err := consumer.Receive(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(msp.NewReader())
+		msp.Close()
+		if !bytes.Equal(data, message(i%20)) {
+			t.Fatal(fmt.Sprintf("message %d: got %d bytes", i, len(data)))
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package thrift
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/gomsg/memory"
+)
+
+const (
+	binaryVersion1    uint32 = 0x80010000
+	binaryVersionMask uint32 = 0xffff0000
+)
+
+//BinaryWriter writes TBinaryProtocol (strict mode) encoded data into a memory segment proxy.
+//Every header is assembled in a scratch buffer owned by the writer, so no allocation happens per field.
+type BinaryWriter struct {
+	w   memory.MemorySegmentProxyer
+	buf [8]byte
+}
+
+func NewBinaryWriter(w memory.MemorySegmentProxyer) *BinaryWriter {
+	return &BinaryWriter{w: w}
+}
+
+func (bw *BinaryWriter) WriteMessageBegin(name string, typeID MessageType, seqID int32) error {
+	binary.BigEndian.PutUint32(bw.buf[:4], binaryVersion1|uint32(typeID))
+	if err := bw.w.WriteMemory(bw.buf[:4]); err != nil {
+		return err
+	}
+	if err := bw.WriteString(name); err != nil {
+		return err
+	}
+	return bw.WriteI32(seqID)
+}
+
+func (bw *BinaryWriter) WriteMessageEnd() error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteStructBegin(name string) error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteStructEnd() error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteFieldBegin(name string, typeID TType, id int16) error {
+	bw.buf[0] = byte(typeID)
+	binary.BigEndian.PutUint16(bw.buf[1:3], uint16(id))
+	return bw.w.WriteMemory(bw.buf[:3])
+}
+
+func (bw *BinaryWriter) WriteFieldEnd() error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteFieldStop() error {
+	return bw.w.WriteByte(byte(STOP))
+}
+
+func (bw *BinaryWriter) WriteMapBegin(keyType TType, valueType TType, size int) error {
+	bw.buf[0] = byte(keyType)
+	bw.buf[1] = byte(valueType)
+	binary.BigEndian.PutUint32(bw.buf[2:6], uint32(size))
+	return bw.w.WriteMemory(bw.buf[:6])
+}
+
+func (bw *BinaryWriter) WriteMapEnd() error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteListBegin(elemType TType, size int) error {
+	bw.buf[0] = byte(elemType)
+	binary.BigEndian.PutUint32(bw.buf[1:5], uint32(size))
+	return bw.w.WriteMemory(bw.buf[:5])
+}
+
+func (bw *BinaryWriter) WriteListEnd() error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteSetBegin(elemType TType, size int) error {
+	return bw.WriteListBegin(elemType, size)
+}
+
+func (bw *BinaryWriter) WriteSetEnd() error {
+	return nil
+}
+
+func (bw *BinaryWriter) WriteBool(value bool) error {
+	if value {
+		return bw.w.WriteByte(1)
+	}
+	return bw.w.WriteByte(0)
+}
+
+func (bw *BinaryWriter) WriteI8(value int8) error {
+	return bw.w.WriteByte(byte(value))
+}
+
+func (bw *BinaryWriter) WriteI16(value int16) error {
+	binary.BigEndian.PutUint16(bw.buf[:2], uint16(value))
+	return bw.w.WriteMemory(bw.buf[:2])
+}
+
+func (bw *BinaryWriter) WriteI32(value int32) error {
+	binary.BigEndian.PutUint32(bw.buf[:4], uint32(value))
+	return bw.w.WriteMemory(bw.buf[:4])
+}
+
+func (bw *BinaryWriter) WriteI64(value int64) error {
+	binary.BigEndian.PutUint64(bw.buf[:8], uint64(value))
+	return bw.w.WriteMemory(bw.buf[:8])
+}
+
+func (bw *BinaryWriter) WriteDouble(value float64) error {
+	return bw.WriteI64(int64(math.Float64bits(value)))
+}
+
+func (bw *BinaryWriter) WriteString(value string) error {
+	if err := bw.WriteI32(int32(len(value))); err != nil {
+		return err
+	}
+	return bw.w.WriteMemory([]byte(value))
+}
+
+func (bw *BinaryWriter) WriteBinary(value []byte) error {
+	if err := bw.WriteI32(int32(len(value))); err != nil {
+		return err
+	}
+	return bw.w.WriteMemory(value)
+}
+
+//BinaryReader reads TBinaryProtocol encoded data, both strict and non-strict message headers are accepted.
+type BinaryReader struct {
+	r         Reader
+	MaxLength int
+	buf       [8]byte
+}
+
+func NewBinaryReader(r Reader) *BinaryReader {
+	return &BinaryReader{r: r, MaxLength: defMaxLength}
+}
+
+func (br *BinaryReader) ReadMessageBegin() (string, MessageType, int32, error) {
+	size, err := br.ReadI32()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	var name string
+	var typeID MessageType
+	if size < 0 {
+		if uint32(size)&binaryVersionMask != binaryVersion1 {
+			return "", 0, 0, ErrBadVersion
+		}
+		typeID = MessageType(uint32(size) & 0xff)
+		if name, err = br.ReadString(); err != nil {
+			return "", 0, 0, err
+		}
+	} else {
+		//old (non-strict) header: name, type, sequence id.
+		n, err := checkLength(size, br.MaxLength)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		if name, err = br.readString(n); err != nil {
+			return "", 0, 0, err
+		}
+		t, err := br.ReadI8()
+		if err != nil {
+			return "", 0, 0, err
+		}
+		typeID = MessageType(t)
+	}
+	seqID, err := br.ReadI32()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return name, typeID, seqID, nil
+}
+
+func (br *BinaryReader) ReadMessageEnd() error {
+	return nil
+}
+
+func (br *BinaryReader) ReadStructBegin() (string, error) {
+	return "", nil
+}
+
+func (br *BinaryReader) ReadStructEnd() error {
+	return nil
+}
+
+func (br *BinaryReader) ReadFieldBegin() (string, TType, int16, error) {
+	t, err := br.ReadI8()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if TType(t) == STOP {
+		return "", STOP, 0, nil
+	}
+	id, err := br.ReadI16()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return "", TType(t), id, nil
+}
+
+func (br *BinaryReader) ReadFieldEnd() error {
+	return nil
+}
+
+func (br *BinaryReader) ReadMapBegin() (TType, TType, int, error) {
+	if err := readFull(br.r, br.buf[:6]); err != nil {
+		return 0, 0, 0, err
+	}
+	size, err := checkLength(int32(binary.BigEndian.Uint32(br.buf[2:6])), br.MaxLength)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return TType(br.buf[0]), TType(br.buf[1]), size, nil
+}
+
+func (br *BinaryReader) ReadMapEnd() error {
+	return nil
+}
+
+func (br *BinaryReader) ReadListBegin() (TType, int, error) {
+	if err := readFull(br.r, br.buf[:5]); err != nil {
+		return 0, 0, err
+	}
+	size, err := checkLength(int32(binary.BigEndian.Uint32(br.buf[1:5])), br.MaxLength)
+	if err != nil {
+		return 0, 0, err
+	}
+	return TType(br.buf[0]), size, nil
+}
+
+func (br *BinaryReader) ReadListEnd() error {
+	return nil
+}
+
+func (br *BinaryReader) ReadSetBegin() (TType, int, error) {
+	return br.ReadListBegin()
+}
+
+func (br *BinaryReader) ReadSetEnd() error {
+	return nil
+}
+
+func (br *BinaryReader) ReadBool() (bool, error) {
+	b, err := br.ReadI8()
+	return b != 0, err
+}
+
+func (br *BinaryReader) ReadI8() (int8, error) {
+	if err := readFull(br.r, br.buf[:1]); err != nil {
+		return 0, err
+	}
+	return int8(br.buf[0]), nil
+}
+
+func (br *BinaryReader) ReadI16() (int16, error) {
+	if err := readFull(br.r, br.buf[:2]); err != nil {
+		return 0, err
+	}
+	return int16(binary.BigEndian.Uint16(br.buf[:2])), nil
+}
+
+func (br *BinaryReader) ReadI32() (int32, error) {
+	if err := readFull(br.r, br.buf[:4]); err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(br.buf[:4])), nil
+}
+
+func (br *BinaryReader) ReadI64() (int64, error) {
+	if err := readFull(br.r, br.buf[:8]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(br.buf[:8])), nil
+}
+
+func (br *BinaryReader) ReadDouble() (float64, error) {
+	v, err := br.ReadI64()
+	return math.Float64frombits(uint64(v)), err
+}
+
+func (br *BinaryReader) ReadString() (string, error) {
+	data, err := br.ReadBinary()
+	return string(data), err
+}
+
+func (br *BinaryReader) ReadBinary() ([]byte, error) {
+	size, err := br.ReadI32()
+	if err != nil {
+		return nil, err
+	}
+	n, err := checkLength(size, br.MaxLength)
+	if err != nil {
+		return nil, err
+	}
+	return readBytes(br.r, n)
+}
+
+func (br *BinaryReader) readString(n int) (string, error) {
+	data, err := readBytes(br.r, n)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
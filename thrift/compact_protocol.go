@@ -0,0 +1,480 @@
+package thrift
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/gomsg/memory"
+)
+
+const (
+	compactProtocolID      byte = 0x82
+	compactVersion         byte = 1
+	compactVersionMask     byte = 0x1f
+	compactTypeMask        byte = 0xe0
+	compactTypeShiftAmount      = 5
+)
+
+//element types of the compact protocol, which differ from TType.
+const (
+	compactBooleanTrue  byte = 0x01
+	compactBooleanFalse byte = 0x02
+	compactByte         byte = 0x03
+	compactI16          byte = 0x04
+	compactI32          byte = 0x05
+	compactI64          byte = 0x06
+	compactDouble       byte = 0x07
+	compactBinary       byte = 0x08
+	compactList         byte = 0x09
+	compactSet          byte = 0x0a
+	compactMap          byte = 0x0b
+	compactStruct       byte = 0x0c
+)
+
+var ttypeToCompactType = [16]byte{
+	STOP:   0,
+	BOOL:   compactBooleanTrue,
+	BYTE:   compactByte,
+	I16:    compactI16,
+	I32:    compactI32,
+	I64:    compactI64,
+	DOUBLE: compactDouble,
+	STRING: compactBinary,
+	LIST:   compactList,
+	SET:    compactSet,
+	MAP:    compactMap,
+	STRUCT: compactStruct,
+}
+
+func compactTypeToTType(t byte) (TType, error) {
+	switch t & 0x0f {
+	case 0:
+		return STOP, nil
+	case compactBooleanTrue, compactBooleanFalse:
+		return BOOL, nil
+	case compactByte:
+		return BYTE, nil
+	case compactI16:
+		return I16, nil
+	case compactI32:
+		return I32, nil
+	case compactI64:
+		return I64, nil
+	case compactDouble:
+		return DOUBLE, nil
+	case compactBinary:
+		return STRING, nil
+	case compactList:
+		return LIST, nil
+	case compactSet:
+		return SET, nil
+	case compactMap:
+		return MAP, nil
+	case compactStruct:
+		return STRUCT, nil
+	}
+	return 0, ErrUnknownCompactType
+}
+
+//CompactWriter writes TCompactProtocol encoded data into a memory segment proxy.
+//Field ids are delta encoded against the previous field of the same struct,
+//the stack of previous field ids keeps its capacity so no allocation happens per field.
+type CompactWriter struct {
+	w           memory.MemorySegmentProxyer
+	lastFieldID int16
+	fieldIDs    []int16
+	//a boolean field header is written together with its value.
+	boolFieldID      int16
+	boolFieldPending bool
+	buf              [binary.MaxVarintLen64 + 1]byte
+}
+
+func NewCompactWriter(w memory.MemorySegmentProxyer) *CompactWriter {
+	return &CompactWriter{w: w, fieldIDs: make([]int16, 0, 16)}
+}
+
+func (cw *CompactWriter) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(cw.buf[:], v)
+	return cw.w.WriteMemory(cw.buf[:n])
+}
+
+func (cw *CompactWriter) WriteMessageBegin(name string, typeID MessageType, seqID int32) error {
+	cw.buf[0] = compactProtocolID
+	cw.buf[1] = compactVersion&compactVersionMask | byte(typeID)<<compactTypeShiftAmount&compactTypeMask
+	n := binary.PutUvarint(cw.buf[2:], uint64(uint32(seqID)))
+	if err := cw.w.WriteMemory(cw.buf[:2+n]); err != nil {
+		return err
+	}
+	return cw.WriteString(name)
+}
+
+func (cw *CompactWriter) WriteMessageEnd() error {
+	return nil
+}
+
+func (cw *CompactWriter) WriteStructBegin(name string) error {
+	cw.fieldIDs = append(cw.fieldIDs, cw.lastFieldID)
+	cw.lastFieldID = 0
+	return nil
+}
+
+func (cw *CompactWriter) WriteStructEnd() error {
+	if len(cw.fieldIDs) > 0 {
+		cw.lastFieldID = cw.fieldIDs[len(cw.fieldIDs)-1]
+		cw.fieldIDs = cw.fieldIDs[:len(cw.fieldIDs)-1]
+	}
+	return nil
+}
+
+func (cw *CompactWriter) WriteFieldBegin(name string, typeID TType, id int16) error {
+	if typeID == BOOL {
+		cw.boolFieldID = id
+		cw.boolFieldPending = true
+		return nil
+	}
+	return cw.writeFieldHeader(ttypeToCompactType[typeID&0x0f], id)
+}
+
+func (cw *CompactWriter) writeFieldHeader(t byte, id int16) error {
+	var err error
+	if delta := int(id) - int(cw.lastFieldID); delta > 0 && delta <= 15 {
+		err = cw.w.WriteByte(byte(delta)<<4 | t)
+	} else {
+		cw.buf[0] = t
+		n := binary.PutUvarint(cw.buf[1:], zigzag64(int64(id)))
+		err = cw.w.WriteMemory(cw.buf[:1+n])
+	}
+	cw.lastFieldID = id
+	return err
+}
+
+func (cw *CompactWriter) WriteFieldEnd() error {
+	return nil
+}
+
+func (cw *CompactWriter) WriteFieldStop() error {
+	return cw.w.WriteByte(byte(STOP))
+}
+
+func (cw *CompactWriter) WriteMapBegin(keyType TType, valueType TType, size int) error {
+	if size == 0 {
+		return cw.w.WriteByte(0)
+	}
+	n := binary.PutUvarint(cw.buf[:], uint64(uint32(size)))
+	cw.buf[n] = ttypeToCompactType[keyType&0x0f]<<4 | ttypeToCompactType[valueType&0x0f]
+	return cw.w.WriteMemory(cw.buf[:n+1])
+}
+
+func (cw *CompactWriter) WriteMapEnd() error {
+	return nil
+}
+
+func (cw *CompactWriter) WriteListBegin(elemType TType, size int) error {
+	t := ttypeToCompactType[elemType&0x0f]
+	if size < 15 {
+		return cw.w.WriteByte(byte(size)<<4 | t)
+	}
+	cw.buf[0] = 0xf0 | t
+	n := binary.PutUvarint(cw.buf[1:], uint64(uint32(size)))
+	return cw.w.WriteMemory(cw.buf[:1+n])
+}
+
+func (cw *CompactWriter) WriteListEnd() error {
+	return nil
+}
+
+func (cw *CompactWriter) WriteSetBegin(elemType TType, size int) error {
+	return cw.WriteListBegin(elemType, size)
+}
+
+func (cw *CompactWriter) WriteSetEnd() error {
+	return nil
+}
+
+func (cw *CompactWriter) WriteBool(value bool) error {
+	t := compactBooleanFalse
+	if value {
+		t = compactBooleanTrue
+	}
+	if cw.boolFieldPending {
+		cw.boolFieldPending = false
+		return cw.writeFieldHeader(t, cw.boolFieldID)
+	}
+	//bool elements of containers take a whole byte.
+	return cw.w.WriteByte(t)
+}
+
+func (cw *CompactWriter) WriteI8(value int8) error {
+	return cw.w.WriteByte(byte(value))
+}
+
+func (cw *CompactWriter) WriteI16(value int16) error {
+	return cw.writeUvarint(zigzag64(int64(value)))
+}
+
+func (cw *CompactWriter) WriteI32(value int32) error {
+	return cw.writeUvarint(zigzag64(int64(value)))
+}
+
+func (cw *CompactWriter) WriteI64(value int64) error {
+	return cw.writeUvarint(zigzag64(value))
+}
+
+func (cw *CompactWriter) WriteDouble(value float64) error {
+	binary.LittleEndian.PutUint64(cw.buf[:8], math.Float64bits(value))
+	return cw.w.WriteMemory(cw.buf[:8])
+}
+
+func (cw *CompactWriter) WriteString(value string) error {
+	if err := cw.writeUvarint(uint64(len(value))); err != nil {
+		return err
+	}
+	return cw.w.WriteMemory([]byte(value))
+}
+
+func (cw *CompactWriter) WriteBinary(value []byte) error {
+	if err := cw.writeUvarint(uint64(len(value))); err != nil {
+		return err
+	}
+	return cw.w.WriteMemory(value)
+}
+
+//CompactReader reads TCompactProtocol encoded data.
+type CompactReader struct {
+	r           Reader
+	MaxLength   int
+	lastFieldID int16
+	fieldIDs    []int16
+	//the value of a boolean field is carried by its header.
+	boolValue        bool
+	boolValuePending bool
+	buf              [8]byte
+}
+
+func NewCompactReader(r Reader) *CompactReader {
+	return &CompactReader{r: r, MaxLength: defMaxLength, fieldIDs: make([]int16, 0, 16)}
+}
+
+func (cr *CompactReader) readByte() (byte, error) {
+	if err := readFull(cr.r, cr.buf[:1]); err != nil {
+		return 0, err
+	}
+	return cr.buf[0], nil
+}
+
+func (cr *CompactReader) readUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(byteReader{cr})
+	if err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+//byteReader converts io.EOF in the middle of a varint into io.ErrUnexpectedEOF.
+type byteReader struct {
+	cr *CompactReader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	return b.cr.readByte()
+}
+
+func (cr *CompactReader) readSize() (int, error) {
+	v, err := cr.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxInt32 {
+		return 0, ErrNegativeSize
+	}
+	return checkLength(int32(v), cr.MaxLength)
+}
+
+func (cr *CompactReader) ReadMessageBegin() (string, MessageType, int32, error) {
+	protocolID, err := cr.readByte()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if protocolID != compactProtocolID {
+		return "", 0, 0, ErrBadVersion
+	}
+	versionAndType, err := cr.readByte()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if versionAndType&compactVersionMask != compactVersion {
+		return "", 0, 0, ErrBadVersion
+	}
+	seqID, err := cr.readUvarint()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	name, err := cr.ReadString()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return name, MessageType(versionAndType >> compactTypeShiftAmount), int32(uint32(seqID)), nil
+}
+
+func (cr *CompactReader) ReadMessageEnd() error {
+	return nil
+}
+
+func (cr *CompactReader) ReadStructBegin() (string, error) {
+	cr.fieldIDs = append(cr.fieldIDs, cr.lastFieldID)
+	cr.lastFieldID = 0
+	return "", nil
+}
+
+func (cr *CompactReader) ReadStructEnd() error {
+	if len(cr.fieldIDs) > 0 {
+		cr.lastFieldID = cr.fieldIDs[len(cr.fieldIDs)-1]
+		cr.fieldIDs = cr.fieldIDs[:len(cr.fieldIDs)-1]
+	}
+	return nil
+}
+
+func (cr *CompactReader) ReadFieldBegin() (string, TType, int16, error) {
+	header, err := cr.readByte()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	typeID, err := compactTypeToTType(header)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if typeID == STOP {
+		return "", STOP, 0, nil
+	}
+	var id int16
+	if delta := int16(header >> 4); delta != 0 {
+		id = cr.lastFieldID + delta
+	} else {
+		v, err := cr.readUvarint()
+		if err != nil {
+			return "", 0, 0, err
+		}
+		id = int16(unzigzag64(v))
+	}
+	if typeID == BOOL {
+		cr.boolValue = header&0x0f == compactBooleanTrue
+		cr.boolValuePending = true
+	}
+	cr.lastFieldID = id
+	return "", typeID, id, nil
+}
+
+func (cr *CompactReader) ReadFieldEnd() error {
+	return nil
+}
+
+func (cr *CompactReader) ReadMapBegin() (TType, TType, int, error) {
+	size, err := cr.readSize()
+	if err != nil || size == 0 {
+		return STOP, STOP, 0, err
+	}
+	types, err := cr.readByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	keyType, err := compactTypeToTType(types >> 4)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	valueType, err := compactTypeToTType(types)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return keyType, valueType, size, nil
+}
+
+func (cr *CompactReader) ReadMapEnd() error {
+	return nil
+}
+
+func (cr *CompactReader) ReadListBegin() (TType, int, error) {
+	header, err := cr.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	elemType, err := compactTypeToTType(header)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := int(header >> 4)
+	if size == 15 {
+		if size, err = cr.readSize(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return elemType, size, nil
+}
+
+func (cr *CompactReader) ReadListEnd() error {
+	return nil
+}
+
+func (cr *CompactReader) ReadSetBegin() (TType, int, error) {
+	return cr.ReadListBegin()
+}
+
+func (cr *CompactReader) ReadSetEnd() error {
+	return nil
+}
+
+func (cr *CompactReader) ReadBool() (bool, error) {
+	if cr.boolValuePending {
+		cr.boolValuePending = false
+		return cr.boolValue, nil
+	}
+	b, err := cr.readByte()
+	return b == compactBooleanTrue, err
+}
+
+func (cr *CompactReader) ReadI8() (int8, error) {
+	b, err := cr.readByte()
+	return int8(b), err
+}
+
+func (cr *CompactReader) ReadI16() (int16, error) {
+	v, err := cr.readUvarint()
+	return int16(unzigzag64(v)), err
+}
+
+func (cr *CompactReader) ReadI32() (int32, error) {
+	v, err := cr.readUvarint()
+	return int32(unzigzag64(v)), err
+}
+
+func (cr *CompactReader) ReadI64() (int64, error) {
+	v, err := cr.readUvarint()
+	return unzigzag64(v), err
+}
+
+func (cr *CompactReader) ReadDouble() (float64, error) {
+	if err := readFull(cr.r, cr.buf[:8]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(cr.buf[:8])), nil
+}
+
+func (cr *CompactReader) ReadString() (string, error) {
+	data, err := cr.ReadBinary()
+	return string(data), err
+}
+
+func (cr *CompactReader) ReadBinary() ([]byte, error) {
+	size, err := cr.readSize()
+	if err != nil {
+		return nil, err
+	}
+	return readBytes(cr.r, size)
+}
+
+func zigzag64(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag64(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
@@ -0,0 +1,252 @@
+//Package thrift implements Apache Thrift compatible binary (TBinaryProtocol) and compact (TCompactProtocol)
+//protocol writers on memory segment proxies, and the matching readers over segmented buffers.
+package thrift
+
+import (
+	"fmt"
+	"io"
+)
+
+//TType is the type id of a field, element, key or value.
+type TType byte
+
+const (
+	STOP   TType = 0
+	VOID   TType = 1
+	BOOL   TType = 2
+	BYTE   TType = 3
+	DOUBLE TType = 4
+	I16    TType = 6
+	I32    TType = 8
+	I64    TType = 10
+	STRING TType = 11
+	STRUCT TType = 12
+	MAP    TType = 13
+	SET    TType = 14
+	LIST   TType = 15
+)
+
+//MessageType is the kind of a message.
+type MessageType byte
+
+const (
+	CALL      MessageType = 1
+	REPLY     MessageType = 2
+	EXCEPTION MessageType = 3
+	ONEWAY    MessageType = 4
+)
+
+var (
+	//max length of a single string, binary, list, set or map by default.
+	defMaxLength = 1024 * 1024 * 16
+	//max depth of nested containers and structs being skipped.
+	maxSkipDepth = 64
+	//max number of bytes allocated ahead of the data, the length read is untrusted.
+	maxPrealloc = 1024
+)
+
+var (
+	ErrBadVersion         = fmt.Errorf("thrift: bad protocol version.")
+	ErrNegativeSize       = fmt.Errorf("thrift: negative size.")
+	ErrMaxLengthExceeded  = fmt.Errorf("thrift: length exceeded max length.")
+	ErrMaxDepthExceeded   = fmt.Errorf("thrift: exceeded max depth while skipping.")
+	ErrInvalidDataLength  = fmt.Errorf("thrift: invalid data length.")
+	ErrUnknownCompactType = fmt.Errorf("thrift: unknown compact type.")
+)
+
+//Reader is the source the protocol readers consume, memory.MemorySegmentReader satisfies it.
+type Reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+//ProtocolWriter writes thrift messages, the method set follows the TProtocol of Apache Thrift,
+//except WriteByte which is named WriteI8 to keep clear of io.ByteWriter.
+type ProtocolWriter interface {
+	WriteMessageBegin(name string, typeID MessageType, seqID int32) error
+	WriteMessageEnd() error
+	WriteStructBegin(name string) error
+	WriteStructEnd() error
+	WriteFieldBegin(name string, typeID TType, id int16) error
+	WriteFieldEnd() error
+	WriteFieldStop() error
+	WriteMapBegin(keyType TType, valueType TType, size int) error
+	WriteMapEnd() error
+	WriteListBegin(elemType TType, size int) error
+	WriteListEnd() error
+	WriteSetBegin(elemType TType, size int) error
+	WriteSetEnd() error
+	WriteBool(value bool) error
+	WriteI8(value int8) error
+	WriteI16(value int16) error
+	WriteI32(value int32) error
+	WriteI64(value int64) error
+	WriteDouble(value float64) error
+	WriteString(value string) error
+	WriteBinary(value []byte) error
+}
+
+//ProtocolReader reads thrift messages, the method set follows the TProtocol of Apache Thrift,
+//except ReadByte which is named ReadI8 to keep clear of io.ByteReader.
+type ProtocolReader interface {
+	ReadMessageBegin() (name string, typeID MessageType, seqID int32, err error)
+	ReadMessageEnd() error
+	ReadStructBegin() (name string, err error)
+	ReadStructEnd() error
+	ReadFieldBegin() (name string, typeID TType, id int16, err error)
+	ReadFieldEnd() error
+	ReadMapBegin() (keyType TType, valueType TType, size int, err error)
+	ReadMapEnd() error
+	ReadListBegin() (elemType TType, size int, err error)
+	ReadListEnd() error
+	ReadSetBegin() (elemType TType, size int, err error)
+	ReadSetEnd() error
+	ReadBool() (bool, error)
+	ReadI8() (int8, error)
+	ReadI16() (int16, error)
+	ReadI32() (int32, error)
+	ReadI64() (int64, error)
+	ReadDouble() (float64, error)
+	ReadString() (string, error)
+	ReadBinary() ([]byte, error)
+}
+
+//Skip reads and discards a value of the given type, it's used for ignoring unknown fields.
+func Skip(r ProtocolReader, typeID TType) error {
+	return skip(r, typeID, 0)
+}
+
+func skip(r ProtocolReader, typeID TType, depth int) error {
+	if depth > maxSkipDepth {
+		return ErrMaxDepthExceeded
+	}
+	var err error
+	switch typeID {
+	case BOOL:
+		_, err = r.ReadBool()
+	case BYTE:
+		_, err = r.ReadI8()
+	case I16:
+		_, err = r.ReadI16()
+	case I32:
+		_, err = r.ReadI32()
+	case I64:
+		_, err = r.ReadI64()
+	case DOUBLE:
+		_, err = r.ReadDouble()
+	case STRING:
+		_, err = r.ReadBinary()
+	case STRUCT:
+		err = skipStruct(r, depth)
+	case MAP:
+		err = skipMap(r, depth)
+	case SET:
+		elemType, size, e := r.ReadSetBegin()
+		if e != nil {
+			return e
+		}
+		if err = skipElements(r, elemType, size, depth); err == nil {
+			err = r.ReadSetEnd()
+		}
+	case LIST:
+		elemType, size, e := r.ReadListBegin()
+		if e != nil {
+			return e
+		}
+		if err = skipElements(r, elemType, size, depth); err == nil {
+			err = r.ReadListEnd()
+		}
+	default:
+		return fmt.Errorf("thrift: unknown type %d.", typeID)
+	}
+	return err
+}
+
+func skipStruct(r ProtocolReader, depth int) error {
+	if _, err := r.ReadStructBegin(); err != nil {
+		return err
+	}
+	for {
+		_, fieldType, _, err := r.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if fieldType == STOP {
+			return r.ReadStructEnd()
+		}
+		if err := skip(r, fieldType, depth+1); err != nil {
+			return err
+		}
+		if err := r.ReadFieldEnd(); err != nil {
+			return err
+		}
+	}
+}
+
+func skipMap(r ProtocolReader, depth int) error {
+	keyType, valueType, size, err := r.ReadMapBegin()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		if err := skip(r, keyType, depth+1); err != nil {
+			return err
+		}
+		if err := skip(r, valueType, depth+1); err != nil {
+			return err
+		}
+	}
+	return r.ReadMapEnd()
+}
+
+func skipElements(r ProtocolReader, elemType TType, size int, depth int) error {
+	for i := 0; i < size; i++ {
+		if err := skip(r, elemType, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkLength(size int32, max int) (int, error) {
+	if size < 0 {
+		return 0, ErrNegativeSize
+	}
+	if int(size) > max {
+		return 0, ErrMaxLengthExceeded
+	}
+	return int(size), nil
+}
+
+//readBytes reads n bytes, growing the slice only as they come in.
+func readBytes(r Reader, n int) ([]byte, error) {
+	size := n
+	if size > maxPrealloc {
+		size = maxPrealloc
+	}
+	data := make([]byte, 0, size)
+	for len(data) < n {
+		if len(data) == cap(data) {
+			data = append(data, 0)[:len(data)]
+		}
+		end := cap(data)
+		if end > n {
+			end = n
+		}
+		if err := readFull(r, data[len(data):end]); err != nil {
+			return nil, err
+		}
+		data = data[:end]
+	}
+	return data, nil
+}
+
+func readFull(r Reader, buf []byte) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package thrift
+
+import (
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type Thrift struct{}
+
+var _ = Suite(&Thrift{})
+
+const (
+	binaryGolden = "80010001" + "00000004" + "70696e67" + "00000001" +
+		"080001" + "0000002a" +
+		"0b0002" + "00000002" + "6869" +
+		"020003" + "01" +
+		"0f0004" + "06" + "00000002" + "0001" + "ffff" +
+		"0d0005" + "0b0a" + "00000001" + "00000001" + "6b" + "0000000000000007" +
+		"040006" + "3ff0000000000000" +
+		"0c0064" + "020001" + "00" + "00" +
+		"00"
+	compactGolden = "82" + "21" + "01" + "04" + "70696e67" +
+		"15" + "54" +
+		"18" + "02" + "6869" +
+		"11" +
+		"19" + "24" + "0201" +
+		"1b" + "01" + "86" + "016b" + "0e" +
+		"17" + "000000000000f03f" +
+		"0c" + "c801" + "12" + "00" +
+		"00"
+)
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(64*1024, 16)
+	return mp
+}
+
+func writeSample(c *C, w ProtocolWriter) {
+	c.Assert(w.WriteMessageBegin("ping", CALL, 1), IsNil)
+	c.Assert(w.WriteStructBegin("Ping"), IsNil)
+	c.Assert(w.WriteFieldBegin("i", I32, 1), IsNil)
+	c.Assert(w.WriteI32(42), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldBegin("s", STRING, 2), IsNil)
+	c.Assert(w.WriteString("hi"), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldBegin("b", BOOL, 3), IsNil)
+	c.Assert(w.WriteBool(true), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldBegin("l", LIST, 4), IsNil)
+	c.Assert(w.WriteListBegin(I16, 2), IsNil)
+	c.Assert(w.WriteI16(1), IsNil)
+	c.Assert(w.WriteI16(-1), IsNil)
+	c.Assert(w.WriteListEnd(), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldBegin("m", MAP, 5), IsNil)
+	c.Assert(w.WriteMapBegin(STRING, I64, 1), IsNil)
+	c.Assert(w.WriteString("k"), IsNil)
+	c.Assert(w.WriteI64(7), IsNil)
+	c.Assert(w.WriteMapEnd(), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldBegin("d", DOUBLE, 6), IsNil)
+	c.Assert(w.WriteDouble(1.0), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldBegin("nested", STRUCT, 100), IsNil)
+	c.Assert(w.WriteStructBegin("Nested"), IsNil)
+	c.Assert(w.WriteFieldBegin("f", BOOL, 1), IsNil)
+	c.Assert(w.WriteBool(false), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldStop(), IsNil)
+	c.Assert(w.WriteStructEnd(), IsNil)
+	c.Assert(w.WriteFieldEnd(), IsNil)
+	c.Assert(w.WriteFieldStop(), IsNil)
+	c.Assert(w.WriteStructEnd(), IsNil)
+	c.Assert(w.WriteMessageEnd(), IsNil)
+}
+
+func readSample(c *C, r ProtocolReader) {
+	name, typeID, seqID, err := r.ReadMessageBegin()
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "ping")
+	c.Assert(typeID, Equals, CALL)
+	c.Assert(seqID, Equals, int32(1))
+	_, err = r.ReadStructBegin()
+	c.Assert(err, IsNil)
+	for {
+		_, fieldType, id, err := r.ReadFieldBegin()
+		c.Assert(err, IsNil)
+		if fieldType == STOP {
+			break
+		}
+		switch id {
+		case 1:
+			c.Assert(fieldType, Equals, I32)
+			v, err := r.ReadI32()
+			c.Assert(err, IsNil)
+			c.Assert(v, Equals, int32(42))
+		case 2:
+			v, err := r.ReadString()
+			c.Assert(err, IsNil)
+			c.Assert(v, Equals, "hi")
+		case 3:
+			v, err := r.ReadBool()
+			c.Assert(err, IsNil)
+			c.Assert(v, Equals, true)
+		case 4:
+			elemType, size, err := r.ReadListBegin()
+			c.Assert(err, IsNil)
+			c.Assert(elemType, Equals, I16)
+			c.Assert(size, Equals, 2)
+			v1, _ := r.ReadI16()
+			v2, _ := r.ReadI16()
+			c.Assert([]int16{v1, v2}, DeepEquals, []int16{1, -1})
+			c.Assert(r.ReadListEnd(), IsNil)
+		case 5:
+			keyType, valueType, size, err := r.ReadMapBegin()
+			c.Assert(err, IsNil)
+			c.Assert(keyType, Equals, STRING)
+			c.Assert(valueType, Equals, I64)
+			c.Assert(size, Equals, 1)
+			k, _ := r.ReadString()
+			v, err := r.ReadI64()
+			c.Assert(err, IsNil)
+			c.Assert(k, Equals, "k")
+			c.Assert(v, Equals, int64(7))
+			c.Assert(r.ReadMapEnd(), IsNil)
+		case 6:
+			v, err := r.ReadDouble()
+			c.Assert(err, IsNil)
+			c.Assert(v, Equals, 1.0)
+		default:
+			//unknown to this reader.
+			c.Assert(id, Equals, int16(100))
+			c.Assert(Skip(r, fieldType), IsNil)
+		}
+		c.Assert(r.ReadFieldEnd(), IsNil)
+	}
+	c.Assert(r.ReadStructEnd(), IsNil)
+	c.Assert(r.ReadMessageEnd(), IsNil)
+}
+
+func (t *Thrift) Test_BinaryProtocol_Golden(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	writeSample(c, NewBinaryWriter(msp))
+	readSample(c, NewBinaryReader(msp.NewReader()))
+	c.Assert(hex.EncodeToString(msp.GetBuffer()), Equals, binaryGolden)
+}
+
+func (t *Thrift) Test_CompactProtocol_Golden(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	writeSample(c, NewCompactWriter(msp))
+	readSample(c, NewCompactReader(msp.NewReader()))
+	c.Assert(hex.EncodeToString(msp.GetBuffer()), Equals, compactGolden)
+}
+
+func (t *Thrift) Test_CompactProtocol_LongList(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	w := NewCompactWriter(msp)
+	c.Assert(w.WriteListBegin(BYTE, 20), IsNil)
+	for i := 0; i < 20; i++ {
+		c.Assert(w.WriteI8(int8(i)), IsNil)
+	}
+	r := NewCompactReader(msp.NewReader())
+	elemType, size, err := r.ReadListBegin()
+	c.Assert(err, IsNil)
+	c.Assert(elemType, Equals, BYTE)
+	c.Assert(size, Equals, 20)
+	c.Assert(hex.EncodeToString(msp.GetBuffer()[:2]), Equals, "f314")
+}
+
+func (t *Thrift) Test_BinaryReader_Limits(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	c.Assert(msp.WriteMemory([]byte{0x7f, 0xff, 0xff, 0xff}), IsNil)
+	r := NewBinaryReader(msp.NewReader())
+	_, err := r.ReadBinary()
+	c.Assert(err, Equals, ErrMaxLengthExceeded)
+
+	msp2 := mp.NewSegmentProxy()
+	defer msp2.Close()
+	c.Assert(msp2.WriteMemory([]byte{0x80, 0x02, 0x00, 0x01}), IsNil)
+	_, _, _, err = NewBinaryReader(msp2.NewReader()).ReadMessageBegin()
+	c.Assert(err, Equals, ErrBadVersion)
+}
+
+func (t *Thrift) Test_LongBinary(c *C) {
+	mp := newProvider()
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	protocols := []struct {
+		writer    func(w memory.MemorySegmentProxyer) ProtocolWriter
+		reader    func(r Reader) ProtocolReader
+		truncated string
+	}{
+		{func(w memory.MemorySegmentProxyer) ProtocolWriter { return NewBinaryWriter(w) }, func(r Reader) ProtocolReader { return NewBinaryReader(r) }, "00ffffff01"},
+		{func(w memory.MemorySegmentProxyer) ProtocolWriter { return NewCompactWriter(w) }, func(r Reader) ProtocolReader { return NewCompactReader(r) }, "ffffff0701"},
+	}
+	for _, p := range protocols {
+		msp := mp.NewSegmentProxy()
+		defer msp.Close()
+		c.Assert(p.writer(msp).WriteBinary(data), IsNil)
+		read, err := p.reader(msp.NewReader()).ReadBinary()
+		c.Assert(err, IsNil)
+		c.Assert(read, DeepEquals, data)
+
+		//a length up to MaxLength claimed by a few bytes fails once the data runs out, without allocating it ahead.
+		truncated, _ := hex.DecodeString(p.truncated)
+		msp = mp.NewSegmentProxy()
+		defer msp.Close()
+		c.Assert(msp.WriteMemory(truncated), IsNil)
+		_, err = p.reader(msp.NewReader()).ReadBinary()
+		c.Assert(err, Equals, io.ErrUnexpectedEOF, Commentf(p.truncated))
+	}
+}
+
+func (t *Thrift) Test_FieldHeaders_NoAllocation(c *C) {
+	mp := newProvider()
+	msp := mp.NewSegmentProxy()
+	defer msp.Close()
+	bw := NewBinaryWriter(msp)
+	cw := NewCompactWriter(msp)
+	allocs := testing.AllocsPerRun(100, func() {
+		bw.WriteFieldBegin("f", I32, 1)
+		bw.WriteI32(1)
+		cw.WriteStructBegin("s")
+		cw.WriteFieldBegin("f", I64, 2)
+		cw.WriteI64(-1)
+		cw.WriteFieldBegin("b", BOOL, 3)
+		cw.WriteBool(true)
+		cw.WriteFieldStop()
+		cw.WriteStructEnd()
+	})
+	c.Assert(allocs, Equals, 0.0)
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gomsg/memory"
+	log "github.com/sirupsen/logrus"
+)
+
+//Client is a framed connection to a Server, frames sent back by the server are dispatched to the handlers of its Mux.
+type Client struct {
+	*Conn
+	*Mux
+	wg sync.WaitGroup
+}
+
+//Dial connects to the TCP address, incoming bodies are read into segments borrowed from mp.
+func Dial(addr string, mp *memory.MemoryProvider) (*Client, error) {
+	return dialNetwork("tcp", addr, mp)
+}
+
+//DialUnix connects to the Unix domain socket at path, incoming bodies are read into segments borrowed from mp.
+func DialUnix(path string, mp *memory.MemoryProvider) (*Client, error) {
+	return dialNetwork("unix", path, mp)
+}
+
+func dialNetwork(network, addr string, mp *memory.MemoryProvider) (*Client, error) {
+	nc, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cl := &Client{Conn: newConn(nc, mp, 0), Mux: NewMux()}
+	cl.wg.Add(1)
+	go func() {
+		defer cl.wg.Done()
+		if err := cl.serve(cl.Mux); !isClosedErr(err) {
+			select {
+			case <-cl.done:
+			default:
+				log.Warnf("Closing connection to %s: %v", cl.RemoteAddr(), err)
+			}
+		}
+		cl.Conn.Close()
+	}()
+	return cl, nil
+}
+
+//Close closes the connection and waits for the frame being served.
+func (cl *Client) Close() error {
+	err := cl.Conn.Close()
+	cl.wg.Wait()
+	return err
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	log "github.com/sirupsen/logrus"
+)
+
+//Conn is a framed connection, Send is safe for concurrent use.
+type Conn struct {
+	conn      net.Conn
+	reader    *frame.FrameReader
+	files     *fileReceiver
+	writeLock sync.Mutex
+	header    [frame.MaxHeaderSize]byte
+	closeOnce sync.Once
+	done      chan struct{}
+	//pause, if set, holds reads back while its pool is under pressure.
+	pause     *memory.MemoryProvider
+	telemetry atomic.Pointer[Telemetry]
+}
+
+func newConn(conn net.Conn, mp *memory.MemoryProvider, maxBodySize uint32) *Conn {
+	c := &Conn{conn: conn, done: make(chan struct{})}
+	var r io.Reader = conn
+	if uc, ok := conn.(*net.UnixConn); ok {
+		if c.files = newFileReceiver(uc); c.files != nil {
+			r = c.files
+		}
+	}
+	c.reader = frame.NewFrameReader(r, mp)
+	if maxBodySize > 0 {
+		c.reader.MaxBodySize = maxBodySize
+	}
+	return c
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+//SetTelemetry sets the telemetry of the connection, nil restores the default one using the global providers of otel.
+//The connections of a Server get its Telemetry.
+func (c *Conn) SetTelemetry(t *Telemetry) {
+	c.telemetry.Store(t)
+}
+
+//Telemetry returns the telemetry of the connection.
+func (c *Conn) Telemetry() *Telemetry {
+	if t := c.telemetry.Load(); t != nil {
+		return t.init()
+	}
+	return defaultTelemetry.init()
+}
+
+//Send writes a frame whose body is body, the header and the segments of the body go out in a single vectored write.
+//The body is left untouched, the caller still owns it. FLAG_FILES is cleared, see SendFiles.
+func (c *Conn) Send(messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer) error {
+	return c.SendContext(context.Background(), messageType, correlationID, flags, body)
+}
+
+//SendContext is Send within a span child of the one of ctx, the frame carries the context of the span.
+func (c *Conn) SendContext(ctx context.Context, messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer) error {
+	t := c.Telemetry()
+	f := frame.Frame{MessageType: messageType, CorrelationID: correlationID, Flags: flags &^ (frame.FLAG_FILES | frame.FLAG_TRACE), Body: body}
+	ctx, span := t.startSend(ctx, &f)
+	start := time.Now()
+	err := c.send(&f)
+	t.endSend(ctx, span, &f, start, err)
+	return err
+}
+
+func (c *Conn) send(f *frame.Frame) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	header := c.header[:f.HeaderLength()]
+	if err := f.EncodeHeader(header); err != nil {
+		return err
+	}
+	body := f.Body
+	buffers := net.Buffers{header}
+	if body != nil {
+		buffers = append(buffers, body.Buffers()...)
+	}
+	if _, err := buffers.WriteTo(c.conn); err != nil {
+		return c.writeFailed(err)
+	}
+	return nil
+}
+
+//writeFailed closes the connection after a failed write: part of the frame may have gone out,
+//the peer couldn't find the next frame anymore. The following sends fail with ErrConnClosed.
+func (c *Conn) writeFailed(err error) error {
+	select {
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+	log.Warnf("Closing connection to %s after a failed write: %v", c.RemoteAddr(), err)
+	c.Close()
+	return err
+}
+
+//Close closes the connection, a frame being sent or served is interrupted.
+func (c *Conn) Close() error {
+	err := ErrConnClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+		c.files.close()
+	})
+	return err
+}
+
+//Done is closed once the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+//serve reads frames and hands them over to h until the connection fails.
+//A frame whose checksum doesn't match is dropped, the stream is still in sync after it.
+func (c *Conn) serve(h Handler) error {
+	for {
+		if c.pause != nil {
+			select {
+			case <-c.pause.Recovered():
+			case <-c.done:
+				return net.ErrClosed
+			}
+		}
+		f, err := c.reader.ReadFrame()
+		if err != nil {
+			var mismatch *frame.ChecksumMismatchError
+			if errors.As(err, &mismatch) {
+				log.Warnf("Dropping frame from %s: %v", c.RemoteAddr(), err)
+				continue
+			}
+			return err
+		}
+		if f.Flags&frame.FLAG_FILES != 0 {
+			if f.Files = c.files.take(); f.Files == nil {
+				log.Warnf("Dropping frame from %s: %v", c.RemoteAddr(), ErrMissingFiles)
+				f.Close()
+				continue
+			}
+		}
+		span := c.Telemetry().startReceive(f)
+		h.ServeFrame(c, f)
+		span.End()
+	}
+}
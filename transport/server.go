@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gomsg/memory"
+	log "github.com/sirupsen/logrus"
+)
+
+//Server accepts framed connections and dispatches their frames to the handlers of its Mux.
+type Server struct {
+	*Mux
+	mp *memory.MemoryProvider
+	//max body size of an incoming frame, 0 means the default of frame.FrameReader.
+	MaxBodySize uint32
+	//CheckPeer accepts or rejects the peer of a Unix domain socket connection, nil accepts every peer.
+	CheckPeer func(cred *PeerCredentials) error
+	//PauseOnPressure stops reading from the connections while the pool is under pressure,
+	//see memory.MemoryProvider.SetWatermarks, leaving the peers blocked on a full socket.
+	PauseOnPressure bool
+	//Telemetry of the connections, nil uses the global providers of otel. It SHOULD be set before Listen.
+	Telemetry *Telemetry
+	listener  net.Listener
+	conns     map[*Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+}
+
+//NewServer returns a server reading the incoming bodies into segments borrowed from mp.
+func NewServer(mp *memory.MemoryProvider) *Server {
+	return &Server{Mux: NewMux(), mp: mp, conns: make(map[*Conn]struct{})}
+}
+
+//Listen listens on the TCP address and serves the accepted connections in the background.
+func (s *Server) Listen(addr string) error {
+	return s.listen("tcp", addr)
+}
+
+//ListenUnix listens on the Unix domain socket at path and serves the accepted connections in the background.
+//The socket file is removed by Shutdown.
+func (s *Server) ListenUnix(path string) error {
+	return s.listen("unix", path)
+}
+
+func (s *Server) listen(network, addr string) error {
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.wg.Add(1)
+	s.lock.Unlock()
+	go func() {
+		defer s.wg.Done()
+		s.serve(l)
+	}()
+	return nil
+}
+
+//Addr returns the address being listened on, nil before Listen.
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) serve(l net.Listener) {
+	var delay time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				//e.g. too many open files, back off for a while.
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay < time.Second {
+					delay *= 2
+				}
+				log.Warnf("Accept error: %v, retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			log.Errorf("Accept error: %v", err)
+			return
+		}
+		delay = 0
+		c := newConn(nc, s.mp, s.MaxBodySize)
+		if s.PauseOnPressure {
+			c.pause = s.mp
+		}
+		if s.Telemetry != nil {
+			c.SetTelemetry(s.Telemetry)
+		}
+		if err := s.checkPeer(c); err != nil {
+			log.Warnf("Rejecting connection from %s: %v", c.RemoteAddr(), err)
+			c.Close()
+			continue
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+func (s *Server) serveConn(c *Conn) {
+	defer s.wg.Done()
+	err := c.serve(s.Mux)
+	s.lock.Lock()
+	delete(s.conns, c)
+	closed := s.closed
+	s.lock.Unlock()
+	if !closed && !isClosedErr(err) {
+		log.Warnf("Closing connection from %s: %v", c.RemoteAddr(), err)
+	}
+	c.Close()
+}
+
+//Shutdown stops accepting connections and reading frames, waits for the frames being served,
+//then closes the connections. If ctx expires first, the remaining connections are closed right away.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for c := range s.conns {
+		//interrupts the read in progress, a handler being run completes first.
+		c.conn.SetReadDeadline(time.Now())
+	}
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+//checkPeer runs CheckPeer for a Unix domain socket connection.
+func (s *Server) checkPeer(c *Conn) error {
+	if s.CheckPeer == nil {
+		return nil
+	}
+	if _, ok := c.conn.(*net.UnixConn); !ok {
+		return nil
+	}
+	cred, err := c.PeerCredentials()
+	if err != nil {
+		return err
+	}
+	return s.CheckPeer(cred)
+}
+
+//isClosedErr reports whether err is the normal end of a connection, closed by either side.
+func isClosedErr(err error) bool {
+	return err == io.EOF || errors.Is(err, net.ErrClosed)
+}
@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gomsg/frame"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//instrumentationName is the name of the tracer and of the meter of the package.
+const instrumentationName = "github.com/gomsg/transport"
+
+//Telemetry instruments connections with OpenTelemetry, nil providers fall back to the global ones of otel.
+//
+//Every frame is sent within a gomsg.send span, whose context goes along with the frame (see frame.FLAG_TRACE),
+//and served within a gomsg.receive span, child of the span it was sent from. FrameContext links the spans
+//of a handler to it as well.
+//
+//Metrics, by message type:
+//
+//	gomsg.transport.frames.sent     counter, frames sent
+//	gomsg.transport.frames.received counter, frames received
+//	gomsg.transport.bytes.sent      counter, bytes of the bodies sent
+//	gomsg.transport.bytes.received  counter, bytes of the bodies received
+//	gomsg.transport.send.duration   histogram, seconds a send took, waiting for the connection included
+type Telemetry struct {
+	TracerProvider trace.TracerProvider
+	MeterProvider  metric.MeterProvider
+
+	once           sync.Once
+	tracer         trace.Tracer
+	framesSent     metric.Int64Counter
+	framesReceived metric.Int64Counter
+	bytesSent      metric.Int64Counter
+	bytesReceived  metric.Int64Counter
+	sendDuration   metric.Float64Histogram
+}
+
+//defaultTelemetry instruments the connections through the global providers of otel.
+var defaultTelemetry = &Telemetry{}
+
+//Tracer returns a tracer of TracerProvider, or of the global one.
+func (t *Telemetry) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	if t.TracerProvider == nil {
+		return otel.GetTracerProvider().Tracer(name, opts...)
+	}
+	return t.TracerProvider.Tracer(name, opts...)
+}
+
+//Meter returns a meter of MeterProvider, or of the global one.
+func (t *Telemetry) Meter(name string, opts ...metric.MeterOption) metric.Meter {
+	if t.MeterProvider == nil {
+		return otel.GetMeterProvider().Meter(name, opts...)
+	}
+	return t.MeterProvider.Meter(name, opts...)
+}
+
+//init creates the instruments once, an instrument which can't be created is replaced by a no-op one.
+func (t *Telemetry) init() *Telemetry {
+	t.once.Do(func() {
+		t.tracer = t.Tracer(instrumentationName)
+		meter := t.Meter(instrumentationName)
+		t.framesSent = int64Counter(meter, "gomsg.transport.frames.sent", "{frame}", "Frames sent.")
+		t.framesReceived = int64Counter(meter, "gomsg.transport.frames.received", "{frame}", "Frames received.")
+		t.bytesSent = int64Counter(meter, "gomsg.transport.bytes.sent", "By", "Bytes of the bodies sent.")
+		t.bytesReceived = int64Counter(meter, "gomsg.transport.bytes.received", "By", "Bytes of the bodies received.")
+		var err error
+		if t.sendDuration, err = meter.Float64Histogram("gomsg.transport.send.duration", metric.WithUnit("s"),
+			metric.WithDescription("Time a send took, waiting for the connection included.")); err != nil {
+			log.Warnf("Failed to create gomsg.transport.send.duration: %v", err)
+			t.sendDuration, _ = noop.Meter{}.Float64Histogram("")
+		}
+	})
+	return t
+}
+
+func int64Counter(meter metric.Meter, name, unit, description string) metric.Int64Counter {
+	counter, err := meter.Int64Counter(name, metric.WithUnit(unit), metric.WithDescription(description))
+	if err != nil {
+		log.Warnf("Failed to create %s: %v", name, err)
+		counter, _ = noop.Meter{}.Int64Counter(name)
+	}
+	return counter
+}
+
+//startSend starts the span of a frame being sent and fills its trace context in.
+func (t *Telemetry) startSend(ctx context.Context, f *frame.Frame) (context.Context, trace.Span) {
+	ctx, span := t.tracer.Start(ctx, "gomsg.send", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(frameAttributes(f)...))
+	if sc := span.SpanContext(); sc.IsValid() {
+		f.Flags |= frame.FLAG_TRACE
+		f.Trace = frame.TraceContext{TraceID: sc.TraceID(), SpanID: sc.SpanID(), Flags: uint8(sc.TraceFlags())}
+	}
+	return ctx, span
+}
+
+//endSend ends the span of a frame sent at start, f holds the length of its body once its header is encoded.
+func (t *Telemetry) endSend(ctx context.Context, span trace.Span, f *frame.Frame, start time.Time, err error) {
+	messageType := metric.WithAttributes(attribute.Int64("gomsg.message_type", int64(f.MessageType)))
+	t.sendDuration.Record(ctx, time.Since(start).Seconds(), messageType)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		t.framesSent.Add(ctx, 1, messageType)
+		t.bytesSent.Add(ctx, int64(f.BodyLength), messageType)
+		span.SetAttributes(attribute.Int64("gomsg.body_size", int64(f.BodyLength)))
+	}
+	span.End()
+}
+
+//startReceive starts the span of a frame being served, child of the span it was sent from.
+func (t *Telemetry) startReceive(f *frame.Frame) trace.Span {
+	ctx, span := t.tracer.Start(FrameContext(context.Background(), f), "gomsg.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(append(frameAttributes(f), attribute.Int64("gomsg.body_size", int64(f.BodyLength)))...))
+	messageType := metric.WithAttributes(attribute.Int64("gomsg.message_type", int64(f.MessageType)))
+	t.framesReceived.Add(ctx, 1, messageType)
+	t.bytesReceived.Add(ctx, int64(f.BodyLength), messageType)
+	return span
+}
+
+func frameAttributes(f *frame.Frame) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("gomsg.message_type", int64(f.MessageType)),
+		attribute.Int64("gomsg.correlation_id", int64(f.CorrelationID))}
+}
+
+//FrameContext returns ctx carrying the trace context of f as a remote parent, so that the spans started
+//by a handler link to the span the frame was sent from. ctx is returned as it is if f has no trace context.
+func FrameContext(ctx context.Context, f *frame.Frame) context.Context {
+	if f.Flags&frame.FLAG_TRACE == 0 || !f.Trace.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    f.Trace.TraceID,
+		SpanID:     f.Trace.SpanID,
+		TraceFlags: trace.TraceFlags(f.Trace.Flags),
+		Remote:     true}))
+}
@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomsg/aead"
+	"github.com/gomsg/frame"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	. "gopkg.in/check.v1"
+)
+
+//waitSpans waits for n spans to be ended.
+func waitSpans(c *C, exporter *tracetest.InMemoryExporter, n int) tracetest.SpanStubs {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.GetSpans()) < n {
+		if time.Now().After(deadline) {
+			c.Fatalf("timed out waiting for %d spans, got %d", n, len(exporter.GetSpans()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return exporter.GetSpans()
+}
+
+func (s *TransportSuite) Test_Telemetry(c *C) {
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	telemetry := &Telemetry{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))}
+	mp := newProvider()
+	server := NewServer(mp)
+	server.Telemetry = telemetry
+	server.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		c.Check(conn.SendContext(FrameContext(context.Background(), f), echoType, f.CorrelationID, f.Flags, f.Body), IsNil)
+	})
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	defer server.Shutdown(context.Background())
+	cl, replies := dial(c, server, mp)
+	defer cl.Close()
+	cl.SetTelemetry(telemetry)
+
+	ctx, root := telemetry.Tracer("test").Start(context.Background(), "root")
+	body := mp.NewSegmentProxy()
+	c.Assert(body.WriteMemory([]byte("traced")), IsNil)
+	c.Assert(cl.SendContext(ctx, echoType, 1, 0, body), IsNil)
+	body.Close()
+	root.End()
+	select {
+	case f := <-replies:
+		c.Assert(f.Flags&frame.FLAG_TRACE, Equals, frame.FLAG_TRACE)
+		c.Assert(trace.TraceID(f.Trace.TraceID), Equals, root.SpanContext().TraceID())
+		f.Close()
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for reply")
+	}
+
+	//root -> client send -> server receive and server send -> client receive.
+	spans := waitSpans(c, exporter, 5)
+	children := map[trace.SpanID][]tracetest.SpanStub{}
+	for _, span := range spans {
+		children[span.Parent.SpanID()] = append(children[span.Parent.SpanID()], span)
+	}
+	c.Assert(children[root.SpanContext().SpanID()], HasLen, 1)
+	clientSend := children[root.SpanContext().SpanID()][0]
+	c.Assert(clientSend.Name, Equals, "gomsg.send")
+	c.Assert(clientSend.SpanKind, Equals, trace.SpanKindProducer)
+	names := map[string]tracetest.SpanStub{}
+	for _, span := range children[clientSend.SpanContext.SpanID()] {
+		c.Assert(span.Parent.IsRemote(), Equals, true)
+		names[span.Name] = span
+	}
+	c.Assert(names, HasLen, 2)
+	serverSend, serverReceive := names["gomsg.send"], names["gomsg.receive"]
+	c.Assert(children[serverSend.SpanContext.SpanID()], HasLen, 1)
+	clientReceive := children[serverSend.SpanContext.SpanID()][0]
+	for _, receive := range []tracetest.SpanStub{serverReceive, clientReceive} {
+		c.Assert(receive.Name, Equals, "gomsg.receive")
+		c.Assert(receive.SpanContext.TraceID(), Equals, root.SpanContext().TraceID())
+		c.Assert(receive.SpanKind, Equals, trace.SpanKindConsumer)
+		c.Assert(receive.Attributes, DeepEquals, []attribute.KeyValue{
+			attribute.Int64("gomsg.message_type", int64(echoType)),
+			attribute.Int64("gomsg.correlation_id", 1),
+			attribute.Int64("gomsg.body_size", int64(len("traced")))})
+	}
+
+	var rm metricdata.ResourceMetrics
+	c.Assert(reader.Collect(context.Background(), &rm), IsNil)
+	sums := map[string]int64{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+			sums[m.Name] = sum.DataPoints[0].Value
+		}
+	}
+	c.Assert(sums, DeepEquals, map[string]int64{
+		"gomsg.transport.frames.sent":     2,
+		"gomsg.transport.frames.received": 2,
+		"gomsg.transport.bytes.sent":      12,
+		"gomsg.transport.bytes.received":  12})
+}
+
+func (s *TransportSuite) Test_Telemetry_Encrypted(c *C) {
+	telemetry := &Telemetry{TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(tracetest.NewInMemoryExporter()))}
+	keyring := aead.NewMemoryKeyring()
+	_, err := keyring.Rotate(aead.AES_256_GCM)
+	c.Assert(err, IsNil)
+	sealer := aead.NewSealer(keyring)
+	mp := newProvider()
+	server := NewServer(mp)
+	opened := make(chan string, 1)
+	server.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		c.Check(f.Flags&frame.FLAG_TRACE, Equals, frame.FLAG_TRACE)
+		c.Check(sealer.OpenFrame(mp, f), IsNil)
+		opened <- string(f.Body.GetBuffer())
+	})
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	defer server.Shutdown(context.Background())
+	cl, _ := dial(c, server, mp)
+	defer cl.Close()
+	cl.SetTelemetry(telemetry)
+
+	//the body is sealed before the transport fills the trace context in.
+	flags := frame.FLAG_ENCRYPTED | frame.FLAG_CHECKSUM_CRC32C
+	header := &frame.Frame{Version: frame.Version, Flags: flags, MessageType: echoType, CorrelationID: 1}
+	plain := mp.NewSegmentProxy()
+	c.Assert(plain.WriteMemory([]byte("sealed")), IsNil)
+	body, err := sealer.SealProxy(mp, plain, header.AssociatedData())
+	c.Assert(err, IsNil)
+	plain.Close()
+	ctx, root := telemetry.Tracer("test").Start(context.Background(), "root")
+	c.Assert(cl.SendContext(ctx, echoType, 1, flags, body), IsNil)
+	body.Close()
+	root.End()
+	select {
+	case data := <-opened:
+		c.Assert(data, Equals, "sealed")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for the frame")
+	}
+}
+
+func (s *TransportSuite) Test_Telemetry_Unsampled(c *C) {
+	mp := newProvider()
+	server := newEchoServer(c, mp)
+	defer server.Shutdown(context.Background())
+	cl, replies := dial(c, server, mp)
+	defer cl.Close()
+
+	//without a tracer provider the spans aren't recorded, no trace context is sent.
+	c.Assert(cl.Send(echoType, 1, frame.FLAG_TRACE, nil), IsNil)
+	select {
+	case f := <-replies:
+		c.Assert(f.Flags&frame.FLAG_TRACE, Equals, uint16(0))
+		c.Assert(FrameContext(context.Background(), f), Equals, context.Background())
+		f.Close()
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for reply")
+	}
+}
@@ -0,0 +1,83 @@
+//Package transport sends and receives gomsg frames over TCP or Unix domain sockets.
+//
+//Outgoing bodies are written from their memory segments with a single vectored write (writev) along with
+//their header, incoming bodies are read into segments borrowed from a shared MemoryProvider.
+//Incoming frames are dispatched to handlers registered by message type.
+//
+//On Linux, frames sent over Unix domain sockets may carry open files (SCM_RIGHTS) and the server may check
+//the credentials of its peers (SO_PEERCRED).
+package transport
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gomsg/frame"
+)
+
+var (
+	ErrServerClosed = fmt.Errorf("transport: server closed.")
+	ErrConnClosed   = fmt.Errorf("transport: connection closed.")
+	ErrNotUnix      = fmt.Errorf("transport: not a Unix domain socket connection.")
+	ErrTooManyFiles = fmt.Errorf("transport: too many files sent along with a frame.")
+	ErrMissingFiles = fmt.Errorf("transport: files of a frame missing.")
+	ErrUnsupported  = fmt.Errorf("transport: unsupported on this platform.")
+)
+
+//MaxFiles is the max number of files sent along with a frame.
+const MaxFiles = 16
+
+//PeerCredentials identifies the process at the other end of a Unix domain socket,
+//as it was when the connection was established.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+//Handler serves incoming frames. The handler owns the frame and MUST close it once done with its body.
+//
+//Frames of a connection are served one at a time by the goroutine reading the connection,
+//a handler which takes long should hand the frame over to another goroutine.
+type Handler interface {
+	ServeFrame(c *Conn, f *frame.Frame)
+}
+
+type HandlerFunc func(c *Conn, f *frame.Frame)
+
+func (h HandlerFunc) ServeFrame(c *Conn, f *frame.Frame) {
+	h(c, f)
+}
+
+//Mux dispatches frames to the handler registered for their message type,
+//frames of an unregistered message type are dropped.
+type Mux struct {
+	handlers map[uint32]Handler
+	sync.RWMutex
+}
+
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[uint32]Handler)}
+}
+
+//Handle registers the handler of a message type, replacing the previous one.
+func (m *Mux) Handle(messageType uint32, h Handler) {
+	m.Lock()
+	defer m.Unlock()
+	m.handlers[messageType] = h
+}
+
+func (m *Mux) HandleFunc(messageType uint32, h func(c *Conn, f *frame.Frame)) {
+	m.Handle(messageType, HandlerFunc(h))
+}
+
+func (m *Mux) ServeFrame(c *Conn, f *frame.Frame) {
+	m.RLock()
+	h := m.handlers[f.MessageType]
+	m.RUnlock()
+	if h == nil {
+		f.Close()
+		return
+	}
+	h.ServeFrame(c, f)
+}
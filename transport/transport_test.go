@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type TransportSuite struct{}
+
+var _ = Suite(&TransportSuite{})
+
+const (
+	echoType  uint32 = 1
+	blockType uint32 = 2
+)
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(4*1024*1024, 1024)
+	return mp
+}
+
+//newEchoServer sends every frame of echoType back with the same correlation id.
+func newEchoServer(c *C, mp *memory.MemoryProvider) *Server {
+	s := NewServer(mp)
+	s.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		c.Check(conn.Send(echoType, f.CorrelationID, f.Flags, f.Body), IsNil)
+	})
+	c.Assert(s.Listen("127.0.0.1:0"), IsNil)
+	return s
+}
+
+func dial(c *C, s *Server, mp *memory.MemoryProvider) (*Client, chan *frame.Frame) {
+	cl, err := Dial(s.Addr().String(), mp)
+	c.Assert(err, IsNil)
+	replies := make(chan *frame.Frame, 16)
+	cl.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		replies <- f
+	})
+	return cl, replies
+}
+
+func (s *TransportSuite) Test_Echo(c *C) {
+	mp := newProvider()
+	server := newEchoServer(c, mp)
+	defer server.Shutdown(context.Background())
+	cl, replies := dial(c, server, mp)
+	defer cl.Close()
+
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	body := mp.NewSegmentProxy()
+	c.Assert(body.WriteMemory(payload), IsNil)
+	c.Assert(body.GetSegmentCount() > 1, Equals, true)
+	for i := uint64(1); i <= 3; i++ {
+		c.Assert(cl.Send(echoType, i, frame.FLAG_CHECKSUM_XXHASH64, body), IsNil)
+	}
+	//an unhandled message type is dropped.
+	c.Assert(cl.Send(99, 0, 0, nil), IsNil)
+	c.Assert(cl.Send(echoType, 4, 0, nil), IsNil)
+	body.Close()
+
+	for i := uint64(1); i <= 4; i++ {
+		select {
+		case f := <-replies:
+			c.Assert(f.CorrelationID, Equals, i)
+			data, err := ioutil.ReadAll(f.Body.NewReader())
+			c.Assert(err, IsNil)
+			if i < 4 {
+				c.Assert(f.Flags&frame.FLAG_CHECKSUM_XXHASH64, Equals, frame.FLAG_CHECKSUM_XXHASH64)
+				c.Assert(bytes.Equal(data, payload), Equals, true)
+			} else {
+				c.Assert(data, HasLen, 0)
+			}
+			f.Close()
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for reply")
+		}
+	}
+}
+
+//partialConn fails every write once limit bytes went out.
+type partialConn struct {
+	net.Conn
+	limit int
+}
+
+func (pc *partialConn) Write(p []byte) (int, error) {
+	if len(p) > pc.limit {
+		n, _ := pc.Conn.Write(p[:pc.limit])
+		pc.limit -= n
+		return n, errors.New("write failed")
+	}
+	n, err := pc.Conn.Write(p)
+	pc.limit -= n
+	return n, err
+}
+
+func (s *TransportSuite) Test_Send_PartialWrite(c *C) {
+	mp := newProvider()
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go io.Copy(io.Discard, remote)
+	conn := newConn(&partialConn{Conn: local, limit: 100}, mp, 0)
+	body := mp.NewSegmentProxy()
+	defer body.Close()
+	c.Assert(body.WriteMemory(make([]byte, 5000)), IsNil)
+
+	//the peer couldn't find the next frame after part of this one, the connection is closed.
+	c.Assert(conn.Send(echoType, 1, 0, body), NotNil)
+	select {
+	case <-conn.Done():
+	default:
+		c.Fatal("connection still open after a partial write")
+	}
+	c.Assert(conn.Send(echoType, 2, 0, nil), Equals, ErrConnClosed)
+}
+
+func (s *TransportSuite) Test_Shutdown(c *C) {
+	mp := newProvider()
+	server := newEchoServer(c, mp)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.HandleFunc(blockType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		close(started)
+		<-release
+		conn.Send(echoType, f.CorrelationID, 0, nil)
+	})
+	cl, replies := dial(c, server, mp)
+	defer cl.Close()
+	c.Assert(cl.Send(blockType, 7, 0, nil), IsNil)
+	<-started
+
+	//the frame being served completes, its reply still goes out.
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		c.Fatalf("shutdown returned before the handler completed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	c.Assert(<-shutdown, IsNil)
+	select {
+	case f := <-replies:
+		c.Assert(f.CorrelationID, Equals, uint64(7))
+		f.Close()
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for reply")
+	}
+	select {
+	case <-cl.Done():
+	case <-time.After(5 * time.Second):
+		c.Fatal("connection not closed by shutdown")
+	}
+	c.Assert(server.Listen("127.0.0.1:0"), Equals, ErrServerClosed)
+	_, err := Dial(server.Addr().String(), mp)
+	c.Assert(err, NotNil)
+}
+
+func (s *TransportSuite) Test_Shutdown_Timeout(c *C) {
+	mp := newProvider()
+	server := newEchoServer(c, mp)
+	started := make(chan struct{})
+	server.HandleFunc(blockType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		close(started)
+		<-conn.Done()
+	})
+	cl, _ := dial(c, server, mp)
+	defer cl.Close()
+	c.Assert(cl.Send(blockType, 1, 0, nil), IsNil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	c.Assert(server.Shutdown(ctx), Equals, context.DeadlineExceeded)
+}
+
+func (s *TransportSuite) Test_PauseOnPressure(c *C) {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(64*1024, 1024)
+	c.Assert(mp.SetWatermarks(memory.Watermarks{Low: 0.25, High: 0.5}), IsNil)
+	server := NewServer(mp)
+	server.PauseOnPressure = true
+	held := make(chan *frame.Frame, 64)
+	server.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		held <- f
+	})
+	c.Assert(server.Listen("127.0.0.1:0"), IsNil)
+	defer server.Shutdown(context.Background())
+	clientMp := newProvider()
+	cl, _ := dial(c, server, clientMp)
+	defer cl.Close()
+
+	body := clientMp.NewSegmentProxy()
+	c.Assert(body.WriteMemory(make([]byte, 1000)), IsNil)
+	defer body.Close()
+	for i := 0; i < 55; i++ {
+		c.Assert(cl.Send(echoType, uint64(i), 0, body), IsNil)
+	}
+	//every frame held takes a segment, less than a quarter of the pool is free after 49 of them.
+	frames := make([]*frame.Frame, 0, 55)
+	for len(frames) < 49 {
+		frames = append(frames, <-held)
+	}
+	c.Assert(mp.UnderPressure(), Equals, true)
+	select {
+	case <-held:
+		c.Fatal("read a frame under pressure")
+	case <-time.After(50 * time.Millisecond):
+	}
+	//freeing a few segments isn't enough to recover.
+	for _, f := range frames[:10] {
+		f.Close()
+	}
+	select {
+	case <-held:
+		c.Fatal("read a frame under pressure")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, f := range frames[10:20] {
+		f.Close()
+	}
+	c.Assert(mp.UnderPressure(), Equals, false)
+	for i := 49; i < 55; i++ {
+		select {
+		case f := <-held:
+			c.Assert(f.CorrelationID, Equals, uint64(i))
+			f.Close()
+		case <-time.After(5 * time.Second):
+			c.Fatal("timed out waiting for frames")
+		}
+	}
+	for _, f := range frames[20:] {
+		f.Close()
+	}
+}
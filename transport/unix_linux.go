@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+//maxPendingFiles is the max number of files received and not taken yet by their frames.
+const maxPendingFiles = 4 * MaxFiles
+
+//fileReceiver reads a Unix domain socket, keeping the files received along the way.
+//
+//The files sent along with a frame are attached to its header, which the kernel never merges with the bytes
+//of another sendmsg carrying files, so every batch received belongs to the next frame flagged FLAG_FILES.
+//A peer sending files with frames not flagged keeps them pending: past maxPendingFiles, the files received
+//are closed and reading fails with ErrTooManyFiles, which closes the connection.
+type fileReceiver struct {
+	conn    *net.UnixConn
+	oob     []byte
+	batches [][]*os.File
+	pending int
+	closed  bool
+	lock    sync.Mutex
+}
+
+func newFileReceiver(conn *net.UnixConn) *fileReceiver {
+	return &fileReceiver{conn: conn, oob: make([]byte, unix.CmsgSpace(MaxFiles*4))}
+}
+
+func (r *fileReceiver) Read(p []byte) (int, error) {
+	n, oobn, flags, _, err := r.conn.ReadMsgUnix(p, r.oob)
+	if oobn > 0 {
+		if rerr := r.receive(r.oob[:oobn]); rerr != nil {
+			err = rerr
+		}
+	}
+	if flags&unix.MSG_CTRUNC != 0 {
+		log.Warnf("Files received from %s were truncated.", r.conn.RemoteAddr())
+	}
+	return n, err
+}
+
+func (r *fileReceiver) receive(oob []byte) error {
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		log.Warnf("Invalid control message from %s: %v", r.conn.RemoteAddr(), err)
+		return nil
+	}
+	var files []*os.File
+	for i := range messages {
+		fds, err := unix.ParseUnixRights(&messages[i])
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "fd"))
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		closeFiles(files)
+		return nil
+	}
+	if r.pending+len(files) > maxPendingFiles {
+		closeFiles(files)
+		return ErrTooManyFiles
+	}
+	r.batches = append(r.batches, files)
+	r.pending += len(files)
+	return nil
+}
+
+//take returns the files of the frame just read.
+func (r *fileReceiver) take() []*os.File {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.batches) == 0 {
+		return nil
+	}
+	files := r.batches[0]
+	r.batches = r.batches[1:]
+	r.pending -= len(files)
+	return files
+}
+
+//close closes the files received and not taken.
+func (r *fileReceiver) close() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	for _, files := range r.batches {
+		closeFiles(files)
+	}
+	r.batches = nil
+	r.pending = 0
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+//SendFiles is Send over a Unix domain socket, the files are sent along with the frame and stay open.
+//The receiver gets duplicates of the files in the Files of the frame.
+//
+//The header goes out first with the files, then the segments of the body in a single vectored write.
+func (c *Conn) SendFiles(messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer, files []*os.File) error {
+	return c.SendFilesContext(context.Background(), messageType, correlationID, flags, body, files)
+}
+
+//SendFilesContext is SendFiles within a span child of the one of ctx, the frame carries the context of the span.
+func (c *Conn) SendFilesContext(ctx context.Context, messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer, files []*os.File) error {
+	if len(files) == 0 {
+		return c.SendContext(ctx, messageType, correlationID, flags, body)
+	}
+	uc, ok := c.conn.(*net.UnixConn)
+	if !ok {
+		return ErrNotUnix
+	}
+	if len(files) > MaxFiles {
+		return ErrTooManyFiles
+	}
+	fds := make([]int, len(files))
+	for i, file := range files {
+		//unlike Fd, Control doesn't switch the file to blocking mode.
+		rc, err := file.SyscallConn()
+		if err != nil {
+			return err
+		}
+		if err := rc.Control(func(fd uintptr) { fds[i] = int(fd) }); err != nil {
+			return err
+		}
+	}
+	defer runtime.KeepAlive(files)
+
+	t := c.Telemetry()
+	f := frame.Frame{MessageType: messageType, CorrelationID: correlationID, Flags: flags&^frame.FLAG_TRACE | frame.FLAG_FILES, Body: body}
+	ctx, span := t.startSend(ctx, &f)
+	start := time.Now()
+	err := c.sendFiles(uc, &f, fds)
+	t.endSend(ctx, span, &f, start, err)
+	return err
+}
+
+func (c *Conn) sendFiles(uc *net.UnixConn, f *frame.Frame, fds []int) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	header := c.header[:f.HeaderLength()]
+	if err := f.EncodeHeader(header); err != nil {
+		return err
+	}
+	n, _, err := uc.WriteMsgUnix(header, unix.UnixRights(fds...), nil)
+	if err == nil && n < len(header) {
+		//the files went out with the first bytes of the header.
+		_, err = uc.Write(header[n:])
+	}
+	if err == nil && f.Body != nil {
+		buffers := net.Buffers(f.Body.Buffers())
+		_, err = buffers.WriteTo(uc)
+	}
+	if err != nil {
+		return c.writeFailed(err)
+	}
+	return nil
+}
+
+//PeerCredentials returns the credentials of the peer of a Unix domain socket connection.
+func (c *Conn) PeerCredentials() (*PeerCredentials, error) {
+	uc, ok := c.conn.(*net.UnixConn)
+	if !ok {
+		return nil, ErrNotUnix
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &PeerCredentials{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
@@ -0,0 +1,273 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gomsg/frame"
+	"github.com/gomsg/memory"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+)
+
+const filesType uint32 = 3
+
+//newUnixServer echoes frames like newEchoServer and replies to a frame of filesType with the contents
+//of the files received along with it. Peers are checked by checkPeer.
+func newUnixServer(c *C, mp *memory.MemoryProvider, path string, checkPeer func(cred *PeerCredentials) error) *Server {
+	s := NewServer(mp)
+	s.CheckPeer = checkPeer
+	s.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		c.Check(conn.Send(echoType, f.CorrelationID, f.Flags, f.Body), IsNil)
+	})
+	s.HandleFunc(filesType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		body := mp.NewSegmentProxy()
+		defer body.Close()
+		for _, file := range f.Files {
+			data, err := ioutil.ReadAll(file)
+			c.Check(err, IsNil)
+			c.Check(body.WriteMemory(data), IsNil)
+		}
+		c.Check(conn.Send(echoType, f.CorrelationID, 0, body), IsNil)
+	})
+	c.Assert(s.ListenUnix(path), IsNil)
+	return s
+}
+
+func receive(c *C, replies chan *frame.Frame) *frame.Frame {
+	select {
+	case f := <-replies:
+		return f
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for reply")
+	}
+	return nil
+}
+
+func (s *TransportSuite) Test_Unix_Files(c *C) {
+	mp := newProvider()
+	path := filepath.Join(c.MkDir(), "gomsg.sock")
+	server := newUnixServer(c, mp, path, nil)
+	cl, err := DialUnix(path, mp)
+	c.Assert(err, IsNil)
+	defer cl.Close()
+	replies := make(chan *frame.Frame, 16)
+	cl.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		replies <- f
+	})
+
+	var files []*os.File
+	for i := 0; i < 3; i++ {
+		file, err := os.Create(filepath.Join(c.MkDir(), "file"))
+		c.Assert(err, IsNil)
+		defer file.Close()
+		_, err = fmt.Fprintf(file, "file %d;", i)
+		c.Assert(err, IsNil)
+		_, err = file.Seek(0, 0)
+		c.Assert(err, IsNil)
+		files = append(files, file)
+	}
+	body := mp.NewSegmentProxy()
+	defer body.Close()
+	c.Assert(body.WriteMemory(make([]byte, 5000)), IsNil)
+
+	//frames with and without files are interleaved.
+	c.Assert(cl.SendFiles(filesType, 1, 0, body, files[:1]), IsNil)
+	c.Assert(cl.Send(echoType, 2, 0, body), IsNil)
+	c.Assert(cl.SendFiles(filesType, 3, frame.FLAG_CHECKSUM_CRC32C, nil, files[1:]), IsNil)
+	c.Assert(cl.SendFiles(echoType, 4, 0, body, nil), IsNil)
+	c.Assert(cl.SendFiles(filesType, 5, 0, nil, make([]*os.File, MaxFiles+1)), Equals, ErrTooManyFiles)
+
+	expected := []string{"file 0;", string(make([]byte, 5000)), "file 1;file 2;", string(make([]byte, 5000))}
+	for i, data := range expected {
+		f := receive(c, replies)
+		c.Assert(f.CorrelationID, Equals, uint64(i+1))
+		c.Assert(f.Flags&frame.FLAG_FILES, Equals, uint16(0))
+		received, err := ioutil.ReadAll(f.Body.NewReader())
+		c.Assert(err, IsNil)
+		c.Assert(string(received), Equals, data)
+		f.Close()
+	}
+
+	//the socket file is removed.
+	c.Assert(server.Shutdown(context.Background()), IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *TransportSuite) Test_Unix_PendingFiles(c *C) {
+	path := filepath.Join(c.MkDir(), "gomsg.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	sender, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	c.Assert(err, IsNil)
+	defer sender.Close()
+	uc, err := l.AcceptUnix()
+	c.Assert(err, IsNil)
+	defer uc.Close()
+	file, err := os.Create(filepath.Join(c.MkDir(), "file"))
+	c.Assert(err, IsNil)
+	defer file.Close()
+
+	//files sent with bytes not starting a frame flagged FLAG_FILES are never taken.
+	r := newFileReceiver(uc)
+	defer r.close()
+	p := make([]byte, 1)
+	for i := 0; i <= maxPendingFiles; i++ {
+		_, _, err = sender.WriteMsgUnix(p, unix.UnixRights(int(file.Fd())), nil)
+		c.Assert(err, IsNil)
+	}
+	for i := 0; i < maxPendingFiles; i++ {
+		n, err := r.Read(p)
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, 1)
+	}
+	_, err = r.Read(p)
+	c.Assert(err, Equals, ErrTooManyFiles)
+	c.Assert(r.pending, Equals, maxPendingFiles)
+
+	//a batch taken makes room for another one.
+	taken := r.take()
+	c.Assert(taken, HasLen, 1)
+	closeFiles(taken)
+	_, _, err = sender.WriteMsgUnix(p, unix.UnixRights(int(file.Fd())), nil)
+	c.Assert(err, IsNil)
+	_, err = r.Read(p)
+	c.Assert(err, IsNil)
+	r.close()
+	c.Assert(r.pending, Equals, 0)
+}
+
+func (s *TransportSuite) Test_Unix_PeerCredentials(c *C) {
+	mp := newProvider()
+	path := filepath.Join(c.MkDir(), "gomsg.sock")
+	peers := make(chan *PeerCredentials, 2)
+	server := newUnixServer(c, mp, path, func(cred *PeerCredentials) error {
+		peers <- cred
+		if len(peers) > 1 {
+			return fmt.Errorf("second peer rejected")
+		}
+		return nil
+	})
+	defer server.Shutdown(context.Background())
+
+	cl, err := DialUnix(path, mp)
+	c.Assert(err, IsNil)
+	defer cl.Close()
+	cred, err := cl.PeerCredentials()
+	c.Assert(err, IsNil)
+	c.Assert(*cred, Equals, PeerCredentials{PID: int32(os.Getpid()), UID: uint32(os.Getuid()), GID: uint32(os.Getgid())})
+	replies := make(chan *frame.Frame, 1)
+	cl.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		replies <- f
+	})
+	c.Assert(cl.Send(echoType, 1, 0, nil), IsNil)
+	receive(c, replies).Close()
+	c.Assert(len(peers), Equals, 1)
+
+	rejected, err := DialUnix(path, mp)
+	c.Assert(err, IsNil)
+	defer rejected.Close()
+	select {
+	case <-rejected.Done():
+	case <-time.After(5 * time.Second):
+		c.Fatal("rejected connection still open")
+	}
+	c.Assert(*<-peers, Equals, *cred)
+
+	tcp := newEchoServer(c, mp)
+	defer tcp.Shutdown(context.Background())
+	tcl, _ := dial(c, tcp, mp)
+	defer tcl.Close()
+	_, err = tcl.PeerCredentials()
+	c.Assert(err, Equals, ErrNotUnix)
+	c.Assert(tcl.SendFiles(echoType, 1, 0, nil, []*os.File{os.Stdin}), Equals, ErrNotUnix)
+}
+
+func (s *TransportSuite) Test_Unix_Files_Telemetry(c *C) {
+	exporter := tracetest.NewInMemoryExporter()
+	reader := sdkmetric.NewManualReader()
+	telemetry := &Telemetry{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		MeterProvider:  sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))}
+	mp := newProvider()
+	path := filepath.Join(c.MkDir(), "gomsg.sock")
+	server := NewServer(mp)
+	server.Telemetry = telemetry
+	server.HandleFunc(filesType, func(conn *Conn, f *frame.Frame) {
+		defer f.Close()
+		data, err := ioutil.ReadAll(f.Files[0])
+		c.Check(err, IsNil)
+		body := mp.NewSegmentProxy()
+		defer body.Close()
+		c.Check(body.WriteMemory(data), IsNil)
+		c.Check(conn.Send(echoType, f.CorrelationID, 0, body), IsNil)
+	})
+	c.Assert(server.ListenUnix(path), IsNil)
+	defer server.Shutdown(context.Background())
+	cl, err := DialUnix(path, mp)
+	c.Assert(err, IsNil)
+	defer cl.Close()
+	cl.SetTelemetry(telemetry)
+	replies := make(chan *frame.Frame, 1)
+	cl.HandleFunc(echoType, func(conn *Conn, f *frame.Frame) {
+		replies <- f
+	})
+	file, err := os.Create(filepath.Join(c.MkDir(), "file"))
+	c.Assert(err, IsNil)
+	defer file.Close()
+	_, err = file.WriteString("traced file")
+	c.Assert(err, IsNil)
+	_, err = file.Seek(0, 0)
+	c.Assert(err, IsNil)
+
+	ctx, root := telemetry.Tracer("test").Start(context.Background(), "root")
+	c.Assert(cl.SendFilesContext(ctx, filesType, 1, 0, nil, []*os.File{file}), IsNil)
+	root.End()
+	f := receive(c, replies)
+	c.Assert(string(f.Body.GetBuffer()), Equals, "traced file")
+	f.Close()
+
+	//the frame carrying the files is sent within a span, whose context reaches the server.
+	var send tracetest.SpanStub
+	for _, span := range waitSpans(c, exporter, 5) {
+		if span.Name == "gomsg.send" && span.Parent.SpanID() == root.SpanContext().SpanID() {
+			send = span
+		}
+	}
+	c.Assert(send.SpanContext.IsValid(), Equals, true)
+	c.Assert(send.Attributes[0], Equals, attribute.Int64("gomsg.message_type", int64(filesType)))
+	received := false
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "gomsg.receive" && span.Parent.SpanID() == send.SpanContext.SpanID() {
+			received = true
+		}
+	}
+	c.Assert(received, Equals, true)
+
+	var rm metricdata.ResourceMetrics
+	c.Assert(reader.Collect(context.Background(), &rm), IsNil)
+	sent := map[int64]int64{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name == "gomsg.transport.frames.sent" {
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				messageType, _ := dp.Attributes.Value("gomsg.message_type")
+				sent[messageType.AsInt64()] = dp.Value
+			}
+		}
+	}
+	c.Assert(sent[int64(filesType)], Equals, int64(1))
+}
@@ -0,0 +1,46 @@
+//go:build !linux
+
+package transport
+
+import (
+	"context"
+	"net"
+	"os"
+
+	"github.com/gomsg/memory"
+)
+
+//fileReceiver is only supported on Linux, Unix domain socket connections don't receive files elsewhere.
+type fileReceiver struct{}
+
+func newFileReceiver(conn *net.UnixConn) *fileReceiver {
+	return nil
+}
+
+func (r *fileReceiver) Read(p []byte) (int, error) {
+	return 0, ErrUnsupported
+}
+
+func (r *fileReceiver) take() []*os.File {
+	return nil
+}
+
+func (r *fileReceiver) close() {}
+
+//SendFiles is only supported on Linux, it fails unless files is empty.
+func (c *Conn) SendFiles(messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer, files []*os.File) error {
+	return c.SendFilesContext(context.Background(), messageType, correlationID, flags, body, files)
+}
+
+//SendFilesContext is only supported on Linux, it fails unless files is empty.
+func (c *Conn) SendFilesContext(ctx context.Context, messageType uint32, correlationID uint64, flags uint16, body memory.MemorySegmentProxyer, files []*os.File) error {
+	if len(files) > 0 {
+		return ErrUnsupported
+	}
+	return c.SendContext(ctx, messageType, correlationID, flags, body)
+}
+
+//PeerCredentials is only supported on Linux.
+func (c *Conn) PeerCredentials() (*PeerCredentials, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,378 @@
+package wal
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomsg/memory"
+)
+
+//Log is an append-only log of records, it's safe for concurrent use.
+type Log struct {
+	dir      string
+	mp       *memory.MemoryProvider
+	opts     Options
+	segments []*segment
+	writer   *bufio.Writer
+	dirty    bool
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+	now      func() time.Time
+	sync.RWMutex
+}
+
+//Open opens the log stored in dir, creating it if needed, records are read into segments borrowed from mp.
+func Open(dir string, mp *memory.MemoryProvider, opts Options) (*Log, error) {
+	o, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, mp: mp, opts: o, done: make(chan struct{}), now: time.Now}
+	for i, base := range bases {
+		s, err := openSegment(dir, base, i == len(bases)-1, o.IndexInterval)
+		if err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+		if i > 0 {
+			l.segments[i-1].next = base
+		}
+		l.segments = append(l.segments, s)
+	}
+	if len(l.segments) == 0 {
+		s, err := createSegment(dir, 0, l.now())
+		if err != nil {
+			return nil, err
+		}
+		if err := syncDir(dir); err != nil {
+			s.close()
+			return nil, err
+		}
+		l.segments = append(l.segments, s)
+	}
+	l.writer = bufio.NewWriterSize(l.active().file, 64*1024)
+	if o.Sync == SYNC_INTERVAL {
+		l.wg.Add(1)
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+//listSegments returns the base offsets of the segments stored in dir, in order.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, logExtension) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, logExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (l *Log) active() *segment {
+	return l.segments[len(l.segments)-1]
+}
+
+//Append writes the data as a new record and returns its offset, the caller keeps the data.
+//Passing nil appends an empty record.
+//
+//With SYNC_ALWAYS the record is synced before Append returns. If the sync fails the record was written
+//all the same and may persist: its offset is returned along with the error, so that a caller retrying
+//can tell it could be appended twice.
+func (l *Log) Append(data memory.MemorySegmentProxyer) (uint64, error) {
+	var buffers [][]byte
+	length := 0
+	if data != nil {
+		buffers = data.Buffers()
+		for _, buf := range buffers {
+			length += len(buf)
+		}
+	}
+	if int64(length) > maxRecordSize {
+		return 0, ErrRecordTooLarge
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return 0, ErrLogClosed
+	}
+	s := l.active()
+	if s.size > 0 && s.size+headerSize+int64(length) > l.opts.SegmentSize {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+		s = l.active()
+	}
+
+	h := recordHeader{length: uint32(length), offset: s.next}
+	var header [headerSize]byte
+	h.encode(header[:])
+	crc := crc32.Update(0, castagnoli, header[4:])
+	for _, buf := range buffers {
+		crc = crc32.Update(crc, castagnoli, buf)
+	}
+	h.checksum = crc
+	h.encode(header[:])
+	if err := l.write(header[:], buffers); err != nil {
+		//drop what was written of the record.
+		l.writer.Reset(s.file)
+		s.file.Truncate(s.size)
+		return 0, err
+	}
+	s.track(h.offset, s.size, l.opts.IndexInterval)
+	s.size += headerSize + int64(length)
+	s.next++
+	s.lastWrite = l.now()
+	l.dirty = true
+	if l.opts.Sync == SYNC_ALWAYS {
+		if err := l.sync(); err != nil {
+			return h.offset, err
+		}
+	}
+	return h.offset, nil
+}
+
+func (l *Log) write(header []byte, buffers [][]byte) error {
+	if _, err := l.writer.Write(header); err != nil {
+		return err
+	}
+	for _, buf := range buffers {
+		if _, err := l.writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	return l.writer.Flush()
+}
+
+//roll seals the active segment and starts a new one.
+func (l *Log) roll() error {
+	s := l.active()
+	if err := l.sync(); err != nil {
+		return err
+	}
+	if err := s.saveIndex(); err != nil {
+		return err
+	}
+	next, err := createSegment(l.dir, s.next, l.now())
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		next.remove()
+		return err
+	}
+	l.segments = append(l.segments, next)
+	l.writer.Reset(next.file)
+	return l.clean()
+}
+
+func (l *Log) sync() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := l.active().file.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+//Sync flushes the records appended so far to stable storage.
+func (l *Log) Sync() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	return l.sync()
+}
+
+func (l *Log) syncLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Sync()
+		case <-l.done:
+			return
+		}
+	}
+}
+
+//Clean applies the retention options now, it's also done every time a segment is sealed.
+//The active segment is never removed.
+func (l *Log) Clean() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	return l.clean()
+}
+
+func (l *Log) clean() error {
+	total := int64(0)
+	for _, s := range l.segments {
+		total += s.size
+	}
+	now := l.now()
+	for len(l.segments) > 1 {
+		oldest := l.segments[0]
+		if !(l.opts.RetentionSize > 0 && total > l.opts.RetentionSize) &&
+			!(l.opts.RetentionAge > 0 && now.Sub(oldest.lastWrite) > l.opts.RetentionAge) {
+			break
+		}
+		if err := oldest.remove(); err != nil {
+			return err
+		}
+		total -= oldest.size
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+//TruncateBefore removes the sealed segments holding only records before offset,
+//e.g. records every consumer is done with. The active segment is never removed.
+func (l *Log) TruncateBefore(offset uint64) error {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return ErrLogClosed
+	}
+	for len(l.segments) > 1 && l.segments[0].next <= offset {
+		if err := l.segments[0].remove(); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+//Read returns the data of the record at offset, the caller MUST close it.
+//It fails with ErrOffsetTruncated if the record was removed by retention and with ErrOffsetOutOfRange
+//if it wasn't appended yet.
+func (l *Log) Read(offset uint64) (memory.MemorySegmentProxyer, error) {
+	l.RLock()
+	defer l.RUnlock()
+	if l.closed {
+		return nil, ErrLogClosed
+	}
+	if offset < l.segments[0].base {
+		return nil, ErrOffsetTruncated
+	}
+	if offset >= l.active().next {
+		return nil, ErrOffsetOutOfRange
+	}
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].base > offset }) - 1
+	return l.segments[i].read(l.mp, offset)
+}
+
+//FirstOffset returns the offset of the oldest record kept.
+func (l *Log) FirstOffset() uint64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.segments[0].base
+}
+
+//NextOffset returns the offset the next appended record gets.
+func (l *Log) NextOffset() uint64 {
+	l.RLock()
+	defer l.RUnlock()
+	return l.active().next
+}
+
+//Close syncs the log and closes its files.
+func (l *Log) Close() error {
+	l.Lock()
+	if l.closed {
+		l.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	l.Unlock()
+	l.wg.Wait()
+
+	l.Lock()
+	defer l.Unlock()
+	err := l.sync()
+	if err == nil {
+		err = l.active().saveIndex()
+	}
+	l.closeSegments()
+	return err
+}
+
+func (l *Log) closeSegments() {
+	for _, s := range l.segments {
+		s.close()
+	}
+}
+
+//Reader reads the records of a log one after the other.
+type Reader struct {
+	log    *Log
+	offset uint64
+}
+
+//NewReader returns a reader starting at offset.
+func (l *Log) NewReader(offset uint64) *Reader {
+	return &Reader{log: l, offset: offset}
+}
+
+//Offset returns the offset of the next record read.
+func (r *Reader) Offset() uint64 {
+	return r.offset
+}
+
+//Next returns the next record and its offset, the caller MUST close it.
+//It returns io.EOF once every record appended so far was read, Next can be called again after more appends.
+func (r *Reader) Next() (uint64, memory.MemorySegmentProxyer, error) {
+	if r.offset >= r.log.NextOffset() {
+		return r.offset, nil, io.EOF
+	}
+	data, err := r.log.Read(r.offset)
+	if err != nil {
+		return r.offset, nil, err
+	}
+	r.offset++
+	return r.offset - 1, data, nil
+}
@@ -0,0 +1,230 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gomsg/memory"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logExtension   = ".log"
+	indexExtension = ".index"
+	indexEntrySize = 8
+)
+
+//indexEntry maps an offset, relative to the base of its segment, to the position of its record.
+type indexEntry struct {
+	offset   uint32
+	position uint32
+}
+
+type segment struct {
+	dir       string
+	base      uint64
+	next      uint64
+	file      *os.File
+	size      int64
+	index     []indexEntry
+	lastWrite time.Time
+}
+
+func segmentPath(dir string, base uint64, extension string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, extension))
+}
+
+func createSegment(dir string, base uint64, now time.Time) (*segment, error) {
+	file, err := os.OpenFile(segmentPath(dir, base, logExtension), os.O_CREATE|os.O_EXCL|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &segment{dir: dir, base: base, next: base, file: file, lastWrite: now}, nil
+}
+
+//openSegment opens an existing segment, the last one is validated and truncated after its last valid record,
+//the index of a sealed one is loaded if it was saved.
+func openSegment(dir string, base uint64, last bool, indexInterval int64) (*segment, error) {
+	file, err := os.OpenFile(segmentPath(dir, base, logExtension), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := &segment{dir: dir, base: base, next: base, file: file, size: info.Size(), lastWrite: info.ModTime()}
+	if !last && s.loadIndex() {
+		return s, nil
+	}
+	if err := s.scan(indexInterval, last); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//scan reads every record for rebuilding the index, it stops at the first torn or corrupted record
+//and truncates the segment there if asked.
+func (s *segment) scan(indexInterval int64, truncate bool) error {
+	r := bufio.NewReaderSize(io.NewSectionReader(s.file, 0, s.size), 64*1024)
+	s.index = nil
+	s.next = s.base
+	var buf [headerSize]byte
+	position := int64(0)
+	for position < s.size {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			break
+		}
+		h := decodeHeader(buf[:])
+		if h.offset != s.next || position+headerSize+int64(h.length) > s.size {
+			break
+		}
+		crc := crc32.New(castagnoli)
+		crc.Write(buf[4:])
+		if _, err := io.CopyN(crc, r, int64(h.length)); err != nil {
+			return err
+		}
+		if crc.Sum32() != h.checksum {
+			break
+		}
+		s.track(h.offset, position, indexInterval)
+		position += headerSize + int64(h.length)
+		s.next++
+	}
+	if position < s.size {
+		log.Warnf("Segment %s is corrupted after offset %d, position %d.", s.file.Name(), s.next, position)
+		if truncate {
+			if err := s.file.Truncate(position); err != nil {
+				return err
+			}
+			s.size = position
+		}
+	}
+	return nil
+}
+
+//track indexes the record written at position if it's far enough from the last indexed one.
+func (s *segment) track(offset uint64, position int64, indexInterval int64) {
+	if len(s.index) == 0 || position-int64(s.index[len(s.index)-1].position) >= indexInterval {
+		s.index = append(s.index, indexEntry{offset: uint32(offset - s.base), position: uint32(position)})
+	}
+}
+
+//loadIndex loads the saved index, it returns false if it's missing or doesn't fit the segment.
+func (s *segment) loadIndex() bool {
+	data, err := os.ReadFile(segmentPath(s.dir, s.base, indexExtension))
+	if err != nil || len(data)%indexEntrySize != 0 || (len(data) == 0) != (s.size == 0) {
+		return false
+	}
+	index := make([]indexEntry, 0, len(data)/indexEntrySize)
+	for i := 0; i < len(data); i += indexEntrySize {
+		e := indexEntry{
+			offset:   binary.LittleEndian.Uint32(data[i:]),
+			position: binary.LittleEndian.Uint32(data[i+4:])}
+		if int64(e.position) >= s.size || (len(index) > 0 && e.offset <= index[len(index)-1].offset) {
+			return false
+		}
+		index = append(index, e)
+	}
+	s.index = index
+	return true
+}
+
+//saveIndex writes the index atomically next to the segment.
+func (s *segment) saveIndex() error {
+	data := make([]byte, len(s.index)*indexEntrySize)
+	for i, e := range s.index {
+		binary.LittleEndian.PutUint32(data[i*indexEntrySize:], e.offset)
+		binary.LittleEndian.PutUint32(data[i*indexEntrySize+4:], e.position)
+	}
+	path := segmentPath(s.dir, s.base, indexExtension)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//locate returns the position and the header of the record at offset.
+func (s *segment) locate(offset uint64) (int64, recordHeader, error) {
+	relative := uint32(offset - s.base)
+	i := sort.Search(len(s.index), func(i int) bool { return s.index[i].offset > relative }) - 1
+	if i < 0 {
+		return 0, recordHeader{}, ErrCorrupt
+	}
+	var buf [headerSize]byte
+	position := int64(s.index[i].position)
+	for {
+		if _, err := s.file.ReadAt(buf[:], position); err != nil {
+			return 0, recordHeader{}, ErrCorrupt
+		}
+		h := decodeHeader(buf[:])
+		if h.offset == offset {
+			return position, h, nil
+		}
+		if h.offset > offset {
+			return 0, recordHeader{}, ErrCorrupt
+		}
+		position += headerSize + int64(h.length)
+	}
+}
+
+//read copies the data of the record at offset into segments borrowed from mp, validating its checksum.
+func (s *segment) read(mp *memory.MemoryProvider, offset uint64) (memory.MemorySegmentProxyer, error) {
+	position, h, err := s.locate(offset)
+	if err != nil {
+		return nil, err
+	}
+	var buf [headerSize]byte
+	h.encode(buf[:])
+	crc := crc32.New(castagnoli)
+	crc.Write(buf[4:])
+	msp := mp.NewSegmentProxy()
+	msp.SetHash(crc)
+	_, err = msp.FillFrom(io.NewSectionReader(s.file, position+headerSize, int64(h.length)), uint(h.length))
+	msp.SetHash(nil)
+	if err == io.ErrUnexpectedEOF || (err == nil && crc.Sum32() != h.checksum) {
+		err = ErrCorrupt
+	}
+	if err != nil {
+		msp.Close()
+		return nil, err
+	}
+	return msp, nil
+}
+
+func (s *segment) close() error {
+	return s.file.Close()
+}
+
+//remove deletes the files of the segment.
+func (s *segment) remove() error {
+	s.file.Close()
+	if err := os.Remove(segmentPath(s.dir, s.base, logExtension)); err != nil {
+		return err
+	}
+	if err := os.Remove(segmentPath(s.dir, s.base, indexExtension)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+//Package wal implements a durable append-only log of records stored in segment files.
+//
+//Every record gets the next offset of the log, starting from 0. A segment file holds the records
+//from its base offset, which names the file, e.g. 00000000000000001024.log, and is sealed once it
+//grows past Options.SegmentSize. A record is stored as:
+//
+//	size      field
+//	4         CRC32C of the following fields and the data, little endian
+//	4         length of the data, little endian
+//	8         offset, little endian
+//	          data
+//
+//Each segment has a sparse index mapping offsets to file positions, kept in memory and saved next to
+//the segment, e.g. 00000000000000001024.index, when it's sealed. On Open the records of the last segment
+//are validated and the log is truncated at the first torn or corrupted record.
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+var (
+	ErrLogClosed        = fmt.Errorf("wal: log closed.")
+	ErrOffsetOutOfRange = fmt.Errorf("wal: offset out of range.")
+	ErrOffsetTruncated  = fmt.Errorf("wal: offset removed by retention.")
+	ErrCorrupt          = fmt.Errorf("wal: corrupted record.")
+	ErrRecordTooLarge   = fmt.Errorf("wal: record too large.")
+	ErrInvalidOptions   = fmt.Errorf("wal: invalid options.")
+)
+
+//SyncPolicy tells when appended records are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	//SYNC_ALWAYS syncs every append before returning, it's the default.
+	SYNC_ALWAYS SyncPolicy = iota
+	//SYNC_INTERVAL syncs in the background every Options.SyncInterval,
+	//a crash loses at most the records appended meanwhile.
+	SYNC_INTERVAL
+	//SYNC_NEVER leaves flushing to the operating system, Sync and Close still sync.
+	SYNC_NEVER
+)
+
+const (
+	DefaultSegmentSize   int64 = 64 * 1024 * 1024
+	DefaultIndexInterval int64 = 4 * 1024
+	DefaultSyncInterval        = time.Second
+)
+
+//Options of a log, zero values take the defaults.
+type Options struct {
+	//SegmentSize is the size past which the active segment is sealed, up to 4GB.
+	SegmentSize int64
+	//IndexInterval is how many bytes of records at most sit between two index entries.
+	IndexInterval int64
+	Sync          SyncPolicy
+	SyncInterval  time.Duration
+	//RetentionSize removes the oldest sealed segments while the log is larger, 0 keeps them all.
+	RetentionSize int64
+	//RetentionAge removes the sealed segments not written for longer, 0 keeps them all.
+	RetentionAge time.Duration
+}
+
+func (o *Options) withDefaults() (Options, error) {
+	opts := *o
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.IndexInterval == 0 {
+		opts.IndexInterval = DefaultIndexInterval
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if opts.SegmentSize < 0 || opts.SegmentSize > maxSegmentSize || opts.IndexInterval < 0 ||
+		opts.SyncInterval < 0 || opts.RetentionSize < 0 || opts.RetentionAge < 0 ||
+		opts.Sync < SYNC_ALWAYS || opts.Sync > SYNC_NEVER {
+		return opts, ErrInvalidOptions
+	}
+	return opts, nil
+}
+
+const (
+	headerSize = 16
+	//positions are saved in the index as 4 bytes.
+	maxSegmentSize = 1<<32 - 1
+	maxRecordSize  = 1<<32 - 1
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type recordHeader struct {
+	checksum uint32
+	length   uint32
+	offset   uint64
+}
+
+func (h *recordHeader) encode(buf []byte) {
+	binary.LittleEndian.PutUint32(buf, h.checksum)
+	binary.LittleEndian.PutUint32(buf[4:], h.length)
+	binary.LittleEndian.PutUint64(buf[8:], h.offset)
+}
+
+func decodeHeader(buf []byte) recordHeader {
+	return recordHeader{
+		checksum: binary.LittleEndian.Uint32(buf),
+		length:   binary.LittleEndian.Uint32(buf[4:]),
+		offset:   binary.LittleEndian.Uint64(buf[8:])}
+}
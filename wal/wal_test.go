@@ -0,0 +1,281 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomsg/memory"
+	. "gopkg.in/check.v1"
+)
+
+//Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type WALSuite struct{}
+
+var _ = Suite(&WALSuite{})
+
+func newProvider() *memory.MemoryProvider {
+	mp := &memory.MemoryProvider{}
+	mp.Initialize(4*1024*1024, 256)
+	return mp
+}
+
+func record(i int) string {
+	return fmt.Sprintf("record %d %s", i, strings.Repeat("x", i%300))
+}
+
+func appendRecords(c *C, l *Log, mp *memory.MemoryProvider, from, to int) {
+	for i := from; i < to; i++ {
+		msp := mp.NewSegmentProxy()
+		c.Assert(msp.WriteMemory([]byte(record(i))), IsNil)
+		offset, err := l.Append(msp)
+		msp.Close()
+		c.Assert(err, IsNil)
+		c.Assert(offset, Equals, uint64(i))
+	}
+}
+
+func readRecord(c *C, l *Log, offset uint64) (string, error) {
+	msp, err := l.Read(offset)
+	if err != nil {
+		return "", err
+	}
+	defer msp.Close()
+	data, err := io.ReadAll(msp.NewReader())
+	c.Assert(err, IsNil)
+	return string(data), nil
+}
+
+func segmentFiles(c *C, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	c.Assert(err, IsNil)
+	return files
+}
+
+func (s *WALSuite) Test_Append_Read(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	l, err := Open(dir, mp, Options{SegmentSize: 4096, IndexInterval: 512})
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	appendRecords(c, l, mp, 0, 200)
+	c.Assert(len(segmentFiles(c, dir)) > 5, Equals, true)
+	for i := 199; i >= 0; i-- {
+		data, err := readRecord(c, l, uint64(i))
+		c.Assert(err, IsNil)
+		c.Assert(data, Equals, record(i))
+	}
+	_, err = l.Read(200)
+	c.Assert(err, Equals, ErrOffsetOutOfRange)
+
+	offset, err := l.Append(nil)
+	c.Assert(err, IsNil)
+	c.Assert(offset, Equals, uint64(200))
+	data, err := readRecord(c, l, 200)
+	c.Assert(err, IsNil)
+	c.Assert(data, Equals, "")
+	c.Assert(l.FirstOffset(), Equals, uint64(0))
+	c.Assert(l.NextOffset(), Equals, uint64(201))
+}
+
+func (s *WALSuite) Test_Reopen(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	opts := Options{SegmentSize: 4096, IndexInterval: 512, Sync: SYNC_NEVER}
+	l, err := Open(dir, mp, opts)
+	c.Assert(err, IsNil)
+	appendRecords(c, l, mp, 0, 100)
+	c.Assert(l.Close(), IsNil)
+	c.Assert(l.Close(), IsNil)
+	_, err = l.Append(nil)
+	c.Assert(err, Equals, ErrLogClosed)
+
+	//a missing index is rebuilt.
+	files := segmentFiles(c, dir)
+	c.Assert(os.Remove(strings.TrimSuffix(files[1], ".log")+".index"), IsNil)
+
+	l, err = Open(dir, mp, opts)
+	c.Assert(err, IsNil)
+	defer l.Close()
+	c.Assert(l.NextOffset(), Equals, uint64(100))
+	appendRecords(c, l, mp, 100, 150)
+	for i := 0; i < 150; i++ {
+		data, err := readRecord(c, l, uint64(i))
+		c.Assert(err, IsNil)
+		c.Assert(data, Equals, record(i))
+	}
+}
+
+func (s *WALSuite) Test_Recover_TornTail(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	l, err := Open(dir, mp, Options{})
+	c.Assert(err, IsNil)
+	appendRecords(c, l, mp, 0, 10)
+	c.Assert(l.Close(), IsNil)
+
+	//the last record was only partly written.
+	files := segmentFiles(c, dir)
+	path := files[len(files)-1]
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(os.Truncate(path, info.Size()-3), IsNil)
+
+	l, err = Open(dir, mp, Options{})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	c.Assert(l.NextOffset(), Equals, uint64(9))
+	_, err = l.Read(9)
+	c.Assert(err, Equals, ErrOffsetOutOfRange)
+	appendRecords(c, l, mp, 9, 12)
+	for i := 0; i < 12; i++ {
+		data, err := readRecord(c, l, uint64(i))
+		c.Assert(err, IsNil)
+		c.Assert(data, Equals, record(i))
+	}
+}
+
+//corrupt flips a byte of the data of the record at offset.
+func corrupt(c *C, l *Log, offset uint64) {
+	for _, s := range l.segments {
+		if offset >= s.base && offset < s.next {
+			position, _, err := s.locate(offset)
+			c.Assert(err, IsNil)
+			f, err := os.OpenFile(s.file.Name(), os.O_RDWR, 0)
+			c.Assert(err, IsNil)
+			defer f.Close()
+			var b [1]byte
+			_, err = f.ReadAt(b[:], position+headerSize+2)
+			c.Assert(err, IsNil)
+			b[0] ^= 0xff
+			_, err = f.WriteAt(b[:], position+headerSize+2)
+			c.Assert(err, IsNil)
+			return
+		}
+	}
+	c.Fatalf("offset %d not found", offset)
+}
+
+func (s *WALSuite) Test_Recover_Corrupt(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	opts := Options{SegmentSize: 4096}
+	l, err := Open(dir, mp, opts)
+	c.Assert(err, IsNil)
+	appendRecords(c, l, mp, 0, 100)
+	last := l.active().base
+	corrupt(c, l, 3)
+	corrupt(c, l, last+1)
+
+	//a sealed segment reports the corrupted record.
+	_, err = readRecord(c, l, 3)
+	c.Assert(err, Equals, ErrCorrupt)
+	data, err := readRecord(c, l, 4)
+	c.Assert(err, IsNil)
+	c.Assert(data, Equals, record(4))
+	c.Assert(l.Close(), IsNil)
+
+	//the last segment is truncated at the corrupted record.
+	l, err = Open(dir, mp, opts)
+	c.Assert(err, IsNil)
+	defer l.Close()
+	c.Assert(l.NextOffset(), Equals, last+1)
+	_, err = readRecord(c, l, 3)
+	c.Assert(err, Equals, ErrCorrupt)
+}
+
+func (s *WALSuite) Test_Retention_Size(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	l, err := Open(dir, mp, Options{SegmentSize: 4096, RetentionSize: 3 * 4096})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	appendRecords(c, l, mp, 0, 300)
+	c.Assert(len(segmentFiles(c, dir)) <= 4, Equals, true)
+	first := l.FirstOffset()
+	c.Assert(first > 0, Equals, true)
+	_, err = l.Read(first - 1)
+	c.Assert(err, Equals, ErrOffsetTruncated)
+	data, err := readRecord(c, l, first)
+	c.Assert(err, IsNil)
+	c.Assert(data, Equals, record(int(first)))
+}
+
+func (s *WALSuite) Test_Retention_Age(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	l, err := Open(dir, mp, Options{SegmentSize: 4096, RetentionAge: time.Hour})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	appendRecords(c, l, mp, 0, 300)
+	count := len(segmentFiles(c, dir))
+	c.Assert(count > 2, Equals, true)
+
+	now = now.Add(30 * time.Minute)
+	c.Assert(l.Clean(), IsNil)
+	c.Assert(len(segmentFiles(c, dir)), Equals, count)
+
+	//every sealed segment expired, the active one is kept.
+	now = now.Add(time.Hour)
+	c.Assert(l.Clean(), IsNil)
+	c.Assert(len(segmentFiles(c, dir)), Equals, 1)
+	c.Assert(l.FirstOffset(), Equals, l.active().base)
+}
+
+func (s *WALSuite) Test_TruncateBefore(c *C) {
+	mp := newProvider()
+	dir := c.MkDir()
+	l, err := Open(dir, mp, Options{SegmentSize: 4096})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	appendRecords(c, l, mp, 0, 100)
+	second := l.segments[1].base
+	c.Assert(l.TruncateBefore(second-1), IsNil)
+	c.Assert(l.FirstOffset(), Equals, uint64(0))
+	c.Assert(l.TruncateBefore(second), IsNil)
+	c.Assert(l.FirstOffset(), Equals, second)
+	c.Assert(l.TruncateBefore(1000), IsNil)
+	c.Assert(len(segmentFiles(c, dir)), Equals, 1)
+	c.Assert(l.NextOffset(), Equals, uint64(100))
+}
+
+func (s *WALSuite) Test_Reader(c *C) {
+	mp := newProvider()
+	l, err := Open(c.MkDir(), mp, Options{SegmentSize: 4096, Sync: SYNC_INTERVAL, SyncInterval: time.Millisecond})
+	c.Assert(err, IsNil)
+	defer l.Close()
+	appendRecords(c, l, mp, 0, 20)
+
+	r := l.NewReader(5)
+	for i := 5; i < 20; i++ {
+		offset, msp, err := r.Next()
+		c.Assert(err, IsNil)
+		c.Assert(offset, Equals, uint64(i))
+		msp.Close()
+	}
+	_, _, err = r.Next()
+	c.Assert(err, Equals, io.EOF)
+	appendRecords(c, l, mp, 20, 21)
+	offset, msp, err := r.Next()
+	c.Assert(err, IsNil)
+	c.Assert(offset, Equals, uint64(20))
+	msp.Close()
+	c.Assert(r.Offset(), Equals, uint64(21))
+}
+
+func (s *WALSuite) Test_Options(c *C) {
+	mp := newProvider()
+	for _, opts := range []Options{{SegmentSize: -1}, {SegmentSize: 1 << 33}, {Sync: SYNC_NEVER + 1}, {RetentionAge: -time.Second}} {
+		_, err := Open(c.MkDir(), mp, opts)
+		c.Check(err, Equals, ErrInvalidOptions, Commentf("%+v", opts))
+	}
+}